@@ -0,0 +1,89 @@
+package main
+
+import (
+	ui "github.com/VladimirMarkelov/clui"
+	cf "github.com/VladimirMarkelov/termfb2/config"
+)
+
+// runReaderAction executes the reader action bound to a pressed key. It
+// returns false if the key must be processed by the reader widget
+func runReaderAction(controls *ControlList, conf *cf.Config, action string) bool {
+	_, height := controls.reader.Size()
+	switch action {
+	case cf.ActLineDown:
+		scrollReader(controls, conf, 1)
+	case cf.ActLineUp:
+		scrollReader(controls, conf, -1)
+	case cf.ActPageDown:
+		scrollReader(controls, conf, height)
+	case cf.ActPageUp:
+		scrollReader(controls, conf, -height)
+	case cf.ActHalfPageDown:
+		scrollReader(controls, conf, height/2)
+	case cf.ActHalfPageUp:
+		scrollReader(controls, conf, -height/2)
+	case cf.ActTop:
+		scrollReader(controls, conf, -len(conf.Lines))
+	case cf.ActBottom:
+		scrollReader(controls, conf, len(conf.Lines))
+	case cf.ActLibrary:
+		if !conf.UseDb {
+			return false
+		}
+		createBookListDialog(controls, conf)
+	case cf.ActNextLink:
+		selectNextLink(controls, conf)
+	case cf.ActFollowLink:
+		followLink(controls, conf)
+	case cf.ActBack:
+		returnFromLink(controls, conf)
+	case cf.ActSearch:
+		createSearchDialog(controls, conf)
+	case cf.ActNextMatch:
+		jumpToMatch(controls, conf, true)
+	case cf.ActPrevMatch:
+		jumpToMatch(controls, conf, false)
+	case cf.ActClearSearch:
+		if conf.SearchText == "" {
+			return false
+		}
+		clearSearch(controls, conf)
+	case cf.ActAddBookmark:
+		addBookmark(controls, conf)
+	case cf.ActBookmarks:
+		createBookmarkDialog(controls, conf)
+	case cf.ActToc:
+		createTocDialog(controls, conf)
+	case cf.ActStats:
+		if !conf.UseDb {
+			return false
+		}
+		createStatsDialog(controls, conf)
+	case cf.ActQuit:
+		ui.Stop()
+	case cf.ActHelp:
+		createHelpDialog(conf, cf.CtxReader)
+	default:
+		return false
+	}
+	return true
+}
+
+// scrollReader moves the top line of the reader by delta lines. The
+// scrolling stops when the last page of the book is displayed
+func scrollReader(controls *ControlList, conf *cf.Config, delta int) {
+	_, height := controls.reader.Size()
+	current := controls.reader.TopLine()
+	top := current + delta
+	if last := len(conf.Lines) - height; delta > 0 && top > last {
+		top = last
+		// the position can be restored beyond the last page
+		if top < current {
+			top = current
+		}
+	}
+	if top < 0 {
+		top = 0
+	}
+	controls.reader.SetTopLine(top)
+}
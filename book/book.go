@@ -0,0 +1,190 @@
+package book
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Info is a short book description extracted from a book file
+type Info struct {
+	FirstName string
+	LastName  string
+	Title     string
+	Sequence  string
+	// number of the book in the sequence, 0 if it is unknown
+	SeqNumber int
+	Language  string
+	Genre     string
+	// unique id of the book file: FB2 document id or EPUB identifier.
+	// It does not change when the file is renamed
+	Id string
+}
+
+// sequenceNumber parses the number of a book in its sequence. FB2 uses
+// integer numbers, EPUB series index can be a float like "2.0"
+func sequenceNumber(value string) int {
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return int(n)
+}
+
+// SequenceText shows a sequence name with the number of the book in it,
+// e.g. "Noon Universe #3". The number is omitted if it is unknown
+func SequenceText(name string, number int) string {
+	if name == "" || number == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s #%d", name, number)
+}
+
+// Kind is a type of a paragraph. It defines how the paragraph is formatted
+type Kind int
+
+const (
+	// KindText is a regular text paragraph
+	KindText Kind = iota
+	// KindTitle is a line of a book, section or notes title
+	KindTitle
+	// KindSubtitle is a section subtitle
+	KindSubtitle
+	// KindEmpty is an empty line between paragraphs
+	KindEmpty
+	// KindEpigraph is a paragraph of an epigraph
+	KindEpigraph
+	// KindPoem is a line of a poem
+	KindPoem
+)
+
+// Style is a set of inline text styles
+type Style int
+
+const (
+	StyleStrong Style = 1 << iota
+	StyleEmphasis
+	StyleStrike
+	StyleCode
+)
+
+// Span is a part of a paragraph text displayed with a given style.
+// Start and End are rune offsets inside paragraph text
+type Span struct {
+	Start int
+	End   int
+	Style Style
+}
+
+// Link is a reference to another place of the book (e.g, a footnote).
+// Start and End are rune offsets inside paragraph text
+type Link struct {
+	Start  int
+	End    int
+	Target string
+}
+
+// Paragraph is a single piece of book text that is formatted as a whole
+type Paragraph struct {
+	Kind  Kind
+	Text  string
+	Links []Link
+	Spans []Span
+}
+
+// StyleAt returns all styles of the character at offset
+func (p *Paragraph) StyleAt(offset int) Style {
+	var st Style
+	for _, span := range p.Spans {
+		if offset >= span.Start && offset < span.End {
+			st |= span.Style
+		}
+	}
+	return st
+}
+
+// TocItem is a single entry of the book table of contents
+type TocItem struct {
+	Title string
+	// nesting level of the section, 0 is for a body title
+	Level int
+	// index of the first paragraph of the section title
+	Para int
+}
+
+// Book is a parsed book: its description and all its paragraphs, including
+// ones from the notes and comments bodies
+type Book struct {
+	Info       Info
+	Paragraphs []Paragraph
+	// Anchors maps section id (a link target) to the index of the first
+	// paragraph of the section
+	Anchors map[string]int
+	// table of contents built from section titles
+	Toc []TocItem
+	// index of the first paragraph of the notes and comments bodies,
+	// -1 if the book does not have them
+	NotesPara int
+}
+
+// Chapter returns the index of the table of contents item the paragraph
+// belongs to. It returns -1 if the paragraph is before the first item
+func (b *Book) Chapter(para int) int {
+	idx := -1
+	for i, item := range b.Toc {
+		if item.Para > para {
+			break
+		}
+		idx = i
+	}
+	return idx
+}
+
+// Position is a place in a book that does not depend on the way the book
+// is formatted: index of a paragraph and rune offset inside the paragraph
+type Position struct {
+	Para   int
+	Offset int
+}
+
+// Less returns true if the position is before the other one
+func (p Position) Less(other Position) bool {
+	return p.Para < other.Para || (p.Para == other.Para && p.Offset < other.Offset)
+}
+
+// TextEnd returns the position right after the main text of the book.
+// Notes and comments bodies are not included
+func (b *Book) TextEnd() Position {
+	if b.NotesPara >= 0 {
+		return Position{Para: b.NotesPara}
+	}
+	return Position{Para: len(b.Paragraphs)}
+}
+
+// CountWords returns the number of words between two positions. A word
+// that is split by a position is counted in both parts
+func (b *Book) CountWords(from, to Position) int {
+	words := 0
+	for p := from.Para; p <= to.Para && p < len(b.Paragraphs); p++ {
+		if p < 0 {
+			continue
+		}
+		text := b.Paragraphs[p].Text
+		if p == from.Para || p == to.Para {
+			runes := []rune(text)
+			start, end := 0, len(runes)
+			if p == from.Para && from.Offset > 0 {
+				start = from.Offset
+			}
+			if p == to.Para && to.Offset < end {
+				end = to.Offset
+			}
+			if start >= end {
+				continue
+			}
+			text = string(runes[start:end])
+		}
+		words += len(strings.Fields(text))
+	}
+	return words
+}
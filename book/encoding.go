@@ -0,0 +1,114 @@
+package book
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// decodeBOM converts UTF-16 text to UTF-8 if the text starts with BOM.
+// It returns true if the text has been converted
+func decodeBOM(data []byte) ([]byte, bool, error) {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return data[len(bomUTF8):], true, nil
+	case bytes.HasPrefix(data, bomUTF16LE):
+		res, err := unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder().Bytes(data)
+		return res, true, err
+	case bytes.HasPrefix(data, bomUTF16BE):
+		res, err := unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder().Bytes(data)
+		return res, true, err
+	}
+
+	return data, false, nil
+}
+
+// decodeText converts text in the given encoding to UTF-8
+func decodeText(data []byte, label string) ([]byte, error) {
+	enc, err := htmlindex.Get(strings.ToLower(strings.TrimSpace(label)))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported encoding '%s'", label)
+	}
+	return enc.NewDecoder().Bytes(data)
+}
+
+// cyrillicScore returns how much the text looks like a Russian one: the
+// number of lowercase Cyrillic letters minus the number of uppercase ones.
+// A text decoded with a wrong Cyrillic encoding has mostly uppercase letters
+func cyrillicScore(text []byte) int {
+	score := 0
+	for _, r := range string(text) {
+		if (r >= 'а' && r <= 'я') || r == 'ё' {
+			score++
+		} else if (r >= 'А' && r <= 'Я') || r == 'Ё' {
+			score--
+		}
+	}
+	return score
+}
+
+// textToUTF8 converts a plain text of unknown encoding to UTF-8. UTF-8 and
+// UTF-16 with BOM are detected reliably. For other texts the Cyrillic
+// encoding that produces the most Russian-like text wins. If the text does
+// not look like Russian in any encoding, it is decoded as windows-1252
+func textToUTF8(data []byte) ([]byte, error) {
+	if res, decoded, err := decodeBOM(data); decoded || err != nil {
+		return res, err
+	}
+	if utf8.Valid(data) {
+		return data, nil
+	}
+
+	best, bestScore := "windows-1252", len(data)/5
+	for _, label := range []string{"windows-1251", "koi8-r"} {
+		text, err := decodeText(data, label)
+		if err != nil {
+			continue
+		}
+		if score := cyrillicScore(text); score > bestScore {
+			best, bestScore = label, score
+		}
+	}
+	return decodeText(data, best)
+}
+
+// newXMLDecoder creates a decoder that converts the text to UTF-8 using
+// encoding from the XML declaration. Encodings supported by web browsers
+// are available: windows-125x, KOI8-R, KOI8-U, ISO-8859-x, and UTF-16.
+// Decoding fails if the declaration contains an unknown encoding
+func newXMLDecoder(data []byte) (*xml.Decoder, error) {
+	data, decoded, err := decodeBOM(data)
+	if err != nil {
+		return nil, err
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	d.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		label = strings.ToLower(strings.TrimSpace(label))
+		// text with BOM is already in UTF-8
+		if decoded || label == "utf-8" || label == "utf8" {
+			return input, nil
+		}
+
+		enc, err := htmlindex.Get(label)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported encoding '%s'", label)
+		}
+		return enc.NewDecoder().Reader(input), nil
+	}
+
+	return d, nil
+}
@@ -0,0 +1,129 @@
+package book
+
+import (
+	"encoding/xml"
+	"golang.org/x/text/encoding/htmlindex"
+	"io"
+	"testing"
+)
+
+const (
+	russian = "Пикник на обочине. Аркадий и Борис Стругацкие"
+	french  = "Le café était très bon, merci beaucoup"
+)
+
+// encode converts UTF-8 text to the encoding for tests
+func encode(t *testing.T, text, label string) []byte {
+	enc, err := htmlindex.Get(label)
+	if err != nil {
+		t.Fatalf("unknown encoding %s: %v", label, err)
+	}
+	data, err := enc.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatalf("failed to encode text to %s: %v", label, err)
+	}
+	return data
+}
+
+func TestDecodeBOM(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		text    string
+		decoded bool
+	}{
+		{"utf-8", append([]byte{0xEF, 0xBB, 0xBF}, russian...), russian, true},
+		{"utf-16le", []byte{0xFF, 0xFE, 0x1F, 0x04, 0x38, 0x04, 0x20, 0x00, 'a', 0x00}, "Пи a", true},
+		{"utf-16be", []byte{0xFE, 0xFF, 0x04, 0x1F, 0x04, 0x38, 0x00, 0x20, 0x00, 'a'}, "Пи a", true},
+		{"no bom", []byte(russian), russian, false},
+		{"empty", []byte{}, "", false},
+	}
+	for _, test := range tests {
+		data, decoded, err := decodeBOM(test.data)
+		if err != nil {
+			t.Errorf("%s: decodeBOM failed: %v", test.name, err)
+			continue
+		}
+		if decoded != test.decoded || string(data) != test.text {
+			t.Errorf("%s: decodeBOM = %q, %v, want %q, %v", test.name, data, decoded, test.text, test.decoded)
+		}
+	}
+}
+
+func TestTextToUTF8(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		text string
+	}{
+		{"utf-8", []byte(russian), russian},
+		{"utf-8 bom", append([]byte{0xEF, 0xBB, 0xBF}, russian...), russian},
+		{"utf-16le", []byte{0xFF, 0xFE, 0x1F, 0x04, 0x38, 0x04}, "Пи"},
+		{"windows-1251", encode(t, russian, "windows-1251"), russian},
+		{"koi8-r", encode(t, russian, "koi8-r"), russian},
+		{"windows-1252", encode(t, french, "windows-1252"), french},
+	}
+	for _, test := range tests {
+		text, err := textToUTF8(test.data)
+		if err != nil {
+			t.Errorf("%s: textToUTF8 failed: %v", test.name, err)
+			continue
+		}
+		if string(text) != test.text {
+			t.Errorf("%s: textToUTF8 = %q, want %q", test.name, text, test.text)
+		}
+	}
+}
+
+// xmlText returns all character data of the XML document
+func xmlText(data []byte) (string, error) {
+	d, err := newXMLDecoder(data)
+	if err != nil {
+		return "", err
+	}
+	text := ""
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				return text, nil
+			}
+			return text, err
+		}
+		if cd, ok := tok.(xml.CharData); ok {
+			text += string(cd)
+		}
+	}
+}
+
+func TestNewXMLDecoder(t *testing.T) {
+	doc := func(enc string) string {
+		return `<?xml version="1.0" encoding="` + enc + `"?><p>` + russian + `</p>`
+	}
+	utf16 := []byte{0xFF, 0xFE}
+	for _, r := range `<?xml version="1.0" encoding="UTF-16"?><p>Пи</p>` {
+		utf16 = append(utf16, byte(r), byte(r>>8))
+	}
+	tests := []struct {
+		name  string
+		data  []byte
+		text  string
+		fails bool
+	}{
+		{"utf-8", []byte(doc("UTF-8")), russian, false},
+		{"windows-1251", encode(t, doc("windows-1251"), "windows-1251"), russian, false},
+		{"koi8-r", encode(t, doc("KOI8-R"), "koi8-r"), russian, false},
+		{"utf-16 with bom", utf16, "Пи", false},
+		{"unknown encoding", []byte(doc("x-unknown")), "", true},
+	}
+	for _, test := range tests {
+		text, err := xmlText(test.data)
+		if (err != nil) != test.fails {
+			t.Errorf("%s: decoding error = %v, want failure %v", test.name, err, test.fails)
+			continue
+		}
+		if !test.fails && text != test.text {
+			t.Errorf("%s: decoded text %q, want %q", test.name, text, test.text)
+		}
+	}
+}
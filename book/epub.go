@@ -0,0 +1,186 @@
+package book
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const epubContainer = "META-INF/container.xml"
+
+// epubContainerXML is the content of META-INF/container.xml that points
+// to the book package file
+type epubContainerXML struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// epubPackage is the content of OPF file: book metadata, the list of
+// all book files and the order of documents
+type epubPackage struct {
+	Metadata struct {
+		Titles      []string `xml:"title"`
+		Identifiers []string `xml:"identifier"`
+		Creators    []struct {
+			Name   string `xml:",chardata"`
+			FileAs string `xml:"file-as,attr"`
+			Role   string `xml:"role,attr"`
+		} `xml:"creator"`
+		Languages []string `xml:"language"`
+		Subjects  []string `xml:"subject"`
+		Metas     []struct {
+			Name    string `xml:"name,attr"`
+			Content string `xml:"content,attr"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Manifest []struct {
+		Id   string `xml:"id,attr"`
+		Href string `xml:"href,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IdRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// isEPUB returns true if the archive contains EPUB container file
+func isEPUB(zr *zip.Reader) bool {
+	for _, f := range zr.File {
+		if f.Name == epubContainer {
+			return true
+		}
+	}
+	return false
+}
+
+func readZipFile(zr *zip.Reader, name string) ([]byte, error) {
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+
+	return nil, fmt.Errorf("file '%s' not found in the book archive", name)
+}
+
+func decodeEPUBXML(zr *zip.Reader, name string, v interface{}) error {
+	data, err := readZipFile(zr, name)
+	if err != nil {
+		return err
+	}
+	d, err := newXMLDecoder(data)
+	if err != nil {
+		return err
+	}
+	return d.Decode(v)
+}
+
+// splitAuthor extracts first and last names from EPUB creator. file-as
+// attribute has 'Last, First' format, otherwise the last word of the name
+// is the last name
+func splitAuthor(name, fileAs string) (string, string) {
+	if parts := strings.SplitN(fileAs, ",", 2); len(parts) == 2 {
+		return strings.TrimSpace(parts[1]), strings.TrimSpace(parts[0])
+	}
+
+	name = strings.TrimSpace(name)
+	if i := strings.LastIndex(name, " "); i != -1 {
+		return strings.TrimSpace(name[:i]), name[i+1:]
+	}
+	return "", name
+}
+
+// readEPUBPackage returns the path of OPF file and its content
+func readEPUBPackage(zr *zip.Reader) (string, *epubPackage, error) {
+	var container epubContainerXML
+	if err := decodeEPUBXML(zr, epubContainer, &container); err != nil {
+		return "", nil, err
+	}
+	if len(container.Rootfiles) == 0 {
+		return "", nil, fmt.Errorf("EPUB container does not point to a book package")
+	}
+
+	opfPath := container.Rootfiles[0].FullPath
+	pkg := new(epubPackage)
+	if err := decodeEPUBXML(zr, opfPath, pkg); err != nil {
+		return "", nil, err
+	}
+	return opfPath, pkg, nil
+}
+
+func epubInfo(pkg *epubPackage) Info {
+	var info Info
+	meta := &pkg.Metadata
+	if len(meta.Titles) != 0 {
+		info.Title = strings.TrimSpace(meta.Titles[0])
+	}
+	for _, c := range meta.Creators {
+		if c.Role == "" || c.Role == "aut" {
+			info.FirstName, info.LastName = splitAuthor(c.Name, c.FileAs)
+			break
+		}
+	}
+	if len(meta.Identifiers) != 0 {
+		info.Id = strings.TrimSpace(meta.Identifiers[0])
+	}
+	if len(meta.Languages) != 0 {
+		info.Language = strings.TrimSpace(meta.Languages[0])
+	}
+	if len(meta.Subjects) != 0 {
+		info.Genre = strings.TrimSpace(meta.Subjects[0])
+	}
+	for _, m := range meta.Metas {
+		if m.Name == "calibre:series" {
+			info.Sequence = strings.TrimSpace(m.Content)
+		} else if m.Name == "calibre:series_index" {
+			info.SeqNumber = sequenceNumber(m.Content)
+		}
+	}
+
+	return info
+}
+
+// parseEPUB reads book metadata from OPF file and then all documents
+// in the order defined by the package spine
+func parseEPUB(zr *zip.Reader) (*Book, error) {
+	b := emptyBook()
+	opfPath, pkg, err := readEPUBPackage(zr)
+	if err != nil {
+		return b, err
+	}
+	b.Info = epubInfo(pkg)
+
+	files := make(map[string]string, len(pkg.Manifest))
+	for _, item := range pkg.Manifest {
+		href := item.Href
+		if unescaped, err := url.PathUnescape(href); err == nil {
+			href = unescaped
+		}
+		files[item.Id] = path.Join(path.Dir(opfPath), href)
+	}
+
+	for _, ref := range pkg.Spine {
+		name, ok := files[ref.IdRef]
+		if !ok {
+			continue
+		}
+		data, err := readZipFile(zr, name)
+		if err != nil {
+			return b, err
+		}
+		if err := parseHTML(b, name, data); err != nil {
+			return b, err
+		}
+	}
+
+	return b, nil
+}
@@ -0,0 +1,191 @@
+package book
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+const epubContainerData = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`
+
+// epubPackageData has documents in the manifest in a different order than
+// in the spine, and an illustrator before the author
+const epubPackageData = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+<dc:title> Roadside Picnic </dc:title>
+<dc:creator opf:role="ill">Some Artist</dc:creator>
+<dc:creator opf:role="aut" opf:file-as="Strugatsky, Arkady">A. Strugatsky</dc:creator>
+<dc:identifier id="uid">urn:uuid:1234</dc:identifier>
+<dc:language>en</dc:language>
+<dc:subject>Science Fiction</dc:subject>
+<dc:subject>Adventure</dc:subject>
+<meta name="calibre:series" content="Noon Universe"/>
+<meta name="calibre:series_index" content="2.0"/>
+</metadata>
+<manifest>
+<item id="ch2" href="Text/chapter%202.xhtml" media-type="application/xhtml+xml"/>
+<item id="ch1" href="Text/chapter1.xhtml" media-type="application/xhtml+xml"/>
+<item id="css" href="style.css" media-type="text/css"/>
+</manifest>
+<spine><itemref idref="ch1"/><itemref idref="unknown"/><itemref idref="ch2"/></spine>
+</package>`
+
+const epubChapter1 = `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>Ch 1</title><style>p { margin: 0 }</style></head>
+<body>
+<h1>Chapter 1</h1>
+<p>First <b>bold</b> paragraph<a href="chapter%202.xhtml#note">*</a>.</p>
+</body></html>`
+
+const epubChapter2 = `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><body>
+<h2>Chapter 2<br/>The Zone</h2>
+<p id="note">Note text with <a href="chapter1.xhtml">back link</a> and <a href="http://example.com">site</a>.</p>
+</body></html>`
+
+// zipFiles packs the files into a zip archive
+func zipFiles(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err == nil {
+			_, err = w.Write([]byte(data))
+		}
+		if err != nil {
+			t.Fatalf("failed to zip %s: %v", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to zip files: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// testEPUB returns files of a test EPUB book. The files can be changed
+// before zipping them
+func testEPUB() map[string]string {
+	return map[string]string{
+		"mimetype":                   "application/epub+zip",
+		epubContainer:                epubContainerData,
+		"OEBPS/content.opf":          epubPackageData,
+		"OEBPS/Text/chapter1.xhtml":  epubChapter1,
+		"OEBPS/Text/chapter 2.xhtml": epubChapter2,
+		"OEBPS/style.css":            "p { margin: 0 }",
+	}
+}
+
+func TestSplitAuthor(t *testing.T) {
+	tests := []struct {
+		name, fileAs string
+		first, last  string
+	}{
+		{"Arkady Strugatsky", "", "Arkady", "Strugatsky"},
+		{"Arkady Natanovich Strugatsky", "", "Arkady Natanovich", "Strugatsky"},
+		{" Homer ", "", "", "Homer"},
+		{"A. Strugatsky", "Strugatsky, Arkady", "Arkady", "Strugatsky"},
+		{"Le Guin", "Le Guin, Ursula K.", "Ursula K.", "Le Guin"},
+		// file-as without a comma is ignored
+		{"Ursula Le Guin", "Le Guin", "Ursula Le", "Guin"},
+	}
+	for _, test := range tests {
+		first, last := splitAuthor(test.name, test.fileAs)
+		if first != test.first || last != test.last {
+			t.Errorf("splitAuthor(%q, %q) = %q, %q, want %q, %q",
+				test.name, test.fileAs, first, last, test.first, test.last)
+		}
+	}
+}
+
+func TestEPUBInfo(t *testing.T) {
+	info := Info{
+		FirstName: "Arkady",
+		LastName:  "Strugatsky",
+		Title:     "Roadside Picnic",
+		Sequence:  "Noon Universe",
+		SeqNumber: 2,
+		Language:  "en",
+		Genre:     "Science Fiction",
+		Id:        "urn:uuid:1234",
+	}
+	data := zipFiles(t, testEPUB())
+	l := epubLoader{}
+	if !l.Detect("book", data) {
+		t.Errorf("EPUB book is not detected")
+	}
+	if got, err := l.ParseInfo("book", data); err != nil || got != info {
+		t.Errorf("ParseInfo = %+v, %v, want %+v", got, err, info)
+	}
+	b, err := l.Parse("book", data)
+	if err != nil || b.Info != info {
+		t.Errorf("parsed book info %+v, %v, want %+v", b.Info, err, info)
+	}
+}
+
+func TestParseEPUB(t *testing.T) {
+	b, err := epubLoader{}.Parse("book", zipFiles(t, testEPUB()))
+	if err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+
+	// documents are read in the spine order, links between documents
+	// point to the anchors of the whole book
+	paras := []Paragraph{
+		{Kind: KindTitle, Text: "Chapter 1"},
+		{Kind: KindText, Text: "First bold paragraph*.",
+			Links: []Link{{Start: 20, End: 21, Target: "OEBPS/Text/chapter 2.xhtml#note"}},
+			Spans: []Span{{Start: 6, End: 10, Style: StyleStrong}}},
+		{Kind: KindTitle, Text: "Chapter 2 The Zone"},
+		{Kind: KindText, Text: "Note text with back link and site.",
+			Links: []Link{{Start: 15, End: 24, Target: "OEBPS/Text/chapter1.xhtml"}}},
+	}
+	if got := normalized(b.Paragraphs); !reflect.DeepEqual(got, paras) {
+		t.Errorf("paragraphs:\n%+v\nwant:\n%+v", got, paras)
+	}
+	toc := []TocItem{
+		{Title: "Chapter 1", Level: 0, Para: 0},
+		{Title: "Chapter 2 The Zone", Level: 1, Para: 2},
+	}
+	if !reflect.DeepEqual(b.Toc, toc) {
+		t.Errorf("table of contents %+v, want %+v", b.Toc, toc)
+	}
+	anchors := map[string]int{
+		"OEBPS/Text/chapter1.xhtml":       0,
+		"OEBPS/Text/chapter 2.xhtml":      2,
+		"OEBPS/Text/chapter 2.xhtml#note": 3,
+	}
+	if !reflect.DeepEqual(b.Anchors, anchors) {
+		t.Errorf("anchors %v, want %v", b.Anchors, anchors)
+	}
+}
+
+func TestParseEPUBErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(files map[string]string)
+	}{
+		{"no container", func(files map[string]string) { delete(files, epubContainer) }},
+		{"no rootfile", func(files map[string]string) {
+			files[epubContainer] = `<container><rootfiles></rootfiles></container>`
+		}},
+		{"no package", func(files map[string]string) { delete(files, "OEBPS/content.opf") }},
+		{"no document", func(files map[string]string) { delete(files, "OEBPS/Text/chapter 2.xhtml") }},
+	}
+	for _, test := range tests {
+		files := testEPUB()
+		test.change(files)
+		if _, err := (epubLoader{}).Parse("book", zipFiles(t, files)); err == nil {
+			t.Errorf("%s: parsing succeeded, want failure", test.name)
+		}
+	}
+
+	// FB2 archives are not EPUB books
+	if (epubLoader{}).Detect("book", zipFile(t, "picnic.fb2", []byte(testFB2))) {
+		t.Errorf("zipped FB2 book is detected as EPUB")
+	}
+}
@@ -0,0 +1,76 @@
+package book
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// ExportOptions defines how a book is written as plain text
+type ExportOptions struct {
+	Width   int
+	Justify bool
+	// write the book description before the text
+	Header bool
+	// append notes and comments bodies after the main text
+	Notes bool
+}
+
+// header returns lines of the book description. Empty fields are skipped
+func header(info Info) []string {
+	fields := []struct {
+		name, value string
+	}{
+		{"Author", strings.TrimSpace(info.FirstName + " " + info.LastName)},
+		{"Title", info.Title},
+		{"Sequence", SequenceText(info.Sequence, info.SeqNumber)},
+		{"Genre", info.Genre},
+		{"Language", info.Language},
+	}
+
+	lines := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f.value != "" {
+			lines = append(lines, f.name+": "+f.value)
+		}
+	}
+	return lines
+}
+
+// Export formats the book and writes it to w as plain text. The lines are
+// the same as the reader displays, without the end of book marker
+func Export(w io.Writer, b *Book, opts ExportOptions) error {
+	if !opts.Notes && b.NotesPara >= 0 {
+		main := *b
+		main.Paragraphs = b.Paragraphs[:b.NotesPara]
+		b = &main
+	}
+
+	out := bufio.NewWriter(w)
+	if opts.Header {
+		if lines := header(b.Info); len(lines) != 0 {
+			for _, s := range lines {
+				out.WriteString(s + "\n")
+			}
+			out.WriteString("\n")
+		}
+	}
+
+	// empty lines are written only before text, so the output does not
+	// end with them
+	empty := 0
+	for _, line := range Format(b, opts.Width, opts.Justify) {
+		if line.Para >= len(b.Paragraphs) {
+			break
+		}
+		text := strings.TrimRight(line.Text, " ")
+		if text == "" {
+			empty++
+			continue
+		}
+		out.WriteString(strings.Repeat("\n", empty) + text + "\n")
+		empty = 0
+	}
+
+	return out.Flush()
+}
@@ -0,0 +1,76 @@
+package book
+
+import (
+	"bytes"
+	"testing"
+)
+
+// notesBook is a book with a notes body after the main text
+func notesBook() *Book {
+	return &Book{
+		Info: Info{FirstName: "Arkady", LastName: "Strugatsky", Title: "Roadside Picnic",
+			Sequence: "Noon", SeqNumber: 3, Language: "en"},
+		Paragraphs: []Paragraph{
+			{Kind: KindTitle, Text: "Chapter"},
+			{Kind: KindText, Text: "one two three four five six"},
+			{Kind: KindEmpty},
+			{Kind: KindTitle, Text: "Notes"},
+			{Kind: KindText, Text: "A note."},
+		},
+		NotesPara: 2,
+	}
+}
+
+// mainBook is testBook without notes, as loaders make books
+func mainBook() *Book {
+	b := testBook()
+	b.NotesPara = -1
+	return b
+}
+
+func TestExport(t *testing.T) {
+	tests := []struct {
+		name string
+		book *Book
+		opts ExportOptions
+		text string
+	}{
+		// the end of book marker and trailing spaces are not written,
+		// titles are centered
+		{"plain", mainBook(), ExportOptions{Width: 12},
+			"  Chapter\n\n  one two\nthree four\nfive six\n  abc\n"},
+		{"justified", mainBook(), ExportOptions{Width: 12, Justify: true},
+			"  Chapter\n\n  one    two\nthree   four\nfive six\n  abc\n"},
+		{"without notes", notesBook(), ExportOptions{Width: 12},
+			"  Chapter\n\n  one two\nthree four\nfive six\n"},
+		{"with notes", notesBook(), ExportOptions{Width: 12, Notes: true},
+			"  Chapter\n\n  one two\nthree four\nfive six\n\n   Notes\n\n  A note.\n"},
+		{"header", notesBook(), ExportOptions{Width: 40, Header: true},
+			"Author: Arkady Strugatsky\nTitle: Roadside Picnic\nSequence: Noon #3\nLanguage: en\n\n" +
+				"                Chapter\n\n  one two three four five six\n"},
+		// a book without description has no header
+		{"empty header", mainBook(), ExportOptions{Width: 40, Header: true},
+			"                Chapter\n\n  one two three four five six\n  abc\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := Export(&buf, test.book, test.opts); err != nil {
+			t.Errorf("%s: Export failed: %v", test.name, err)
+			continue
+		}
+		if buf.String() != test.text {
+			t.Errorf("%s: Export = %q, want %q", test.name, buf.String(), test.text)
+		}
+	}
+}
+
+func TestExportKeepsBook(t *testing.T) {
+	// paragraphs of notes are skipped in a copy of the book
+	b := notesBook()
+	if err := Export(&bytes.Buffer{}, b, ExportOptions{Width: 12}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if len(b.Paragraphs) != 5 || b.NotesPara != 2 {
+		t.Errorf("exported book has %d paragraphs and notes at %d, want 5 and 2", len(b.Paragraphs), b.NotesPara)
+	}
+}
@@ -0,0 +1,403 @@
+package book
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode"
+)
+
+// inline FB2 tags that change text style
+var fb2Styles = map[string]Style{
+	"strong":        StyleStrong,
+	"emphasis":      StyleEmphasis,
+	"strikethrough": StyleStrike,
+	"code":          StyleCode,
+}
+
+// paraBuilder collects text of a paragraph: it squeezes all whitespaces
+// into a single space and tracks links inside the paragraph
+type paraBuilder struct {
+	kind  Kind
+	text  strings.Builder
+	runes int
+	space bool
+	links []Link
+	spans []Span
+	// spans that are not closed yet
+	open []Span
+}
+
+func (p *paraBuilder) addText(s string) {
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			if p.runes > 0 && !p.space {
+				p.text.WriteRune(' ')
+				p.runes++
+				p.space = true
+			}
+			continue
+		}
+		p.text.WriteRune(r)
+		p.runes++
+		p.space = false
+	}
+}
+
+func (p *paraBuilder) openSpan(style Style) {
+	p.open = append(p.open, Span{Start: p.runes, Style: style})
+}
+
+func (p *paraBuilder) closeSpan(style Style) {
+	for i := len(p.open) - 1; i >= 0; i-- {
+		if p.open[i].Style != style {
+			continue
+		}
+		span := p.open[i]
+		span.End = p.runes
+		p.spans = append(p.spans, span)
+		p.open = append(p.open[:i], p.open[i+1:]...)
+		return
+	}
+}
+
+// trimRange excludes leading and trailing spaces from a text range
+func trimRange(runes []rune, start, end int) (int, int) {
+	if end > len(runes) {
+		end = len(runes)
+	}
+	for start < end && runes[start] == ' ' {
+		start++
+	}
+	for end > start && runes[end-1] == ' ' {
+		end--
+	}
+	return start, end
+}
+
+func (p *paraBuilder) paragraph() Paragraph {
+	runes := []rune(p.text.String())
+	if p.space {
+		runes = runes[:len(runes)-1]
+	}
+	if len(runes) == 0 {
+		return Paragraph{Kind: KindEmpty}
+	}
+
+	links := make([]Link, 0, len(p.links))
+	for _, l := range p.links {
+		l.Start, l.End = trimRange(runes, l.Start, l.End)
+		if l.Start < l.End {
+			links = append(links, l)
+		}
+	}
+	// unclosed styles last until the end of the paragraph
+	for _, span := range p.open {
+		span.End = len(runes)
+		p.spans = append(p.spans, span)
+	}
+	spans := make([]Span, 0, len(p.spans))
+	for _, span := range p.spans {
+		span.Start, span.End = trimRange(runes, span.Start, span.End)
+		if span.Start < span.End {
+			spans = append(spans, span)
+		}
+	}
+
+	return Paragraph{Kind: p.kind, Text: string(runes), Links: links, Spans: spans}
+}
+
+// bookBuilder collects book paragraphs and remembers which paragraphs
+// link targets point to
+type bookBuilder struct {
+	book *Book
+	// ids of the sections that do not have any paragraphs yet
+	pendingAnchors []string
+}
+
+func (b *bookBuilder) addParagraph(para Paragraph) {
+	for _, id := range b.pendingAnchors {
+		b.book.Anchors[id] = len(b.book.Paragraphs)
+	}
+	b.pendingAnchors = b.pendingAnchors[:0]
+
+	b.book.Paragraphs = append(b.book.Paragraphs, para)
+}
+
+// fb2Parser keeps the state of FB2 file parsing
+type fb2Parser struct {
+	bookBuilder
+	stack []string
+
+	para       *paraBuilder
+	linkStart  int
+	linkTarget string
+	titleDepth int
+	// the title of the current section that is being parsed
+	tocItem *TocItem
+	// depth of nested sections
+	sectionDepth int
+	// true if the current body contains notes or comments
+	notesBody bool
+	// the first author is the book author, the rest are ignored
+	authorDone bool
+}
+
+func isFB2Name(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".fb2")
+}
+
+// zipHasFB2 returns true if the archive contains an FB2 file
+func zipHasFB2(zr *zip.Reader) bool {
+	for _, f := range zr.File {
+		if isFB2Name(f.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// unzipFB2 extracts the first FB2 file from the archive
+func unzipFB2(zr *zip.Reader) ([]byte, error) {
+	for _, f := range zr.File {
+		if !isFB2Name(f.Name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+
+	return nil, fmt.Errorf("archive does not contain FB2 files")
+}
+
+// parseFB2 reads FB2 book. If infoOnly is true, parsing stops after
+// the book description
+func parseFB2(data []byte, infoOnly bool) (*Book, error) {
+	p := &fb2Parser{bookBuilder: bookBuilder{book: emptyBook()}}
+
+	d, err := newXMLDecoder(data)
+	if err != nil {
+		return p.book, err
+	}
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return p.book, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "binary" {
+				// images are not displayed, skip them to save time
+				if err := d.Skip(); err != nil {
+					return p.book, err
+				}
+				continue
+			}
+			p.startElement(t)
+		case xml.EndElement:
+			p.endElement(t)
+			if infoOnly && t.Name.Local == "description" {
+				return p.book, nil
+			}
+		case xml.CharData:
+			p.charData(string(t))
+		}
+	}
+
+	return p.book, nil
+}
+
+func attrValue(t xml.StartElement, name string) string {
+	for _, a := range t.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (p *fb2Parser) inside(name string) bool {
+	for _, s := range p.stack {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *fb2Parser) current() string {
+	if len(p.stack) == 0 {
+		return ""
+	}
+	return p.stack[len(p.stack)-1]
+}
+
+// addTocItem adds the title of the current section to the table of contents
+func (p *fb2Parser) addTocItem() {
+	parts := make([]string, 0)
+	for _, para := range p.book.Paragraphs[p.tocItem.Para:] {
+		if para.Text != "" {
+			parts = append(parts, para.Text)
+		}
+	}
+	if len(parts) != 0 {
+		p.tocItem.Title = strings.Join(parts, " ")
+		p.book.Toc = append(p.book.Toc, *p.tocItem)
+	}
+	p.tocItem = nil
+}
+
+func (p *fb2Parser) startElement(t xml.StartElement) {
+	name := t.Name.Local
+	p.stack = append(p.stack, name)
+
+	if p.inside("description") {
+		if name == "sequence" && p.inside("title-info") && p.book.Info.Sequence == "" {
+			p.book.Info.Sequence = attrValue(t, "name")
+			p.book.Info.SeqNumber = sequenceNumber(attrValue(t, "number"))
+		}
+		return
+	}
+
+	switch name {
+	case "body":
+		p.notesBody = attrValue(t, "name") != ""
+		if p.notesBody && p.book.NotesPara == -1 {
+			p.book.NotesPara = len(p.book.Paragraphs)
+		}
+		if len(p.book.Paragraphs) != 0 {
+			// separate notes and comments from the main text
+			p.addParagraph(Paragraph{Kind: KindEmpty})
+		}
+	case "section":
+		p.sectionDepth++
+		if id := attrValue(t, "id"); id != "" {
+			p.pendingAnchors = append(p.pendingAnchors, id)
+		}
+	case "title":
+		p.titleDepth++
+		// every footnote is a separate section, so only the title of
+		// the notes body is added to the table of contents
+		if p.titleDepth == 1 && (!p.notesBody || p.sectionDepth == 0) {
+			p.tocItem = &TocItem{Level: p.sectionDepth, Para: len(p.book.Paragraphs)}
+		}
+	case "p", "v", "subtitle", "text-author":
+		kind := KindText
+		if p.titleDepth > 0 {
+			kind = KindTitle
+		} else if name == "subtitle" {
+			kind = KindSubtitle
+		} else if p.inside("epigraph") {
+			kind = KindEpigraph
+		} else if p.inside("poem") {
+			kind = KindPoem
+		}
+		p.para = &paraBuilder{kind: kind}
+	case "empty-line":
+		p.addParagraph(Paragraph{Kind: KindEmpty})
+	case "a":
+		href := attrValue(t, "href")
+		if p.para != nil && strings.HasPrefix(href, "#") {
+			p.linkTarget = href[1:]
+			p.linkStart = p.para.runes
+		}
+	default:
+		if style, ok := fb2Styles[name]; ok && p.para != nil {
+			p.para.openSpan(style)
+		}
+	}
+}
+
+func (p *fb2Parser) endElement(t xml.EndElement) {
+	name := t.Name.Local
+	if len(p.stack) != 0 {
+		p.stack = p.stack[:len(p.stack)-1]
+	}
+
+	switch name {
+	case "author":
+		if p.inside("title-info") {
+			p.authorDone = true
+		}
+	case "section":
+		if p.sectionDepth > 0 {
+			p.sectionDepth--
+		}
+	case "title":
+		if p.titleDepth > 0 {
+			p.titleDepth--
+		}
+		if p.titleDepth == 0 && p.tocItem != nil {
+			p.addTocItem()
+		}
+	case "stanza":
+		p.addParagraph(Paragraph{Kind: KindEmpty})
+	case "p", "v", "subtitle", "text-author":
+		if p.para != nil {
+			p.addParagraph(p.para.paragraph())
+			p.para = nil
+		}
+	case "a":
+		if p.para != nil && p.linkTarget != "" {
+			p.para.links = append(p.para.links, Link{Start: p.linkStart, End: p.para.runes, Target: p.linkTarget})
+		}
+		p.linkTarget = ""
+	default:
+		if style, ok := fb2Styles[name]; ok && p.para != nil {
+			p.para.closeSpan(style)
+		}
+	}
+}
+
+func (p *fb2Parser) charData(s string) {
+	if p.para != nil {
+		p.para.addText(s)
+		return
+	}
+
+	if !p.inside("title-info") && !p.inside("document-info") {
+		return
+	}
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return
+	}
+	info := &p.book.Info
+	if p.inside("document-info") {
+		if p.current() == "id" {
+			info.Id = s
+		}
+		return
+	}
+	switch p.current() {
+	case "first-name":
+		if !p.authorDone && p.inside("author") {
+			info.FirstName = s
+		}
+	case "last-name":
+		if !p.authorDone && p.inside("author") {
+			info.LastName = s
+		}
+	case "book-title":
+		info.Title = s
+	case "lang":
+		info.Language = s
+	case "genre":
+		if info.Genre == "" {
+			info.Genre = s
+		}
+	}
+}
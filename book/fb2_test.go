@@ -0,0 +1,252 @@
+package book
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testFB2 is a book with nested sections, an epigraph, a poem, inline
+// styles, internal and external links, and a notes body
+const testFB2 = `<?xml version="1.0" encoding="UTF-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description>
+<title-info>
+<genre>sf</genre><genre>adventure</genre>
+<author><first-name>Arkady</first-name><last-name>Strugatsky</last-name></author>
+<author><first-name>Boris</first-name><last-name>Strugatsky</last-name></author>
+<book-title>Roadside Picnic</book-title>
+<lang>en</lang>
+<sequence name="Noon Universe" number="3"/>
+</title-info>
+<document-info><id>doc-42</id></document-info>
+</description>
+<body>
+<title><p>Roadside Picnic</p></title>
+<epigraph><p>You have to make good out of evil</p><text-author>R. P. Warren</text-author></epigraph>
+<section id="ch1">
+<title><p>Chapter 1</p><p>Redrick Schuhart</p></title>
+<p>  Text   with <strong>bold</strong> and <emphasis>italic <strikethrough>gone</strikethrough></emphasis> words<a l:href="#n1" type="note">[1]</a>.</p>
+<empty-line/>
+<section id="ch1-1">
+<title><p>Part A</p></title>
+<subtitle>Subtitle</subtitle>
+<poem><stanza><v>First line</v><v>Second line</v></stanza></poem>
+<p>See <a l:href="#ch2">chapter 2</a> or <a l:href="http://example.com">site</a>.</p>
+</section>
+</section>
+<section id="ch2">
+<title><p>Chapter 2</p></title>
+<p><code>x := 1</code></p>
+</section>
+</body>
+<body name="notes">
+<title><p>Notes</p></title>
+<section id="n1"><title><p>1</p></title><p>A note.</p></section>
+</body>
+<binary id="cover" content-type="image/png">iVBORw0KGgo=</binary>
+</FictionBook>`
+
+// russianFB2 returns a short book in the encoding
+func russianFB2(enc string) string {
+	return `<?xml version="1.0" encoding="` + enc + `"?>
+<FictionBook><description><title-info><book-title>Пикник на обочине</book-title></title-info></description>
+<body><section><p>` + russian + `</p></section></body></FictionBook>`
+}
+
+// zipFile packs the data into a zip archive as a single file
+func zipFile(t *testing.T, name string, data []byte) []byte {
+	return zipFiles(t, map[string]string{name: string(data)})
+}
+
+// normalized replaces empty link and span lists with nil, so paragraphs
+// can be compared with reflect.DeepEqual
+func normalized(paras []Paragraph) []Paragraph {
+	res := make([]Paragraph, len(paras))
+	for i, p := range paras {
+		if len(p.Links) == 0 {
+			p.Links = nil
+		}
+		if len(p.Spans) == 0 {
+			p.Spans = nil
+		}
+		res[i] = p
+	}
+	return res
+}
+
+func TestParseFB2Info(t *testing.T) {
+	info := Info{
+		FirstName: "Arkady",
+		LastName:  "Strugatsky",
+		Title:     "Roadside Picnic",
+		Sequence:  "Noon Universe",
+		SeqNumber: 3,
+		Language:  "en",
+		Genre:     "sf",
+		Id:        "doc-42",
+	}
+	for _, infoOnly := range []bool{true, false} {
+		b, err := parseFB2([]byte(testFB2), infoOnly)
+		if err != nil {
+			t.Errorf("info only %v: parseFB2 failed: %v", infoOnly, err)
+			continue
+		}
+		if b.Info != info {
+			t.Errorf("info only %v: book info %+v, want %+v", infoOnly, b.Info, info)
+		}
+		if infoOnly && len(b.Paragraphs) != 0 {
+			t.Errorf("info only: book has %d paragraphs, want none", len(b.Paragraphs))
+		}
+	}
+}
+
+func TestParseFB2(t *testing.T) {
+	b, err := parseFB2([]byte(testFB2), false)
+	if err != nil {
+		t.Fatalf("parseFB2 failed: %v", err)
+	}
+
+	paras := []Paragraph{
+		{Kind: KindTitle, Text: "Roadside Picnic"},
+		{Kind: KindEpigraph, Text: "You have to make good out of evil"},
+		{Kind: KindEpigraph, Text: "R. P. Warren"},
+		{Kind: KindTitle, Text: "Chapter 1"},
+		{Kind: KindTitle, Text: "Redrick Schuhart"},
+		{Kind: KindText, Text: "Text with bold and italic gone words[1].",
+			Links: []Link{{Start: 36, End: 39, Target: "n1"}},
+			Spans: []Span{{10, 14, StyleStrong}, {26, 30, StyleStrike}, {19, 30, StyleEmphasis}}},
+		{Kind: KindEmpty},
+		{Kind: KindTitle, Text: "Part A"},
+		{Kind: KindSubtitle, Text: "Subtitle"},
+		{Kind: KindPoem, Text: "First line"},
+		{Kind: KindPoem, Text: "Second line"},
+		{Kind: KindEmpty},
+		// external links are not shown
+		{Kind: KindText, Text: "See chapter 2 or site.", Links: []Link{{Start: 4, End: 13, Target: "ch2"}}},
+		{Kind: KindTitle, Text: "Chapter 2"},
+		{Kind: KindText, Text: "x := 1", Spans: []Span{{0, 6, StyleCode}}},
+		// notes body is separated from the main text
+		{Kind: KindEmpty},
+		{Kind: KindTitle, Text: "Notes"},
+		{Kind: KindTitle, Text: "1"},
+		{Kind: KindText, Text: "A note."},
+	}
+	if got := normalized(b.Paragraphs); !reflect.DeepEqual(got, paras) {
+		t.Errorf("paragraphs:\n%+v\nwant:\n%+v", got, paras)
+	}
+
+	// titles of notes are not in the table of contents, only the title
+	// of the notes body
+	toc := []TocItem{
+		{Title: "Roadside Picnic", Level: 0, Para: 0},
+		{Title: "Chapter 1 Redrick Schuhart", Level: 1, Para: 3},
+		{Title: "Part A", Level: 2, Para: 7},
+		{Title: "Chapter 2", Level: 1, Para: 13},
+		{Title: "Notes", Level: 0, Para: 16},
+	}
+	if !reflect.DeepEqual(b.Toc, toc) {
+		t.Errorf("table of contents %+v, want %+v", b.Toc, toc)
+	}
+
+	anchors := map[string]int{"ch1": 3, "ch1-1": 7, "ch2": 13, "n1": 17}
+	if !reflect.DeepEqual(b.Anchors, anchors) {
+		t.Errorf("anchors %v, want %v", b.Anchors, anchors)
+	}
+	if b.NotesPara != 15 || b.TextEnd().Para != 15 {
+		t.Errorf("notes start at %d, text ends at %d, want 15", b.NotesPara, b.TextEnd().Para)
+	}
+}
+
+func TestParseFB2Notes(t *testing.T) {
+	tests := []struct {
+		name    string
+		fb2     string
+		notes   int
+		anchors map[string]int
+	}{
+		{"no notes", `<FictionBook><body><section id="a"><p>Text</p></section></body></FictionBook>`,
+			-1, map[string]int{"a": 0}},
+		// a section without paragraphs points to the next paragraph
+		{"empty section", `<FictionBook><body><section id="a"><section id="b"><p>Text</p></section></section></body>
+			<body name="comments"><section id="c"><p>Comment</p></section></body></FictionBook>`,
+			1, map[string]int{"a": 0, "b": 0, "c": 2}},
+		{"notes and comments", `<FictionBook><body><p>Text</p></body>
+			<body name="notes"><section id="n1"><p>Note</p></section></body>
+			<body name="comments"><section id="c1"><p>Comment</p></section></body></FictionBook>`,
+			1, map[string]int{"n1": 2, "c1": 4}},
+	}
+	for _, test := range tests {
+		b, err := parseFB2([]byte(test.fb2), false)
+		if err != nil {
+			t.Errorf("%s: parseFB2 failed: %v", test.name, err)
+			continue
+		}
+		if b.NotesPara != test.notes {
+			t.Errorf("%s: notes start at %d, want %d", test.name, b.NotesPara, test.notes)
+		}
+		if !reflect.DeepEqual(b.Anchors, test.anchors) {
+			t.Errorf("%s: anchors %v, want %v", test.name, b.Anchors, test.anchors)
+		}
+	}
+}
+
+func TestParseFB2Encoding(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"utf-8", []byte(russianFB2("UTF-8"))},
+		{"utf-8 bom", append([]byte{0xEF, 0xBB, 0xBF}, russianFB2("UTF-8")...)},
+		{"windows-1251", encode(t, russianFB2("windows-1251"), "windows-1251")},
+		{"koi8-r", encode(t, russianFB2("koi8-r"), "koi8-r")},
+		{"zipped", zipFile(t, "picnic.fb2", encode(t, russianFB2("windows-1251"), "windows-1251"))},
+	}
+	l := fb2Loader{}
+	for _, test := range tests {
+		if !l.Detect("book", test.data) {
+			t.Errorf("%s: FB2 book is not detected", test.name)
+		}
+		b, err := l.Parse("book", test.data)
+		if err != nil {
+			t.Errorf("%s: parsing failed: %v", test.name, err)
+			continue
+		}
+		if b.Info.Title != "Пикник на обочине" {
+			t.Errorf("%s: book title %q, want %q", test.name, b.Info.Title, "Пикник на обочине")
+		}
+		if len(b.Paragraphs) != 1 || b.Paragraphs[0].Text != russian {
+			t.Errorf("%s: paragraphs %+v, want %q", test.name, b.Paragraphs, russian)
+		}
+	}
+}
+
+func TestParseFB2Entities(t *testing.T) {
+	// books often use HTML entities, non-breaking spaces are squeezed
+	// like other spaces
+	data := `<FictionBook><body><p>Text&nbsp;&nbsp;and&mdash;more &amp; &lt;tag&gt;&#33;</p></body></FictionBook>`
+	b, err := parseFB2([]byte(data), false)
+	if err != nil {
+		t.Fatalf("parseFB2 failed: %v", err)
+	}
+	want := "Text and\u2014more & <tag>!"
+	if len(b.Paragraphs) != 1 || b.Paragraphs[0].Text != want {
+		t.Errorf("paragraphs %+v, want %q", b.Paragraphs, want)
+	}
+}
+
+func TestParseFB2Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated", []byte(`<FictionBook><body><p>Text`)},
+		{"unknown encoding", []byte(russianFB2("x-unknown"))},
+		{"zip without fb2", zipFile(t, "picnic.txt", []byte(russianFB2("UTF-8")))},
+	}
+	l := fb2Loader{}
+	for _, test := range tests {
+		if _, err := l.Parse("book", test.data); err == nil {
+			t.Errorf("%s: parsing succeeded, want failure", test.name)
+		}
+	}
+}
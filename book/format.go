@@ -0,0 +1,228 @@
+package book
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// the first line of a text paragraph starts with this number of spaces
+	paraIndent = 2
+	endMarker  = "--- THE END ---"
+)
+
+// Line is a single formatted line of a book
+type Line struct {
+	Text string
+	// index of the paragraph the line belongs to. Empty lines between
+	// paragraphs belong to the paragraph that follows them
+	Para int
+	// Src maps every rune of Text to the rune offset inside the paragraph
+	// text it was copied from. Indentation and padding spaces are -1
+	Src []int32
+}
+
+// Position returns the position of the first paragraph character
+// displayed in the line
+func (l *Line) Position() Position {
+	for _, s := range l.Src {
+		if s >= 0 {
+			return Position{Para: l.Para, Offset: int(s)}
+		}
+	}
+	return Position{Para: l.Para}
+}
+
+// Column returns the column of the line that displays paragraph character
+// at offset. It returns -1 if the character is not in the line
+func (l *Line) Column(offset int) int {
+	for col, s := range l.Src {
+		if int(s) == offset {
+			return col
+		}
+	}
+	return -1
+}
+
+// FindLine returns the index of the formatted line that contains pos
+func FindLine(lines []Line, pos Position) int {
+	idx := sort.Search(len(lines), func(i int) bool {
+		return pos.Less(lines[i].Position())
+	})
+	if idx > 0 {
+		idx--
+	}
+	return idx
+}
+
+// WordsLeft returns the number of words from every formatted line to the
+// end position, the line itself included. Lines after the end position
+// have no words. The result has an extra zero item for the end of lines
+func WordsLeft(lines []Line, end Position) []int {
+	left := make([]int, len(lines)+1)
+	for i := len(lines) - 1; i >= 0; i-- {
+		left[i] = left[i+1]
+		if lines[i].Position().Less(end) {
+			left[i] += len(strings.Fields(lines[i].Text))
+		}
+	}
+	return left
+}
+
+type word struct {
+	start, end int
+}
+
+// Format splits all book paragraphs into lines that fit width. If justify
+// is true the text paragraph lines, except the last one, are padded with
+// spaces to make them exactly width characters long
+func Format(b *Book, width int, justify bool) []Line {
+	if width <= paraIndent*2 {
+		width = paraIndent*2 + 1
+	}
+
+	lines := make([]Line, 0, len(b.Paragraphs)*2)
+	prevKind := KindEmpty
+	for i, para := range b.Paragraphs {
+		isTitle := para.Kind == KindTitle || para.Kind == KindSubtitle
+		wasTitle := prevKind == KindTitle || prevKind == KindSubtitle
+		if para.Kind != KindEmpty && prevKind != KindEmpty && isTitle != wasTitle {
+			lines = append(lines, Line{Para: i})
+		}
+		prevKind = para.Kind
+
+		switch para.Kind {
+		case KindEmpty:
+			lines = append(lines, Line{Para: i})
+		case KindTitle, KindSubtitle:
+			lines = append(lines, wrapParagraph(i, para.Text, width, 0, false, true)...)
+		case KindEpigraph, KindPoem:
+			lines = append(lines, wrapParagraph(i, para.Text, width, paraIndent*2, false, false)...)
+		default:
+			lines = append(lines, wrapParagraph(i, para.Text, width, paraIndent, justify, false)...)
+		}
+	}
+
+	lines = append(lines, Line{Para: len(b.Paragraphs)})
+	end := Line{Para: len(b.Paragraphs)}
+	end.Text, end.Src = padLine([]rune(endMarker), (width-len(endMarker))/2)
+	lines = append(lines, end)
+
+	return lines
+}
+
+func padLine(text []rune, lead int) (string, []int32) {
+	if lead < 0 {
+		lead = 0
+	}
+	src := make([]int32, len(text)+lead)
+	for i := range src {
+		src[i] = -1
+	}
+	return strings.Repeat(" ", lead) + string(text), src
+}
+
+func splitWords(runes []rune, maxLen int) []word {
+	words := make([]word, 0)
+	start := -1
+	for i, r := range runes {
+		if r == ' ' {
+			if start != -1 {
+				words = append(words, word{start: start, end: i})
+				start = -1
+			}
+		} else if start == -1 {
+			start = i
+		}
+	}
+	if start != -1 {
+		words = append(words, word{start: start, end: len(runes)})
+	}
+
+	// too long words are split into chunks that fit a line
+	res := make([]word, 0, len(words))
+	for _, w := range words {
+		for w.end-w.start > maxLen {
+			res = append(res, word{start: w.start, end: w.start + maxLen})
+			w.start += maxLen
+		}
+		res = append(res, w)
+	}
+
+	return res
+}
+
+func wrapParagraph(index int, text string, width, indent int, justify, center bool) []Line {
+	runes := []rune(text)
+	words := splitWords(runes, width-indent)
+	lines := make([]Line, 0, len(runes)/width+1)
+
+	lead := indent
+	first := 0
+	lineLen := 0
+	for i, w := range words {
+		wlen := w.end - w.start
+		// chunks of a split word never share a line
+		glued := i > 0 && words[i-1].end == w.start
+		if i > first && (glued || lead+lineLen+1+wlen > width) {
+			lines = append(lines, makeLine(index, runes, words[first:i], lead, width, justify, center))
+			first = i
+			lead = 0
+			lineLen = 0
+		}
+		if i > first {
+			lineLen++
+		}
+		lineLen += wlen
+	}
+	if first < len(words) {
+		lines = append(lines, makeLine(index, runes, words[first:], lead, width, false, center))
+	}
+
+	return lines
+}
+
+func makeLine(index int, runes []rune, words []word, lead, width int, justify, center bool) Line {
+	textLen := len(words) - 1
+	for _, w := range words {
+		textLen += w.end - w.start
+	}
+
+	if center && width > textLen {
+		lead = (width - textLen) / 2
+	}
+	extra := 0
+	gaps := len(words) - 1
+	if justify && gaps > 0 && width > lead+textLen {
+		extra = width - lead - textLen
+	}
+
+	buf := make([]rune, 0, width)
+	src := make([]int32, 0, width)
+	for i := 0; i < lead; i++ {
+		buf = append(buf, ' ')
+		src = append(src, -1)
+	}
+	for i, w := range words {
+		if i > 0 {
+			buf = append(buf, ' ')
+			src = append(src, int32(words[i-1].end))
+			if extra > 0 {
+				n := extra / gaps
+				if i-1 < extra%gaps {
+					n++
+				}
+				for ; n > 0; n-- {
+					buf = append(buf, ' ')
+					src = append(src, -1)
+				}
+			}
+		}
+		for k := w.start; k < w.end; k++ {
+			buf = append(buf, runes[k])
+			src = append(src, int32(k))
+		}
+	}
+
+	return Line{Text: string(buf), Para: index, Src: src}
+}
@@ -0,0 +1,124 @@
+package book
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testBook() *Book {
+	return &Book{Paragraphs: []Paragraph{
+		{Kind: KindTitle, Text: "Chapter"},
+		{Kind: KindText, Text: "one two three four five six"},
+		{Kind: KindText, Text: "abc"},
+	}}
+}
+
+// lineTexts returns texts of the formatted lines
+func lineTexts(lines []Line) []string {
+	texts := make([]string, len(lines))
+	for i, l := range lines {
+		texts[i] = l.Text
+	}
+	return texts
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		book    *Book
+		width   int
+		justify bool
+		texts   []string
+	}{
+		{"plain", testBook(), 12, false, []string{
+			"  Chapter", "", "  one two", "three four", "five six", "  abc", "", endMarker}},
+		{"justified", testBook(), 12, true, []string{
+			"  Chapter", "", "  one    two", "three   four", "five six", "  abc", "", endMarker}},
+		{"long word", &Book{Paragraphs: []Paragraph{{Kind: KindText, Text: "abcdefghij"}}}, 6, false, []string{
+			"  abcd", "efgh", "ij", "", endMarker}},
+		{"poem", &Book{Paragraphs: []Paragraph{{Kind: KindPoem, Text: "a b"}, {Kind: KindEmpty}}}, 20, false, []string{
+			"    a b", "", "", "  " + endMarker}},
+	}
+	for _, test := range tests {
+		if texts := lineTexts(Format(test.book, test.width, test.justify)); !reflect.DeepEqual(texts, test.texts) {
+			t.Errorf("%s: Format = %q, want %q", test.name, texts, test.texts)
+		}
+	}
+}
+
+func TestFormatSource(t *testing.T) {
+	lines := Format(testBook(), 12, true)
+	tests := []struct {
+		line int
+		para int
+		src  []int32
+	}{
+		{1, 1, []int32{}},
+		{2, 1, []int32{-1, -1, 0, 1, 2, 3, -1, -1, -1, 4, 5, 6}},
+		{3, 1, []int32{8, 9, 10, 11, 12, 13, -1, -1, 14, 15, 16, 17}},
+		{4, 1, []int32{19, 20, 21, 22, 23, 24, 25, 26}},
+		{5, 2, []int32{-1, -1, 0, 1, 2}},
+	}
+	for _, test := range tests {
+		l := lines[test.line]
+		src := l.Src
+		if src == nil {
+			src = []int32{}
+		}
+		if l.Para != test.para || !reflect.DeepEqual(src, test.src) {
+			t.Errorf("line %d: para %d, source %v, want para %d, source %v", test.line, l.Para, src, test.para, test.src)
+		}
+	}
+}
+
+func TestLineColumn(t *testing.T) {
+	lines := Format(testBook(), 12, true)
+	tests := []struct {
+		line   int
+		offset int
+		col    int
+	}{
+		{2, 0, 2},
+		{2, 4, 9},
+		{3, 14, 8},
+		{3, 0, -1},
+		{1, 0, -1},
+	}
+	for _, test := range tests {
+		if col := lines[test.line].Column(test.offset); col != test.col {
+			t.Errorf("line %d: Column(%d) = %d, want %d", test.line, test.offset, col, test.col)
+		}
+	}
+}
+
+func TestFindLine(t *testing.T) {
+	lines := Format(testBook(), 12, false)
+	tests := []struct {
+		pos  Position
+		line int
+	}{
+		{Position{Para: 0, Offset: 0}, 0},
+		{Position{Para: 0, Offset: 3}, 0},
+		// the separator line before a paragraph is skipped
+		{Position{Para: 1, Offset: 0}, 2},
+		{Position{Para: 1, Offset: 5}, 2},
+		{Position{Para: 1, Offset: 8}, 3},
+		{Position{Para: 1, Offset: 20}, 4},
+		{Position{Para: 2, Offset: 1}, 5},
+		{Position{Para: 5, Offset: 0}, len(lines) - 1},
+	}
+	for _, test := range tests {
+		if line := FindLine(lines, test.pos); line != test.line {
+			t.Errorf("FindLine(%+v) = %d, want %d", test.pos, line, test.line)
+		}
+	}
+}
+
+func TestWordsLeft(t *testing.T) {
+	lines := Format(testBook(), 12, false)
+	left := WordsLeft(lines, Position{Para: 2})
+	want := []int{7, 6, 6, 4, 2, 0, 0, 0, 0}
+	if !reflect.DeepEqual(left, want) {
+		t.Errorf("WordsLeft = %v, want %v", left, want)
+	}
+}
@@ -0,0 +1,359 @@
+package book
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// HTML elements that start a new paragraph
+var htmlBlocks = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"body": true, "dd": true, "div": true, "dl": true, "dt": true,
+	"figcaption": true, "figure": true, "footer": true, "header": true,
+	"li": true, "nav": true, "ol": true, "p": true, "pre": true,
+	"section": true, "table": true, "td": true, "th": true, "tr": true,
+	"ul": true,
+}
+
+// heading levels of HTML elements
+var htmlHeadings = map[string]int{
+	"h1": 1, "h2": 2, "h3": 3, "h4": 4, "h5": 5, "h6": 6,
+}
+
+// inline HTML elements that change text style
+var htmlStyles = map[string]Style{
+	"b":      StyleStrong,
+	"strong": StyleStrong,
+	"i":      StyleEmphasis,
+	"em":     StyleEmphasis,
+	"cite":   StyleEmphasis,
+	"s":      StyleStrike,
+	"strike": StyleStrike,
+	"del":    StyleStrike,
+	"code":   StyleCode,
+	"tt":     StyleCode,
+	"kbd":    StyleCode,
+	"samp":   StyleCode,
+}
+
+// HTML elements which content is never displayed
+var htmlSkipped = map[string]bool{
+	"head":   true,
+	"script": true,
+	"style":  true,
+}
+
+// htmlParser keeps the state of HTML document parsing
+type htmlParser struct {
+	bookBuilder
+	// name of the document inside a book archive. It is a prefix of all
+	// link targets and anchors, so links between documents work
+	file string
+
+	para *paraBuilder
+	kind Kind
+	// level of the current heading, 0 if the text is not a heading
+	heading    int
+	linkStart  int
+	linkTarget string
+}
+
+// parseHTML appends paragraphs of HTML or XHTML document to the book.
+// Headings are added to the book table of contents
+func parseHTML(b *Book, file string, data []byte) error {
+	p := &htmlParser{bookBuilder: bookBuilder{book: b}, file: file}
+	if file != "" {
+		// links to the whole document point to its first paragraph
+		p.pendingAnchors = append(p.pendingAnchors, file)
+	}
+
+	d, err := newXMLDecoder(data)
+	if err != nil {
+		return err
+	}
+	d.AutoClose = xml.HTMLAutoClose
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			p.flush()
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if htmlSkipped[strings.ToLower(t.Name.Local)] {
+				if err := d.Skip(); err != nil {
+					p.flush()
+					return err
+				}
+				continue
+			}
+			p.startElement(t)
+		case xml.EndElement:
+			p.endElement(t)
+		case xml.CharData:
+			p.charData(string(t))
+		}
+	}
+	p.flush()
+
+	return nil
+}
+
+// anchor returns a book-wide link target for an element id
+func (p *htmlParser) anchor(id string) string {
+	return p.file + "#" + id
+}
+
+// resolveLink converts a link to a book-wide link target. It returns
+// an empty string for links to external resources
+func (p *htmlParser) resolveLink(href string) string {
+	if href == "" || strings.Contains(href, ":") {
+		return ""
+	}
+
+	file, frag := href, ""
+	if i := strings.Index(href, "#"); i != -1 {
+		file, frag = href[:i], href[i+1:]
+	}
+	if file == "" {
+		file = p.file
+	} else {
+		if unescaped, err := url.PathUnescape(file); err == nil {
+			file = unescaped
+		}
+		file = path.Join(path.Dir(p.file), file)
+	}
+
+	if frag == "" {
+		return file
+	}
+	return file + "#" + frag
+}
+
+func (p *htmlParser) ensurePara() {
+	if p.para == nil {
+		p.para = &paraBuilder{kind: p.kind}
+	}
+}
+
+// flush adds the collected paragraph to the book
+func (p *htmlParser) flush() {
+	if p.para == nil {
+		return
+	}
+
+	para := p.para.paragraph()
+	p.para = nil
+	if para.Kind == KindEmpty {
+		return
+	}
+	if p.heading > 0 {
+		item := TocItem{Title: para.Text, Level: p.heading - 1, Para: len(p.book.Paragraphs)}
+		p.book.Toc = append(p.book.Toc, item)
+	}
+	p.addParagraph(para)
+}
+
+func (p *htmlParser) startElement(t xml.StartElement) {
+	name := strings.ToLower(t.Name.Local)
+
+	if level, ok := htmlHeadings[name]; ok {
+		p.flush()
+		p.heading = level
+		p.kind = KindSubtitle
+		if level <= 3 {
+			p.kind = KindTitle
+		}
+	} else if htmlBlocks[name] {
+		p.flush()
+		p.kind = KindText
+		p.heading = 0
+	}
+
+	if id := attrValue(t, "id"); id != "" {
+		p.pendingAnchors = append(p.pendingAnchors, p.anchor(id))
+	}
+
+	switch name {
+	case "br":
+		if p.heading > 0 && p.para != nil {
+			// multi-line heading is a single table of contents item
+			p.para.addText(" ")
+		} else {
+			p.flush()
+		}
+	case "hr":
+		p.flush()
+		p.addParagraph(Paragraph{Kind: KindEmpty})
+	case "a":
+		if target := p.resolveLink(attrValue(t, "href")); target != "" {
+			p.ensurePara()
+			p.linkTarget = target
+			p.linkStart = p.para.runes
+		}
+	default:
+		if style, ok := htmlStyles[name]; ok {
+			p.ensurePara()
+			p.para.openSpan(style)
+		}
+	}
+}
+
+func (p *htmlParser) endElement(t xml.EndElement) {
+	name := strings.ToLower(t.Name.Local)
+
+	if _, ok := htmlHeadings[name]; ok || htmlBlocks[name] {
+		p.flush()
+		p.kind = KindText
+		p.heading = 0
+		return
+	}
+
+	switch name {
+	case "a":
+		if p.para != nil && p.linkTarget != "" {
+			p.para.links = append(p.para.links, Link{Start: p.linkStart, End: p.para.runes, Target: p.linkTarget})
+		}
+		p.linkTarget = ""
+	default:
+		if style, ok := htmlStyles[name]; ok && p.para != nil {
+			p.para.closeSpan(style)
+		}
+	}
+}
+
+func (p *htmlParser) charData(s string) {
+	if p.para == nil && strings.TrimSpace(s) == "" {
+		return
+	}
+	p.ensurePara()
+	p.para.addText(s)
+}
+
+// htmlLoader reads standalone HTML and XHTML documents
+type htmlLoader struct{}
+
+func (l htmlLoader) Name() string {
+	return "html"
+}
+
+func (l htmlLoader) Extensions() []string {
+	return []string{".htm", ".html", ".xhtml"}
+}
+
+func (l htmlLoader) Detect(fileName string, data []byte) bool {
+	head, _, _ := decodeBOM(fileHead(data))
+	head = bytes.ToLower(head)
+	if bytes.Contains(head, []byte("<html")) || bytes.Contains(head, []byte("<!doctype html")) {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(fileName))
+	return ext == ".htm" || ext == ".html" || ext == ".xhtml"
+}
+
+func (l htmlLoader) Parse(fileName string, data []byte) (*Book, error) {
+	b := emptyBook()
+	data, err := htmlToUTF8(data)
+	if err != nil {
+		return b, err
+	}
+
+	b.Info = htmlInfo(fileName, data)
+	err = parseHTML(b, "", data)
+	return b, err
+}
+
+func (l htmlLoader) ParseInfo(fileName string, data []byte) (Info, error) {
+	data, err := htmlToUTF8(data)
+	if err != nil {
+		return Info{}, err
+	}
+	return htmlInfo(fileName, data), nil
+}
+
+func htmlInfo(fileName string, data []byte) Info {
+	title := htmlTitle(data)
+	if title == "" {
+		title = titleFromFileName(fileName)
+	}
+	return Info{Title: title}
+}
+
+// htmlCharset returns the encoding from <meta> element of HTML document.
+// It returns an empty string if the document does not declare encoding
+func htmlCharset(data []byte) string {
+	head := bytes.ToLower(fileHead(data))
+	i := bytes.Index(head, []byte("charset="))
+	if i == -1 {
+		return ""
+	}
+	label := head[i+len("charset="):]
+	label = bytes.TrimLeft(label, "\"' ")
+	if end := bytes.IndexAny(label, "\"'; />"); end != -1 {
+		label = label[:end]
+	}
+	return string(label)
+}
+
+// htmlToUTF8 converts HTML document to UTF-8 using encoding from <meta>
+// element. XHTML documents with XML declaration are left as is because
+// the XML decoder converts them itself
+func htmlToUTF8(data []byte) ([]byte, error) {
+	if _, decoded, _ := decodeBOM(fileHead(data)); decoded {
+		return data, nil
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(fileHead(data)), []byte("<?xml")) {
+		return data, nil
+	}
+
+	label := htmlCharset(data)
+	if label == "" || label == "utf-8" || label == "utf8" {
+		return data, nil
+	}
+	return decodeText(data, label)
+}
+
+// htmlTitle returns the content of <title> element of HTML document
+func htmlTitle(data []byte) string {
+	d, err := newXMLDecoder(data)
+	if err != nil {
+		return ""
+	}
+	d.AutoClose = xml.HTMLAutoClose
+
+	inTitle := false
+	var title strings.Builder
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if name == "body" {
+				return ""
+			}
+			inTitle = name == "title"
+		case xml.EndElement:
+			if strings.ToLower(t.Name.Local) == "title" {
+				return strings.Join(strings.Fields(title.String()), " ")
+			}
+		case xml.CharData:
+			if inTitle {
+				title.Write(t)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package book
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHTMLCharset(t *testing.T) {
+	tests := []struct {
+		html    string
+		charset string
+	}{
+		{`<html><head><meta charset="windows-1251"></head>`, "windows-1251"},
+		{`<meta http-equiv="Content-Type" content="text/html; charset=KOI8-R">`, "koi8-r"},
+		{`<meta charset=utf-8/>`, "utf-8"},
+		{`<html><head><title>No charset</title></head>`, ""},
+	}
+	for _, test := range tests {
+		if charset := htmlCharset([]byte(test.html)); charset != test.charset {
+			t.Errorf("htmlCharset(%q) = %q, want %q", test.html, charset, test.charset)
+		}
+	}
+}
+
+func TestHTMLTitle(t *testing.T) {
+	tests := []struct {
+		html  string
+		title string
+	}{
+		{"<html><head><title> Roadside \n Picnic </title></head><body></body></html>", "Roadside Picnic"},
+		{"<HTML><HEAD><TITLE>Upper case</TITLE></HEAD></HTML>", "Upper case"},
+		// the title must be in the document head
+		{"<html><body><title>Body</title></body></html>", ""},
+		{"<p>No title</p>", ""},
+	}
+	for _, test := range tests {
+		if title := htmlTitle([]byte(test.html)); title != test.title {
+			t.Errorf("htmlTitle(%q) = %q, want %q", test.html, title, test.title)
+		}
+	}
+}
+
+func TestParseHTML(t *testing.T) {
+	// HTML document with unclosed elements in a legacy encoding
+	page := `<!DOCTYPE html>
+<html><head><meta charset="windows-1251"><title>Пикник</title></head>
+<body>
+<h1 id="top">Глава 1</h1>
+<p>Первый абзац<br>вторая строка
+<p>Текст с <i>курсивом</i> и <a href="#top">ссылкой</a>.
+<hr>
+<h4>Small</h4>
+<script>var x = 1;</script>
+</body></html>`
+	b, err := htmlLoader{}.Parse("/books/picnic.html", encode(t, page, "windows-1251"))
+	if err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+
+	paras := []Paragraph{
+		{Kind: KindTitle, Text: "Глава 1"},
+		{Kind: KindText, Text: "Первый абзац"},
+		{Kind: KindText, Text: "вторая строка"},
+		{Kind: KindText, Text: "Текст с курсивом и ссылкой.",
+			Links: []Link{{Start: 19, End: 26, Target: "#top"}},
+			Spans: []Span{{Start: 8, End: 16, Style: StyleEmphasis}}},
+		{Kind: KindEmpty},
+		{Kind: KindSubtitle, Text: "Small"},
+	}
+	if got := normalized(b.Paragraphs); !reflect.DeepEqual(got, paras) {
+		t.Errorf("paragraphs:\n%+v\nwant:\n%+v", got, paras)
+	}
+	toc := []TocItem{{Title: "Глава 1", Level: 0, Para: 0}, {Title: "Small", Level: 3, Para: 5}}
+	if !reflect.DeepEqual(b.Toc, toc) {
+		t.Errorf("table of contents %+v, want %+v", b.Toc, toc)
+	}
+	if b.Anchors["#top"] != 0 {
+		t.Errorf("anchors %v, want #top at the first paragraph", b.Anchors)
+	}
+	if b.Info.Title != "Пикник" {
+		t.Errorf("book title %q, want %q", b.Info.Title, "Пикник")
+	}
+
+	info, err := htmlLoader{}.ParseInfo("/books/no title.html", []byte("<p>Text</p>"))
+	if err != nil || info.Title != "no title" {
+		t.Errorf("title of a document without a title %q, %v, want the file name", info.Title, err)
+	}
+}
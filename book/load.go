@@ -0,0 +1,221 @@
+package book
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+var zipMagic = []byte("PK\x03\x04")
+
+// the number of bytes from the file start used to detect the file format
+const detectLength = 4096
+
+// Loader reads books of a single format
+type Loader interface {
+	// Name returns a short name of the format
+	Name() string
+	// Extensions returns file extensions of the format in lower case.
+	// They are used to find books in a directory without reading files
+	Extensions() []string
+	// Detect returns true if the file content is in the loader format.
+	// The file name is only a hint for formats that cannot be reliably
+	// detected by content
+	Detect(fileName string, data []byte) bool
+	// Parse returns the book description and all its paragraphs
+	Parse(fileName string, data []byte) (*Book, error)
+	// ParseInfo returns only the book description. It is used to add
+	// many books to the library at once, so it should not parse the text
+	// if possible
+	ParseInfo(fileName string, data []byte) (Info, error)
+}
+
+var loaders []Loader
+
+// RegisterLoader adds a loader for a new book format. Loaders are checked
+// in the order they are registered, and the first loader that detects
+// the format reads the book
+func RegisterLoader(l Loader) {
+	loaders = append(loaders, l)
+}
+
+func init() {
+	// plain text loader accepts any text, so it must be the last one
+	RegisterLoader(epubLoader{})
+	RegisterLoader(fb2Loader{})
+	RegisterLoader(htmlLoader{})
+	RegisterLoader(markdownLoader{})
+	RegisterLoader(textLoader{})
+}
+
+func emptyBook() *Book {
+	return &Book{Anchors: make(map[string]int), NotesPara: -1}
+}
+
+// fileHead returns the first bytes of the file used to detect its format
+func fileHead(data []byte) []byte {
+	n := len(data)
+	if n > detectLength {
+		// keep UTF-16 characters whole
+		n = detectLength &^ 1
+	}
+	return data[:n]
+}
+
+// titleFromFileName generates a book title for formats without metadata
+func titleFromFileName(fileName string) string {
+	base := filepath.Base(fileName)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+func openZip(data []byte) (*zip.Reader, error) {
+	return zip.NewReader(bytes.NewReader(data), int64(len(data)))
+}
+
+// IsBookFile returns true if the file extension is one of extensions of
+// the registered formats
+func IsBookFile(fileName string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for _, l := range loaders {
+		for _, e := range l.Extensions() {
+			if e == ext {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FindLoader returns the loader that can read the file. It returns nil
+// if the file format is unknown
+func FindLoader(fileName string, data []byte) Loader {
+	for _, l := range loaders {
+		if l.Detect(fileName, data) {
+			return l
+		}
+	}
+	return nil
+}
+
+// ParseBook reads a book file and returns the book description and all its
+// paragraphs. The file format is detected by its content with registered
+// loaders: EPUB, FB2 (plain or zipped), HTML, Markdown, and plain text
+func ParseBook(fileName string) (*Book, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return emptyBook(), err
+	}
+
+	l := FindLoader(fileName, data)
+	if l == nil {
+		return emptyBook(), fmt.Errorf("unsupported book format")
+	}
+	return l.Parse(fileName, data)
+}
+
+// ParseBookInfo reads a book file and returns only the book description
+func ParseBookInfo(fileName string) (Info, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return Info{}, err
+	}
+
+	l := FindLoader(fileName, data)
+	if l == nil {
+		return Info{}, fmt.Errorf("unsupported book format")
+	}
+	return l.ParseInfo(fileName, data)
+}
+
+// fb2Loader reads FB2 books, both plain and zipped
+type fb2Loader struct{}
+
+func (l fb2Loader) Name() string {
+	return "fb2"
+}
+
+func (l fb2Loader) Extensions() []string {
+	// zipped books usually have extension .fb2.zip
+	return []string{".fb2", ".zip"}
+}
+
+func (l fb2Loader) Detect(fileName string, data []byte) bool {
+	if bytes.HasPrefix(data, zipMagic) {
+		zr, err := openZip(data)
+		return err == nil && zipHasFB2(zr)
+	}
+
+	head, _, _ := decodeBOM(fileHead(data))
+	return bytes.Contains(head, []byte("<FictionBook"))
+}
+
+// unpack extracts FB2 file from the archive if the book is zipped
+func (l fb2Loader) unpack(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, zipMagic) {
+		return data, nil
+	}
+	zr, err := openZip(data)
+	if err != nil {
+		return nil, err
+	}
+	return unzipFB2(zr)
+}
+
+func (l fb2Loader) Parse(fileName string, data []byte) (*Book, error) {
+	data, err := l.unpack(data)
+	if err != nil {
+		return emptyBook(), err
+	}
+	return parseFB2(data, false)
+}
+
+func (l fb2Loader) ParseInfo(fileName string, data []byte) (Info, error) {
+	data, err := l.unpack(data)
+	if err != nil {
+		return Info{}, err
+	}
+	b, err := parseFB2(data, true)
+	return b.Info, err
+}
+
+// epubLoader reads EPUB books
+type epubLoader struct{}
+
+func (l epubLoader) Name() string {
+	return "epub"
+}
+
+func (l epubLoader) Extensions() []string {
+	return []string{".epub"}
+}
+
+func (l epubLoader) Detect(fileName string, data []byte) bool {
+	if !bytes.HasPrefix(data, zipMagic) {
+		return false
+	}
+	zr, err := openZip(data)
+	return err == nil && isEPUB(zr)
+}
+
+func (l epubLoader) Parse(fileName string, data []byte) (*Book, error) {
+	zr, err := openZip(data)
+	if err != nil {
+		return emptyBook(), err
+	}
+	return parseEPUB(zr)
+}
+
+func (l epubLoader) ParseInfo(fileName string, data []byte) (Info, error) {
+	zr, err := openZip(data)
+	if err != nil {
+		return Info{}, err
+	}
+	_, pkg, err := readEPUBPackage(zr)
+	if err != nil {
+		return Info{}, err
+	}
+	return epubInfo(pkg), nil
+}
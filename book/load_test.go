@@ -0,0 +1,58 @@
+package book
+
+import (
+	"testing"
+)
+
+func TestFindLoader(t *testing.T) {
+	tests := []struct {
+		fileName string
+		data     []byte
+		loader   string
+	}{
+		{"picnic.fb2", []byte(testFB2), "fb2"},
+		{"picnic.fb2.zip", zipFile(t, "picnic.fb2", []byte(testFB2)), "fb2"},
+		// the content is more important than the extension
+		{"picnic.zip", zipFiles(t, testEPUB()), "epub"},
+		{"picnic.epub", zipFiles(t, testEPUB()), "epub"},
+		{"picnic.txt", []byte(testFB2), "fb2"},
+		{"page.html", []byte("<p>Text</p>"), "html"},
+		{"page.txt", []byte("<!DOCTYPE html>\n<html><body><p>Text</p></body></html>"), "html"},
+		{"notes.md", []byte("# Notes"), "markdown"},
+		{"notes.txt", []byte("# Notes"), "text"},
+		{"README", []byte("Plain text"), "text"},
+		{"image.png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), ""},
+		{"archive.zip", zipFile(t, "picnic.txt", []byte("Text")), ""},
+	}
+	for _, test := range tests {
+		name := ""
+		if l := FindLoader(test.fileName, test.data); l != nil {
+			name = l.Name()
+		}
+		if name != test.loader {
+			t.Errorf("FindLoader(%q) = %q, want %q", test.fileName, name, test.loader)
+		}
+	}
+}
+
+func TestIsBookFile(t *testing.T) {
+	tests := []struct {
+		fileName string
+		book     bool
+	}{
+		{"/books/picnic.fb2", true},
+		{"/books/PICNIC.FB2", true},
+		{"/books/picnic.fb2.zip", true},
+		{"/books/picnic.epub", true},
+		{"/books/notes.md", true},
+		{"/books/page.xhtml", true},
+		{"/books/readme.txt", true},
+		{"/books/cover.jpg", false},
+		{"/books/picnic", false},
+	}
+	for _, test := range tests {
+		if book := IsBookFile(test.fileName); book != test.book {
+			t.Errorf("IsBookFile(%q) = %v, want %v", test.fileName, book, test.book)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package book
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// markdownLoader reads Markdown documents. Markdown is a plain text, so
+// it is detected only by file extension
+type markdownLoader struct{}
+
+func (l markdownLoader) Name() string {
+	return "markdown"
+}
+
+func (l markdownLoader) Extensions() []string {
+	return []string{".md", ".markdown", ".mkd"}
+}
+
+func (l markdownLoader) Detect(fileName string, data []byte) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for _, e := range l.Extensions() {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func (l markdownLoader) Parse(fileName string, data []byte) (*Book, error) {
+	b := emptyBook()
+	data, err := textToUTF8(data)
+	if err != nil {
+		return b, err
+	}
+
+	p := &markdownParser{bookBuilder: bookBuilder{book: b}}
+	p.parse(splitLines(string(data)))
+	if b.Info.Title == "" {
+		b.Info.Title = titleFromFileName(fileName)
+	}
+	return b, nil
+}
+
+// ParseInfo parses the whole document because the title is the first
+// heading that can be anywhere in the text
+func (l markdownLoader) ParseInfo(fileName string, data []byte) (Info, error) {
+	b, err := l.Parse(fileName, data)
+	return b.Info, err
+}
+
+// markdownParser keeps the state of Markdown document parsing
+type markdownParser struct {
+	bookBuilder
+	// lines of the current paragraph
+	lines []string
+	kind  Kind
+	// marker of the current fenced code block, empty outside code blocks
+	fence string
+}
+
+func (p *markdownParser) parse(lines []string) {
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		if p.fence != "" {
+			if strings.HasPrefix(trimmed, p.fence) {
+				p.fence = ""
+				continue
+			}
+			p.addCode(line)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			p.flush()
+			p.fence = trimmed[:3]
+		case trimmed == "":
+			p.flush()
+		case strings.HasPrefix(trimmed, "#"):
+			level := 0
+			for level < len(trimmed) && trimmed[level] == '#' {
+				level++
+			}
+			if level > 6 || (level < len(trimmed) && trimmed[level] != ' ') {
+				p.addLine(KindText, trimmed)
+				break
+			}
+			p.flush()
+			p.addHeading(level, strings.Trim(trimmed[level:], "# "))
+		case isSetextUnderline(trimmed) && len(p.lines) != 0 && p.kind == KindText:
+			text := strings.Join(p.lines, " ")
+			p.lines = p.lines[:0]
+			level := 1
+			if trimmed[0] == '-' {
+				level = 2
+			}
+			p.addHeading(level, text)
+		case isThematicBreak(trimmed):
+			p.flush()
+			p.addParagraph(Paragraph{Kind: KindEmpty})
+		case strings.HasPrefix(trimmed, ">"):
+			p.addLine(KindEpigraph, strings.TrimSpace(strings.TrimLeft(trimmed, ">")))
+		default:
+			if marker := listMarker(trimmed); marker != "" {
+				p.flush()
+				// numbers of ordered lists are kept as is
+				if strings.ContainsAny(marker, "-*+") {
+					trimmed = "•" + trimmed[len(marker):]
+				}
+			}
+			p.addLine(KindText, trimmed)
+		}
+	}
+	p.flush()
+}
+
+// addLine appends a line to the current paragraph. A line of different
+// kind starts a new paragraph
+func (p *markdownParser) addLine(kind Kind, line string) {
+	if len(p.lines) != 0 && p.kind != kind {
+		p.flush()
+	}
+	p.kind = kind
+	p.lines = append(p.lines, line)
+}
+
+// addCode adds a line of a fenced code block as a separate paragraph
+func (p *markdownParser) addCode(line string) {
+	para := &paraBuilder{kind: KindText}
+	para.openSpan(StyleCode)
+	para.addText(line)
+	p.addParagraph(para.paragraph())
+}
+
+func (p *markdownParser) addHeading(level int, text string) {
+	kind := KindSubtitle
+	if level <= 3 {
+		kind = KindTitle
+	}
+	para := &paraBuilder{kind: kind}
+	p.inline(para, text)
+	heading := para.paragraph()
+	if heading.Kind == KindEmpty {
+		return
+	}
+
+	if level == 1 && p.book.Info.Title == "" {
+		p.book.Info.Title = heading.Text
+	}
+	p.pendingAnchors = append(p.pendingAnchors, headingAnchor(heading.Text))
+	p.book.Toc = append(p.book.Toc, TocItem{Title: heading.Text, Level: level - 1, Para: len(p.book.Paragraphs)})
+	p.addParagraph(heading)
+}
+
+// flush adds the collected lines as a paragraph
+func (p *markdownParser) flush() {
+	if len(p.lines) == 0 {
+		return
+	}
+	para := &paraBuilder{kind: p.kind}
+	p.inline(para, strings.Join(p.lines, " "))
+	p.lines = p.lines[:0]
+	if res := para.paragraph(); res.Kind != KindEmpty {
+		p.addParagraph(res)
+	}
+}
+
+// headingAnchor generates a link target for a heading the same way
+// GitHub does: lowercase words joined with dashes
+func headingAnchor(text string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_':
+			sb.WriteRune(r)
+		case r == ' ':
+			sb.WriteRune('-')
+		}
+	}
+	return sb.String()
+}
+
+func isRepeated(line string, chars string) bool {
+	line = strings.Replace(line, " ", "", -1)
+	if len(line) < 3 {
+		return false
+	}
+	for _, c := range chars {
+		if strings.Trim(line, string(c)) == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func isSetextUnderline(line string) bool {
+	return !strings.Contains(line, " ") && isRepeated(line, "=-")
+}
+
+func isThematicBreak(line string) bool {
+	return isRepeated(line, "-*_")
+}
+
+// listMarker returns the marker of a list item, or an empty string if
+// the line is not a list item
+func listMarker(line string) string {
+	if len(line) > 1 && strings.ContainsRune("-*+", rune(line[0])) && line[1] == ' ' {
+		return line[:1]
+	}
+	i := 0
+	for i < len(line) && line[i] >= '0' && line[i] <= '9' {
+		i++
+	}
+	if i > 0 && i+1 < len(line) && (line[i] == '.' || line[i] == ')') && line[i+1] == ' ' {
+		return line[:i+1]
+	}
+	return ""
+}
+
+// inline adds text with inline Markdown markup to the paragraph: emphasis,
+// strong emphasis, strikethrough, code spans, links and images
+func (p *markdownParser) inline(para *paraBuilder, s string) {
+	runes := []rune(s)
+	open := make(map[string]bool)
+	isSpace := func(i int) bool {
+		return i < 0 || i >= len(runes) || unicode.IsSpace(runes[i])
+	}
+	isWord := func(i int) bool {
+		return i >= 0 && i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]))
+	}
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		marker := string(r)
+		if i+1 < len(runes) && runes[i+1] == r && (r == '*' || r == '_' || r == '~') {
+			marker += string(r)
+		}
+
+		switch {
+		case r == '\\' && i+1 < len(runes):
+			i++
+			para.addText(string(runes[i]))
+		case r == '`':
+			end := indexRune(runes, i+1, '`')
+			if end == -1 {
+				para.addText(marker)
+				continue
+			}
+			para.openSpan(StyleCode)
+			para.addText(string(runes[i+1 : end]))
+			para.closeSpan(StyleCode)
+			i = end
+		case r == '*' || r == '_' || marker == "~~":
+			style := StyleEmphasis
+			if marker == "~~" {
+				style = StyleStrike
+			} else if len(marker) == 2 {
+				style = StyleStrong
+			}
+			n := len([]rune(marker))
+			canOpen := !isSpace(i + n)
+			canClose := !isSpace(i - 1)
+			if r == '_' {
+				// underscores inside words are not markup
+				canOpen = canOpen && !isWord(i-1)
+				canClose = canClose && !isWord(i+n)
+			}
+			switch {
+			case open[marker] && canClose:
+				para.closeSpan(style)
+				open[marker] = false
+			case !open[marker] && canOpen:
+				para.openSpan(style)
+				open[marker] = true
+			default:
+				para.addText(marker)
+			}
+			i += n - 1
+		case r == '[' || (r == '!' && i+1 < len(runes) && runes[i+1] == '['):
+			start := i + 1
+			if r == '!' {
+				start++
+			}
+			textEnd := indexRune(runes, start, ']')
+			if textEnd == -1 || textEnd+1 >= len(runes) || runes[textEnd+1] != '(' {
+				para.addText(marker)
+				continue
+			}
+			targetEnd := indexRune(runes, textEnd+2, ')')
+			if targetEnd == -1 {
+				para.addText(marker)
+				continue
+			}
+
+			linkStart := para.runes
+			p.inline(para, string(runes[start:textEnd]))
+			target := strings.Fields(string(runes[textEnd+2 : targetEnd]))
+			// only links inside the document can be followed
+			if r == '[' && len(target) != 0 && strings.HasPrefix(target[0], "#") {
+				para.links = append(para.links, Link{Start: linkStart, End: para.runes, Target: target[0][1:]})
+			}
+			i = targetEnd
+		default:
+			para.addText(string(r))
+		}
+	}
+}
+
+func indexRune(runes []rune, from int, r rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,107 @@
+package book
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testMarkdown = "# Roadside Picnic\n" +
+	"\n" +
+	"Intro *emphasis* and **strong** and ~~gone~~ and `code` text,\n" +
+	"continued line with snake_case_name.\n" +
+	"\n" +
+	"Setext Heading\n" +
+	"--------------\n" +
+	"\n" +
+	"> quoted line\n" +
+	"> second quote\n" +
+	"\n" +
+	"- item one\n" +
+	"- item two\n" +
+	"1. first\n" +
+	"\n" +
+	"See [the zone](#setext-heading) or [site](http://example.com) ![img](pic.png).\n" +
+	"\n" +
+	"***\n" +
+	"\n" +
+	"```go\n" +
+	"x := 1\n" +
+	"```\n" +
+	"#### Small heading ####\n" +
+	"#NotHeading\n"
+
+func TestParseMarkdown(t *testing.T) {
+	b, err := markdownLoader{}.Parse("/books/notes.md", []byte(testMarkdown))
+	if err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+
+	paras := []Paragraph{
+		{Kind: KindTitle, Text: "Roadside Picnic"},
+		{Kind: KindText, Text: "Intro emphasis and strong and gone and code text, continued line with snake_case_name.",
+			Spans: []Span{{6, 14, StyleEmphasis}, {19, 25, StyleStrong}, {30, 34, StyleStrike}, {39, 43, StyleCode}}},
+		{Kind: KindTitle, Text: "Setext Heading"},
+		{Kind: KindEpigraph, Text: "quoted line second quote"},
+		{Kind: KindText, Text: "• item one"},
+		{Kind: KindText, Text: "• item two"},
+		{Kind: KindText, Text: "1. first"},
+		// only links inside the document are kept
+		{Kind: KindText, Text: "See the zone or site img.", Links: []Link{{Start: 4, End: 12, Target: "setext-heading"}}},
+		{Kind: KindEmpty},
+		{Kind: KindText, Text: "x := 1", Spans: []Span{{0, 6, StyleCode}}},
+		{Kind: KindSubtitle, Text: "Small heading"},
+		{Kind: KindText, Text: "#NotHeading"},
+	}
+	if got := normalized(b.Paragraphs); !reflect.DeepEqual(got, paras) {
+		t.Errorf("paragraphs:\n%+v\nwant:\n%+v", got, paras)
+	}
+	toc := []TocItem{
+		{Title: "Roadside Picnic", Level: 0, Para: 0},
+		{Title: "Setext Heading", Level: 1, Para: 2},
+		{Title: "Small heading", Level: 3, Para: 10},
+	}
+	if !reflect.DeepEqual(b.Toc, toc) {
+		t.Errorf("table of contents %+v, want %+v", b.Toc, toc)
+	}
+	anchors := map[string]int{"roadside-picnic": 0, "setext-heading": 2, "small-heading": 10}
+	if !reflect.DeepEqual(b.Anchors, anchors) {
+		t.Errorf("anchors %v, want %v", b.Anchors, anchors)
+	}
+	if b.Info.Title != "Roadside Picnic" {
+		t.Errorf("book title %q, want the first heading", b.Info.Title)
+	}
+}
+
+func TestMarkdownTitle(t *testing.T) {
+	tests := []struct {
+		text  string
+		title string
+	}{
+		{"## Section\n\n# Title\n", "Title"},
+		{"Text\n\n## Section\n", "notes"},
+		{"Title\n=====\n", "Title"},
+	}
+	for _, test := range tests {
+		info, err := markdownLoader{}.ParseInfo("/books/notes.md", []byte(test.text))
+		if err != nil || info.Title != test.title {
+			t.Errorf("title of %q = %q, %v, want %q", test.text, info.Title, err, test.title)
+		}
+	}
+}
+
+func TestHeadingAnchor(t *testing.T) {
+	tests := []struct {
+		text   string
+		anchor string
+	}{
+		{"Roadside Picnic", "roadside-picnic"},
+		{"What's new in v2.0?", "whats-new-in-v20"},
+		{"snake_case and-dash", "snake_case-and-dash"},
+		{"Глава 1", "глава-1"},
+	}
+	for _, test := range tests {
+		if anchor := headingAnchor(test.text); anchor != test.anchor {
+			t.Errorf("headingAnchor(%q) = %q, want %q", test.text, anchor, test.anchor)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package book
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Match is a found occurrence of a search text. Start and End are rune
+// offsets inside paragraph text
+type Match struct {
+	Para  int
+	Start int
+	End   int
+}
+
+// Position returns the position of the first character of the match
+func (m Match) Position() Position {
+	return Position{Para: m.Para, Offset: m.Start}
+}
+
+func foldRunes(s string) []rune {
+	runes := []rune(s)
+	for i, r := range runes {
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+func runesEqual(r1, r2 []rune) bool {
+	for i := range r1 {
+		if r1[i] != r2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Search looks for all occurrences of text in the book paragraphs ignoring
+// case. Paragraphs are searched as a whole, so a match can be split between
+// a few formatted lines. Whitespaces in text are squeezed the same way as
+// it is done for paragraph text
+func Search(b *Book, text string) []Match {
+	matches := make([]Match, 0)
+	query := foldRunes(strings.Join(strings.Fields(text), " "))
+	if len(query) == 0 {
+		return matches
+	}
+
+	for i, para := range b.Paragraphs {
+		runes := foldRunes(para.Text)
+		for start := 0; start+len(query) <= len(runes); start++ {
+			if runesEqual(query, runes[start:start+len(query)]) {
+				matches = append(matches, Match{Para: i, Start: start, End: start + len(query)})
+				start += len(query) - 1
+			}
+		}
+	}
+
+	return matches
+}
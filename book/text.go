@@ -0,0 +1,158 @@
+package book
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// lines longer than this are whole paragraphs, not wrapped parts of them
+	unwrappedLineLength = 200
+	// only short lines can be chapter titles
+	maxTitleLength = 60
+)
+
+// words that usually start a chapter title in plain texts
+var chapterWords = []string{
+	"chapter", "part", "book", "prologue", "epilogue",
+	"глава", "часть", "книга", "пролог", "эпилог",
+}
+
+// textLoader reads plain texts. It accepts any file that does not look
+// binary, so it must be registered after all other loaders
+type textLoader struct{}
+
+func (l textLoader) Name() string {
+	return "text"
+}
+
+func (l textLoader) Extensions() []string {
+	return []string{".txt"}
+}
+
+func (l textLoader) Detect(fileName string, data []byte) bool {
+	head, _, err := decodeBOM(fileHead(data))
+	return err == nil && bytes.IndexByte(head, 0) == -1
+}
+
+func (l textLoader) Parse(fileName string, data []byte) (*Book, error) {
+	b := emptyBook()
+	data, err := textToUTF8(data)
+	if err != nil {
+		return b, err
+	}
+	b.Info.Title = titleFromFileName(fileName)
+
+	p := &textParser{bookBuilder: bookBuilder{book: b}}
+	p.parse(splitLines(string(data)))
+	return b, nil
+}
+
+func (l textLoader) ParseInfo(fileName string, data []byte) (Info, error) {
+	return Info{Title: titleFromFileName(fileName)}, nil
+}
+
+// splitLines splits text into lines with any line endings
+func splitLines(text string) []string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, "\r", "\n", -1)
+	return strings.Split(text, "\n")
+}
+
+// textParser detects paragraphs and chapter titles in a plain text
+type textParser struct {
+	bookBuilder
+	lines []string
+	// true if the paragraph follows an empty line or starts the text
+	afterBlank bool
+}
+
+// parse splits the text into paragraphs. A paragraph ends at an empty line.
+// If the text is hard-wrapped, a paragraph also ends at a short line, and
+// a line with indentation starts a new paragraph. Otherwise every line is
+// a paragraph
+func (p *textParser) parse(lines []string) {
+	widest := 0
+	for _, line := range lines {
+		if n := utf8.RuneCountInString(strings.TrimSpace(line)); n > widest {
+			widest = n
+		}
+	}
+	wrapped := widest <= unwrappedLineLength
+
+	p.afterBlank = true
+	prevShort := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			p.flush(true)
+			p.afterBlank = true
+			continue
+		}
+
+		indented := line[0] == ' ' || line[0] == '\t'
+		if !wrapped || indented || prevShort {
+			p.flush(false)
+		}
+		p.lines = append(p.lines, trimmed)
+		prevShort = utf8.RuneCountInString(trimmed) < widest*2/3
+	}
+	p.flush(true)
+}
+
+// flush adds the collected lines as a paragraph. beforeBlank is true if
+// the paragraph is followed by an empty line or ends the text
+func (p *textParser) flush(beforeBlank bool) {
+	if len(p.lines) == 0 {
+		return
+	}
+
+	para := &paraBuilder{kind: KindText}
+	para.addText(strings.Join(p.lines, " "))
+	isTitle := p.afterBlank && beforeBlank && len(p.lines) == 1 && isTitleLine(p.lines[0])
+	if isTitle {
+		para.kind = KindTitle
+		p.book.Toc = append(p.book.Toc, TocItem{Title: p.lines[0], Para: len(p.book.Paragraphs)})
+	}
+	p.addParagraph(para.paragraph())
+
+	p.lines = p.lines[:0]
+	p.afterBlank = false
+}
+
+// isTitleLine returns true if a standalone line looks like a chapter
+// title: it is short, does not end with punctuation and either is
+// in upper case or starts with a word like 'Chapter'
+func isTitleLine(line string) bool {
+	if utf8.RuneCountInString(line) > maxTitleLength {
+		return false
+	}
+	last, _ := utf8.DecodeLastRuneInString(line)
+	if strings.ContainsRune(".,;:!?…-—\"»", last) {
+		return false
+	}
+
+	lower := strings.ToLower(line)
+	for _, w := range chapterWords {
+		if !strings.HasPrefix(lower, w) {
+			continue
+		}
+		next, _ := utf8.DecodeRuneInString(lower[len(w):])
+		if !unicode.IsLetter(next) {
+			return true
+		}
+	}
+
+	hasLetter := false
+	for _, r := range line {
+		if unicode.IsLower(r) {
+			return false
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			hasLetter = true
+		}
+	}
+	return hasLetter
+}
@@ -0,0 +1,97 @@
+package book
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsTitleLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		title bool
+	}{
+		{"Chapter 1", true},
+		{"CHAPTER ONE", true},
+		{"Глава 5. Зона", true},
+		{"Глава 5", true},
+		{"PART II", true},
+		{"1984", true},
+		{"Chapters of life", false},
+		{"Introduction", false},
+		{"THE END.", false},
+		{"---", false},
+		{"", false},
+		{strings.Repeat("LONG ", 13), false},
+	}
+	for _, test := range tests {
+		if title := isTitleLine(test.line); title != test.title {
+			t.Errorf("isTitleLine(%q) = %v, want %v", test.line, title, test.title)
+		}
+	}
+}
+
+func TestParseText(t *testing.T) {
+	long := strings.TrimSpace(strings.Repeat("word ", 45))
+	tests := []struct {
+		name  string
+		text  string
+		paras []Paragraph
+		toc   []TocItem
+	}{
+		{"wrapped", "CHAPTER ONE\r\n\r\nThis is a wrapped paragraph that\r\ncontinues on the next line.\r\n" +
+			"  Indented line starts a new one\r\nand goes on.\r\n",
+			[]Paragraph{
+				{Kind: KindTitle, Text: "CHAPTER ONE"},
+				{Kind: KindText, Text: "This is a wrapped paragraph that continues on the next line."},
+				{Kind: KindText, Text: "Indented line starts a new one and goes on."},
+			},
+			[]TocItem{{Title: "CHAPTER ONE", Para: 0}}},
+		// a short line ends a paragraph of a wrapped text
+		{"short line", "The first paragraph of the text\nends here.\nThe second paragraph starts\nafter it.",
+			[]Paragraph{
+				{Kind: KindText, Text: "The first paragraph of the text ends here."},
+				{Kind: KindText, Text: "The second paragraph starts after it."},
+			},
+			nil},
+		// every line of a text that is not wrapped is a paragraph. A title
+		// must be surrounded with empty lines
+		{"not wrapped", long + "\nChapter 1\n\nChapter 2\n\nText",
+			[]Paragraph{
+				{Kind: KindText, Text: long},
+				{Kind: KindText, Text: "Chapter 1"},
+				{Kind: KindTitle, Text: "Chapter 2"},
+				{Kind: KindText, Text: "Text"},
+			},
+			[]TocItem{{Title: "Chapter 2", Para: 2}}},
+	}
+	for _, test := range tests {
+		b, err := textLoader{}.Parse("/books/My Book.txt", []byte(test.text))
+		if err != nil {
+			t.Errorf("%s: parsing failed: %v", test.name, err)
+			continue
+		}
+		if got := normalized(b.Paragraphs); !reflect.DeepEqual(got, test.paras) {
+			t.Errorf("%s: paragraphs:\n%+v\nwant:\n%+v", test.name, got, test.paras)
+		}
+		if !reflect.DeepEqual(b.Toc, test.toc) {
+			t.Errorf("%s: table of contents %+v, want %+v", test.name, b.Toc, test.toc)
+		}
+		if b.Info.Title != "My Book" {
+			t.Errorf("%s: book title %q, want %q", test.name, b.Info.Title, "My Book")
+		}
+	}
+}
+
+func TestParseTextEncoding(t *testing.T) {
+	for _, enc := range []string{"windows-1251", "koi8-r"} {
+		b, err := textLoader{}.Parse("picnic.txt", encode(t, russian, enc))
+		if err != nil {
+			t.Errorf("%s: parsing failed: %v", enc, err)
+			continue
+		}
+		if len(b.Paragraphs) != 1 || b.Paragraphs[0].Text != russian {
+			t.Errorf("%s: paragraphs %+v, want %q", enc, b.Paragraphs, russian)
+		}
+	}
+}
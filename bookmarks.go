@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	ui "github.com/VladimirMarkelov/clui"
+	"github.com/VladimirMarkelov/termfb2/book"
+	"github.com/VladimirMarkelov/termfb2/common"
+	cf "github.com/VladimirMarkelov/termfb2/config"
+	xs "github.com/huandu/xstrings"
+	term "github.com/nsf/termbox-go"
+	"strings"
+)
+
+const bookmarkNameLength = 40
+
+// defaultBookmarkName generates a bookmark name from the text of the first
+// non-empty line starting from the top line of the reader
+func defaultBookmarkName(conf *cf.Config, top int) string {
+	for ind := top; ind < len(conf.Lines); ind++ {
+		text := strings.TrimSpace(conf.Lines[ind].Text)
+		if text == "" {
+			continue
+		}
+		if xs.Len(text) > bookmarkNameLength {
+			text = xs.Slice(text, 0, bookmarkNameLength)
+		}
+		return text
+	}
+	return ""
+}
+
+// addBookmark asks for a bookmark name and adds the top line of the reader
+// to bookmarks of the opened book
+func addBookmark(controls *ControlList, conf *cf.Config) {
+	if !conf.UseDb || conf.LastFile == "" || len(conf.Lines) == 0 {
+		return
+	}
+
+	top := controls.reader.TopLine()
+	pos := conf.Lines[top].Position()
+	createInputDialog("New bookmark", defaultBookmarkName(conf, top), func(name string) {
+		// bookmarks are kept inside the book record, so the book must be
+		// in the library before adding a bookmark
+		err := saveBookPosition(conf)
+		if err == nil {
+			bm := common.Bookmark{Name: name, Para: pos.Para, Offset: pos.Offset}
+			err = conf.DbDriver.AddBookmark(conf.LastFile, bm)
+		}
+		if err != nil {
+			showError("Library error", fmt.Sprintf("Failed to add bookmark: %v", err))
+		}
+	})
+}
+
+// Generate a text for TableView control that displays bookmarks
+func getBookmarkColumnText(conf *cf.Config, bm common.Bookmark, col int) string {
+	line := book.FindLine(conf.Lines, book.Position{Para: bm.Para, Offset: bm.Offset})
+	text := ""
+	switch col {
+	case 0:
+		text = bm.Name
+	case 1:
+		text = fmt.Sprintf("%v%%", (line+1)*100/len(conf.Lines))
+	case 2:
+		text = bm.Added
+	case 3:
+		text = strings.TrimSpace(conf.Lines[line].Text)
+	}
+
+	return text
+}
+
+// Creates and shows a bookmark list of the opened book - available only if
+// library is ON
+func createBookmarkDialog(controls *ControlList, conf *cf.Config) {
+	if !conf.UseDb || conf.LastFile == "" || len(conf.Lines) == 0 {
+		return
+	}
+
+	controls.bookmarkWindow = ui.AddWindow(0, 0, 12, 7, "Bookmarks")
+	controls.bookmarkWindow.SetPack(ui.Vertical)
+	controls.bookmarkWindow.SetModal(true)
+
+	controls.bookmarkTable = ui.CreateTableView(controls.bookmarkWindow, minWidth, minHeight, 1)
+	ui.ActivateControl(controls.bookmarkWindow, controls.bookmarkTable)
+	controls.bookmarkTable.SetShowLines(true)
+	controls.bookmarkTable.SetShowRowNumber(true)
+	controls.bookmarkWindow.SetMaximized(true)
+
+	controls.bookmarkTable.SetRowCount(len(conf.DbDriver.Bookmarks(conf.LastFile)))
+
+	cols := []ui.Column{
+		ui.Column{Title: "Name", Width: 25, Alignment: ui.AlignLeft},
+		ui.Column{Title: "Done", Width: 4, Alignment: ui.AlignRight},
+		ui.Column{Title: "Added", Width: 20, Alignment: ui.AlignLeft},
+		ui.Column{Title: "Text", Width: 100, Alignment: ui.AlignLeft},
+	}
+	controls.bookmarkTable.SetColumns(cols)
+
+	// Enter jumps to the selected bookmark, F2 renames it
+	// Escape closes the dialog without doing anything
+	controls.bookmarkWindow.OnKeyDown(func(ev ui.Event, data interface{}) bool {
+		switch ev.Key {
+		case term.KeyEsc:
+			go ui.PutEvent(ui.Event{Type: ui.EventCloseWindow})
+			return true
+		case term.KeyEnter:
+			row := controls.bookmarkTable.SelectedRow()
+			marks := conf.DbDriver.Bookmarks(conf.LastFile)
+			if row != -1 && row < len(marks) {
+				pos := book.Position{Para: marks[row].Para, Offset: marks[row].Offset}
+				controls.reader.SetTopLine(book.FindLine(conf.Lines, pos))
+			}
+			go ui.PutEvent(ui.Event{Type: ui.EventCloseWindow})
+			return true
+		case term.KeyF2:
+			row := controls.bookmarkTable.SelectedRow()
+			marks := conf.DbDriver.Bookmarks(conf.LastFile)
+			if row != -1 && row < len(marks) {
+				createInputDialog("Rename bookmark", marks[row].Name, func(name string) {
+					if err := conf.DbDriver.RenameBookmark(conf.LastFile, row, name); err != nil {
+						showError("Library error", fmt.Sprintf("Failed to rename bookmark: %v", err))
+					}
+				})
+			}
+			return true
+		}
+		return false
+	}, nil)
+
+	controls.bookmarkTable.OnDrawCell(func(info *ui.ColumnDrawInfo) {
+		marks := conf.DbDriver.Bookmarks(conf.LastFile)
+		if info.Row >= len(marks) {
+			return
+		}
+		info.Text = getBookmarkColumnText(conf, marks[info.Row], info.Col)
+	})
+
+	controls.bookmarkTable.OnAction(func(ev ui.TableEvent) {
+		if ev.Action != ui.TableActionDelete || ev.Row == -1 {
+			return
+		}
+
+		err := conf.DbDriver.DeleteBookmark(conf.LastFile, ev.Row)
+		controls.bookmarkTable.SetRowCount(len(conf.DbDriver.Bookmarks(conf.LastFile)))
+		if err != nil {
+			showError("Library error", fmt.Sprintf("Failed to delete bookmark: %v", err))
+		}
+	})
+}
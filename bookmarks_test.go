@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/VladimirMarkelov/termfb2/book"
+	cf "github.com/VladimirMarkelov/termfb2/config"
+	"testing"
+)
+
+func TestDefaultBookmarkName(t *testing.T) {
+	conf := &cf.Config{Lines: []book.Line{
+		{Text: "Chapter 1"},
+		{Text: "   "},
+		{Text: "  Пикник на обочине. Аркадий и Борис Стругацкие, 1972"},
+	}}
+	tests := []struct {
+		top  int
+		name string
+	}{
+		{0, "Chapter 1"},
+		// empty lines are skipped, long names are cut
+		{1, "Пикник на обочине. Аркадий и Борис Струг"},
+		{3, ""},
+	}
+	for _, test := range tests {
+		if name := defaultBookmarkName(conf, test.top); name != test.name {
+			t.Errorf("defaultBookmarkName(%d) = %q, want %q", test.top, name, test.name)
+		}
+	}
+}
@@ -0,0 +1,739 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/VladimirMarkelov/termfb2/book"
+	"github.com/VladimirMarkelov/termfb2/common"
+	cf "github.com/VladimirMarkelov/termfb2/config"
+	"github.com/VladimirMarkelov/termfb2/index"
+	"github.com/VladimirMarkelov/termfb2/scan"
+	"github.com/VladimirMarkelov/termfb2/stats"
+	"io/ioutil"
+	"os"
+	path "path/filepath"
+	"strings"
+	"time"
+)
+
+// openArgs are the arguments of the reader UI
+type openArgs struct {
+	fileName string
+	// the paragraph to show at the top of the reader, -1 - restore the
+	// saved reading position
+	para int
+}
+
+// bookEntry is a library book in JSON output of subcommands
+type bookEntry struct {
+	Id        string   `json:"id"`
+	FilePath  string   `json:"path"`
+	FirstName string   `json:"firstName"`
+	LastName  string   `json:"lastName"`
+	Title     string   `json:"title"`
+	Sequence  string   `json:"sequence"`
+	SeqNumber int      `json:"sequenceNumber"`
+	Genre     string   `json:"genre"`
+	Language  string   `json:"language"`
+	Added     string   `json:"added"`
+	Completed string   `json:"completed"`
+	Progress  int      `json:"progress"`
+	Para      int      `json:"para"`
+	Offset    int      `json:"offset"`
+	Bookmarks int      `json:"bookmarks"`
+	Hash      string   `json:"hash"`
+	DocId     string   `json:"docId"`
+	Tags      []string `json:"tags"`
+	Rating    int      `json:"rating"`
+	Missing   bool     `json:"missing"`
+}
+
+// fileEntry is a book file description in JSON output of info subcommand
+type fileEntry struct {
+	FilePath   string     `json:"path"`
+	Format     string     `json:"format"`
+	Hash       string     `json:"hash"`
+	FirstName  string     `json:"firstName"`
+	LastName   string     `json:"lastName"`
+	Title      string     `json:"title"`
+	Sequence   string     `json:"sequence"`
+	SeqNumber  int        `json:"sequenceNumber"`
+	Genre      string     `json:"genre"`
+	Language   string     `json:"language"`
+	DocId      string     `json:"docId"`
+	Paragraphs int        `json:"paragraphs"`
+	Chapters   int        `json:"chapters"`
+	Library    *bookEntry `json:"library"`
+}
+
+// importEntry is a directory import summary in JSON output
+type importEntry struct {
+	Dir      string   `json:"dir"`
+	Added    int      `json:"added"`
+	Relinked int      `json:"relinked"`
+	Skipped  int      `json:"skipped"`
+	Failed   int      `json:"failed"`
+	Errors   []string `json:"errors"`
+}
+
+// textHitEntry is a found paragraph in JSON output of search subcommand
+type textHitEntry struct {
+	FilePath string `json:"path"`
+	Title    string `json:"title"`
+	Para     int    `json:"para"`
+	Offset   int    `json:"offset"`
+	Exact    bool   `json:"exact"`
+	Snippet  string `json:"snippet"`
+}
+
+// libraryStats is the output of stats subcommand
+type libraryStats struct {
+	Books     int `json:"books"`
+	Completed int `json:"completed"`
+	Reading   int `json:"reading"`
+	Unread    int `json:"unread"`
+	Missing   int `json:"missing"`
+	Bookmarks int `json:"bookmarks"`
+	// reading sessions statistics
+	Periods        []stats.Period `json:"periods"`
+	Days           []stats.Period `json:"days"`
+	Weeks          []stats.Period `json:"weeks"`
+	Months         []stats.Period `json:"months"`
+	WordsPerMinute float64        `json:"wordsPerMinute"`
+	Current        *currentBook   `json:"current,omitempty"`
+}
+
+// currentBook is the time left to finish the last opened book
+type currentBook struct {
+	FilePath    string `json:"path"`
+	WordsLeft   int    `json:"wordsLeft"`
+	SecondsLeft int64  `json:"secondsLeft"`
+}
+
+const cliUsage = `Usage:
+  termfb2 [FILE]
+  termfb2 open [--position N] FILE
+  termfb2 list [--json] [FILTER...]
+  termfb2 info [--json] FILE
+  termfb2 import [--json] DIRECTORY [DIRECTORY...]
+  termfb2 remove [--json] ID [ID...]
+  termfb2 search [--json] TEXT...
+  termfb2 stats [--json]
+  termfb2 export [--width N] [--justify] [--header] [--notes] [--output FILE] FILE`
+
+// runCommand executes a command line subcommand and exits. If the
+// arguments do not start with a subcommand that exits, it returns the
+// arguments of the reader UI: the first argument is a book to open
+func runCommand(conf *cf.Config, args []string) openArgs {
+	if len(args) == 0 {
+		return openArgs{para: -1}
+	}
+
+	if run, found := cliCommands[args[0]]; found {
+		printConfigErrors(conf)
+		os.Exit(run(conf, args[1:]))
+	}
+	switch args[0] {
+	case "open":
+		return parseOpenArgs(args[1:])
+	case "help":
+		fmt.Println(cliUsage)
+		os.Exit(0)
+	}
+	return openArgs{fileName: args[0], para: -1}
+}
+
+// cliCommands are subcommands that exit after they are executed
+var cliCommands = map[string]func(conf *cf.Config, args []string) int{
+	"list":   runList,
+	"info":   runInfo,
+	"import": runImport,
+	"remove": runRemove,
+	"search": runFindText,
+	"stats":  runStats,
+	"export": runExport,
+}
+
+// printConfigErrors prints errors of the configuration file. The reader
+// UI shows them in a dialog instead
+func printConfigErrors(conf *cf.Config) {
+	if len(conf.ConfigErrors) != 0 {
+		fmt.Fprintf(os.Stderr, "Configuration error:\n%s\n", strings.Join(conf.ConfigErrors, "\n"))
+	}
+}
+
+// newFlagSet creates a parser of subcommand flags that prints the usage
+// of all subcommands on error
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, cliUsage)
+	}
+	return flags
+}
+
+// usageError prints the error and the usage of all subcommands. It
+// returns the process exit code
+func usageError(msg string) int {
+	fmt.Fprintln(os.Stderr, msg)
+	fmt.Fprintln(os.Stderr, cliUsage)
+	return 2
+}
+
+func parseOpenArgs(args []string) openArgs {
+	flags := newFlagSet("open")
+	para := flags.Int("position", -1, "paragraph to open the book at")
+	if err := flags.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	if flags.NArg() != 1 {
+		os.Exit(usageError("open: exactly one book file expected"))
+	}
+	if *para < -1 {
+		os.Exit(usageError("open: position must be a paragraph number starting from 0"))
+	}
+	return openArgs{fileName: flags.Arg(0), para: *para}
+}
+
+// openLibrary reads the library for a subcommand. It returns false if
+// the library is disabled or cannot be opened. Damaged records are
+// reported, but the rest of the library is still usable
+func openLibrary(conf *cf.Config) bool {
+	if !conf.UseDb {
+		fmt.Fprintln(os.Stderr, "The library is disabled in the configuration file")
+		return false
+	}
+	if err := conf.InitDatabase(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return conf.UseDb
+}
+
+// printJSON writes the value to stdout. It returns the process exit code
+func printJSON(v interface{}) int {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(data))
+	return 0
+}
+
+func newBookEntry(conf *cf.Config, b common.BookRecord) bookEntry {
+	e := bookEntry{
+		Id:        b.Id,
+		FilePath:  b.FilePath,
+		FirstName: b.FirstName,
+		LastName:  b.LastName,
+		Title:     b.Title,
+		Sequence:  b.Sequence,
+		SeqNumber: b.SeqNumber,
+		Genre:     b.Genre,
+		Language:  b.Language,
+		Added:     b.Added,
+		Completed: b.Completed,
+		Para:      b.ParaLast,
+		Offset:    b.OffsetLast,
+		Bookmarks: len(conf.DbDriver.Bookmarks(b.FilePath)),
+		Hash:      b.Hash,
+		DocId:     b.DocId,
+		Tags:      b.Tags,
+		Rating:    b.Rating,
+	}
+	if e.Tags == nil {
+		e.Tags = make([]string, 0)
+	}
+	e.Progress = b.Percent()
+	if _, err := os.Stat(b.FilePath); err != nil {
+		e.Missing = true
+	}
+	return e
+}
+
+// runList prints all library books that match the filter: one book per
+// line with tab separated id, progress, author, title, and file path
+func runList(conf *cf.Config, args []string) int {
+	flags := newFlagSet("list")
+	asJSON := flags.Bool("json", false, "print books in JSON format")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if !openLibrary(conf) {
+		return 1
+	}
+
+	// a query can be passed as a few arguments without quoting
+	conf.DbDriver.SetFilter(strings.Join(flags.Args(), " "))
+	if err := conf.DbDriver.FilterError(); err != nil {
+		fmt.Fprintf(os.Stderr, "list: invalid filter: %v\n", err)
+		return 2
+	}
+	books := conf.DbDriver.FilteredBooks()
+	if err := conf.DbDriver.ListError(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read the library: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		entries := make([]bookEntry, 0, len(books))
+		for _, b := range books {
+			entries = append(entries, newBookEntry(conf, b))
+		}
+		return printJSON(entries)
+	}
+
+	for _, b := range books {
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\n", b.Id, getBookColumnText(b, 2),
+			getBookColumnText(b, 0), b.Title, b.FilePath)
+	}
+	return 0
+}
+
+// runInfo prints the description of a book file and its library record
+func runInfo(conf *cf.Config, args []string) int {
+	flags := newFlagSet("info")
+	asJSON := flags.Bool("json", false, "print book information in JSON format")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		return usageError("info: exactly one book file expected")
+	}
+
+	fileName, err := path.Abs(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid path '%s': %v\n", flags.Arg(0), err)
+		return 1
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read book '%s': %v\n", fileName, err)
+		return 1
+	}
+	l := book.FindLoader(fileName, data)
+	if l == nil {
+		fmt.Fprintf(os.Stderr, "Failed to open book '%s': unsupported book format\n", fileName)
+		return 1
+	}
+	bk, err := l.Parse(fileName, data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open book '%s': %v\n", fileName, err)
+		return 1
+	}
+
+	info := fileEntry{
+		FilePath:   fileName,
+		Format:     l.Name(),
+		Hash:       scan.ContentHash(data),
+		FirstName:  bk.Info.FirstName,
+		LastName:   bk.Info.LastName,
+		Title:      bk.Info.Title,
+		Sequence:   bk.Info.Sequence,
+		SeqNumber:  bk.Info.SeqNumber,
+		Genre:      bk.Info.Genre,
+		Language:   bk.Info.Language,
+		DocId:      bk.Info.Id,
+		Paragraphs: len(bk.Paragraphs),
+		Chapters:   len(bk.Toc),
+	}
+	// the book description is printed even if the library is disabled
+	if conf.UseDb && openLibrary(conf) {
+		b, found := conf.DbDriver.BookByFilePath(fileName)
+		if !found {
+			b, found = conf.DbDriver.BookByHash(info.Hash)
+		}
+		if found {
+			e := newBookEntry(conf, b)
+			info.Library = &e
+		}
+	}
+
+	if *asJSON {
+		return printJSON(info)
+	}
+
+	fmt.Printf("File:       %s\n", info.FilePath)
+	fmt.Printf("Format:     %s\n", info.Format)
+	fmt.Printf("Author:     %s %s\n", info.FirstName, info.LastName)
+	fmt.Printf("Title:      %s\n", info.Title)
+	fmt.Printf("Sequence:   %s\n", book.SequenceText(info.Sequence, info.SeqNumber))
+	fmt.Printf("Genre:      %s\n", info.Genre)
+	fmt.Printf("Language:   %s\n", info.Language)
+	fmt.Printf("Document:   %s\n", info.DocId)
+	fmt.Printf("Paragraphs: %d\n", info.Paragraphs)
+	fmt.Printf("Chapters:   %d\n", info.Chapters)
+	if info.Library == nil {
+		fmt.Println("Library:    not in the library")
+		return 0
+	}
+	e := info.Library
+	fmt.Printf("Library:    %s\n", e.Id)
+	fmt.Printf("Added:      %s\n", e.Added)
+	fmt.Printf("Completed:  %s\n", e.Completed)
+	fmt.Printf("Progress:   %d%% (paragraph %d)\n", e.Progress, e.Para)
+	fmt.Printf("Bookmarks:  %d\n", e.Bookmarks)
+	return 0
+}
+
+// runImport adds all books from the directories to the library and
+// prints the progress. It returns the process exit code
+func runImport(conf *cf.Config, args []string) int {
+	flags := newFlagSet("import")
+	asJSON := flags.Bool("json", false, "print import summary in JSON format")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	dirs := flags.Args()
+	if len(dirs) == 0 {
+		return usageError("import: no directory to import")
+	}
+	if !openLibrary(conf) {
+		return 1
+	}
+
+	// JSON output contains only the summary
+	var progress scan.Progress
+	if !*asJSON {
+		progress = func(done, total int, fileName string) {
+			fmt.Printf("[%d/%d] %s\n", done+1, total, fileName)
+		}
+	}
+
+	code := 0
+	entries := make([]importEntry, 0, len(dirs))
+	for _, dir := range dirs {
+		res, err := scan.ImportDir(conf.DbDriver, dir, progress)
+		if err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("Failed to scan '%s': %v", dir, err))
+		}
+		if err != nil || res.Failed != 0 {
+			code = 1
+		}
+		if *asJSON {
+			e := importEntry{Dir: dir, Added: res.Added, Relinked: res.Relinked,
+				Skipped: res.Skipped, Failed: res.Failed, Errors: res.Errors}
+			if e.Errors == nil {
+				e.Errors = []string{}
+			}
+			entries = append(entries, e)
+			continue
+		}
+
+		for _, e := range res.Errors {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		if err == nil {
+			fmt.Printf("%s: %s\n", dir, res)
+		}
+	}
+
+	if !updateIndexCli(conf, !*asJSON) {
+		code = 1
+	}
+	if *asJSON && printJSON(entries) != 0 {
+		return 1
+	}
+	return code
+}
+
+// updateIndexCli adds library books that are not indexed yet to the
+// full-text index. Errors are printed to stderr. It returns false if some
+// books cannot be indexed
+func updateIndexCli(conf *cf.Config, showProgress bool) bool {
+	var progress index.Progress
+	if showProgress {
+		progress = func(done, total int, fileName string) {
+			fmt.Printf("Indexing [%d/%d] %s\n", done+1, total, fileName)
+		}
+	}
+
+	// indices of books that are not in the list are removed, so an
+	// incomplete list must not be used
+	books := conf.DbDriver.BookList()
+	if err := conf.DbDriver.ListError(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read the library: %v\n", err)
+		return false
+	}
+	errs, err := conf.TextIndex().Update(books, progress)
+	for _, e := range errs {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to update the search index: %v\n", err)
+		return false
+	}
+	return len(errs) == 0
+}
+
+// runFindText prints paragraphs of library books that contain all words
+// of the text: one paragraph per line with tab separated file path,
+// paragraph number, and the text around the found words. The paragraph
+// number can be passed to open subcommand
+func runFindText(conf *cf.Config, args []string) int {
+	flags := newFlagSet("search")
+	asJSON := flags.Bool("json", false, "print found paragraphs in JSON format")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	text := strings.Join(flags.Args(), " ")
+	if len(index.Words(text)) == 0 {
+		return usageError("search: no text to search")
+	}
+	if !openLibrary(conf) {
+		return 1
+	}
+
+	// books that cannot be read are reported, but other books are searched
+	updateIndexCli(conf, false)
+	hits, complete, err := conf.TextIndex().Search(text, maxTextHits)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to search text: %v\n", err)
+		return 1
+	}
+	if !complete {
+		fmt.Fprintf(os.Stderr, "Only the first %d books with all words have been searched, use a more specific text\n",
+			index.MaxParsedBooks)
+	}
+
+	if *asJSON {
+		entries := make([]textHitEntry, 0, len(hits))
+		for _, hit := range hits {
+			e := textHitEntry{FilePath: hit.FilePath, Para: hit.Para, Offset: hit.Offset,
+				Exact: hit.Exact, Snippet: hit.Snippet}
+			if b, found := conf.DbDriver.BookByFilePath(hit.FilePath); found {
+				e.Title = b.Title
+			}
+			entries = append(entries, e)
+		}
+		return printJSON(entries)
+	}
+
+	for _, hit := range hits {
+		fmt.Printf("%s\t%d\t%s\n", hit.FilePath, hit.Para, hit.Snippet)
+	}
+	return 0
+}
+
+// runRemove deletes books from the library by their ids. Book files are
+// not deleted
+func runRemove(conf *cf.Config, args []string) int {
+	flags := newFlagSet("remove")
+	asJSON := flags.Bool("json", false, "print removed books in JSON format")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		return usageError("remove: no book id")
+	}
+	if !openLibrary(conf) {
+		return 1
+	}
+
+	// a book is deleted by its index in the filtered list, so the list
+	// must contain all books
+	conf.DbDriver.SetFilter("")
+	conf.DbDriver.SetMissingOnly(false)
+
+	code := 0
+	removed := make([]bookEntry, 0, flags.NArg())
+	for _, id := range flags.Args() {
+		idx := -1
+		books := conf.DbDriver.FilteredBooks()
+		for i, b := range books {
+			if b.Id == id {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			fmt.Fprintf(os.Stderr, "Book '%s' is not in the library\n", id)
+			code = 1
+			continue
+		}
+
+		entry := newBookEntry(conf, books[idx])
+		if err := conf.DbDriver.DeleteBookByIndex(idx); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to remove book '%s': %v\n", id, err)
+			code = 1
+			continue
+		}
+		removed = append(removed, entry)
+		if !*asJSON {
+			fmt.Printf("Removed %s\t%s\t%s\n", entry.Id, entry.Title, entry.FilePath)
+		}
+	}
+
+	if *asJSON && printJSON(removed) != 0 {
+		return 1
+	}
+	return code
+}
+
+// printPeriods prints a table of period statistics after an empty line.
+// title is the header of the first column
+func printPeriods(title string, periods []stats.Period) {
+	fmt.Println()
+	for i, col := range periodColumns {
+		if i != 0 {
+			fmt.Print("\t")
+		}
+		if i == 0 {
+			col = title
+		}
+		fmt.Print(col)
+	}
+	fmt.Println()
+	for _, p := range periods {
+		for i := range periodColumns {
+			if i != 0 {
+				fmt.Print("\t")
+			}
+			fmt.Print(periodColumnText(p, i))
+		}
+		fmt.Println()
+	}
+}
+
+// runStats prints the number of library books by their reading state and
+// the reading statistics of sessions
+func runStats(conf *cf.Config, args []string) int {
+	flags := newFlagSet("stats")
+	asJSON := flags.Bool("json", false, "print statistics in JSON format")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		return usageError("stats: too many arguments")
+	}
+	if !openLibrary(conf) {
+		return 1
+	}
+
+	books := conf.DbDriver.BookList()
+	if err := conf.DbDriver.ListError(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read the library: %v\n", err)
+		return 1
+	}
+	var st libraryStats
+	for _, b := range books {
+		e := newBookEntry(conf, b)
+		st.Books++
+		st.Bookmarks += e.Bookmarks
+		switch {
+		case b.Completed != "":
+			st.Completed++
+		case b.ParaLast > 0 || b.LineLast > 0:
+			st.Reading++
+		default:
+			st.Unread++
+		}
+		if e.Missing {
+			st.Missing++
+		}
+	}
+
+	sum := stats.Collect(conf.DbDriver, time.Now())
+	st.Periods = sum.Periods
+	st.Days = sum.Days
+	st.Weeks = sum.Weeks
+	st.Months = sum.Months
+	st.WordsPerMinute = sum.WordsPerMinute
+	wordsLeft := lastBookWordsLeft(conf)
+	if wordsLeft >= 0 {
+		st.Current = &currentBook{
+			FilePath:    conf.LastFile,
+			WordsLeft:   wordsLeft,
+			SecondsLeft: int64(sum.TimeLeft(wordsLeft) / time.Second),
+		}
+	}
+
+	if *asJSON {
+		return printJSON(st)
+	}
+	fmt.Printf("Books:     %d\n", st.Books)
+	fmt.Printf("Completed: %d\n", st.Completed)
+	fmt.Printf("Reading:   %d\n", st.Reading)
+	fmt.Printf("Unread:    %d\n", st.Unread)
+	fmt.Printf("Missing:   %d\n", st.Missing)
+	fmt.Printf("Bookmarks: %d\n", st.Bookmarks)
+	printPeriods(periodColumns[0], st.Periods)
+	for i, periods := range historyPeriods(sum) {
+		printPeriods(historyTitles[i], periods)
+	}
+	fmt.Println()
+	for _, s := range speedText(sum, wordsLeft) {
+		fmt.Println(s)
+	}
+	return 0
+}
+
+// lastBookWordsLeft returns the number of words after the saved position
+// of the last opened book. It returns -1 if the book cannot be read
+func lastBookWordsLeft(conf *cf.Config) int {
+	if conf.LastFile == "" {
+		return -1
+	}
+	para, offset := conf.LastPara, conf.LastOffset
+	if para < 0 {
+		b, found := conf.DbDriver.BookByFilePath(conf.LastFile)
+		if !found || b.PosVersion < common.POS_VERSION {
+			return -1
+		}
+		para, offset = b.ParaLast, b.OffsetLast
+	}
+
+	bk, err := book.ParseBook(conf.LastFile)
+	if err != nil {
+		return -1
+	}
+	return bk.CountWords(book.Position{Para: para, Offset: offset}, bk.TextEnd())
+}
+
+// runExport formats a book and writes it as plain text to stdout or a file
+func runExport(conf *cf.Config, args []string) int {
+	flags := newFlagSet("export")
+	width := flags.Int("width", conf.ExportWidth, "line width")
+	justify := flags.Bool("justify", conf.Justify, "make all text lines the same width")
+	header := flags.Bool("header", false, "write the book description before the text")
+	notes := flags.Bool("notes", false, "append notes and comments after the text")
+	output := flags.String("output", "", "output file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		return usageError("export: exactly one book file expected")
+	}
+	if *width <= 0 {
+		return usageError("export: width must be a positive number")
+	}
+
+	fileName := flags.Arg(0)
+	bk, err := book.ParseBook(fileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open book '%s': %v\n", fileName, err)
+		return 1
+	}
+
+	w := os.Stdout
+	if *output != "" {
+		w, err = os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create file '%s': %v\n", *output, err)
+			return 1
+		}
+	}
+
+	opts := book.ExportOptions{Width: *width, Justify: *justify, Header: *header, Notes: *notes}
+	err = book.Export(w, bk, opts)
+	if *output != "" {
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to export book '%s': %v\n", fileName, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/VladimirMarkelov/termfb2/common"
+	cf "github.com/VladimirMarkelov/termfb2/config"
+	"io/ioutil"
+	"os"
+	path "path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const picnicFB2 = `<?xml version="1.0" encoding="UTF-8"?>
+<FictionBook><description><title-info>
+<author><first-name>Arkady</first-name><last-name>Strugatsky</last-name></author>
+<book-title>Roadside Picnic</book-title><lang>en</lang>
+</title-info></description>
+<body><section><title><p>Chapter 1</p></title><p>Nobody knows what the Zone is.</p></section></body>
+</FictionBook>`
+
+// runCaptured runs the subcommand and returns its exit code and what it
+// has written to stdout and stderr
+func runCaptured(t *testing.T, run func(*cf.Config, []string) int, conf *cf.Config, args ...string) (int, string, string) {
+	files := make([]*os.File, 2)
+	for i := range files {
+		f, err := ioutil.TempFile(t.TempDir(), "out")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		files[i] = f
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = files[0], files[1]
+	code := run(conf, args)
+	os.Stdout, os.Stderr = stdout, stderr
+
+	out := make([]string, len(files))
+	for i, f := range files {
+		data, err := ioutil.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[i] = string(data)
+	}
+	return code, out[0], out[1]
+}
+
+// decodeOutput parses JSON output of a subcommand
+func decodeOutput(t *testing.T, name, out string, v interface{}) {
+	if err := json.Unmarshal([]byte(out), v); err != nil {
+		t.Fatalf("%s: invalid JSON output %q: %v", name, out, err)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(*cf.Config, []string) int
+		args []string
+	}{
+		{"list", runList, []string{"--unknown"}},
+		{"info", runInfo, nil},
+		{"info", runInfo, []string{"a.fb2", "b.fb2"}},
+		{"import", runImport, nil},
+		{"remove", runRemove, nil},
+		{"search", runFindText, []string{"..."}},
+		{"stats", runStats, []string{"extra"}},
+		{"export", runExport, nil},
+	}
+	// the library is not opened before the arguments are checked
+	conf := &cf.Config{UseDb: true}
+	for _, test := range tests {
+		code, out, errs := runCaptured(t, test.run, conf, test.args...)
+		if code != 2 || out != "" || !strings.Contains(errs, "Usage:") {
+			t.Errorf("%s %v: exit code %d, output %q, errors %q, want 2 and usage", test.name, test.args, code, out, errs)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	// the library is kept in the home directory. Both backends use the
+	// same directory, so the sqlite library is created first: a new one
+	// imports books of the scribble library
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	picnic, notes := path.Join(dir, "picnic.fb2"), path.Join(dir, "notes.txt")
+	for fileName, text := range map[string]string{picnic: picnicFB2, notes: "A few notes.\n"} {
+		if err := ioutil.WriteFile(fileName, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, backend := range []string{common.DB_SQLITE, common.DB_SCRIBBLE} {
+		conf := cf.InitConfig()
+		conf.DbBackend = backend
+
+		code, out, errs := runCaptured(t, runImport, conf, "--json", dir)
+		var imported []importEntry
+		decodeOutput(t, backend, out, &imported)
+		want := []importEntry{{Dir: dir, Added: 2, Errors: []string{}}}
+		if code != 0 || !reflect.DeepEqual(imported, want) {
+			t.Errorf("%s: import exit code %d, %+v, errors %q, want 0 and %+v", backend, code, imported, errs, want)
+		}
+
+		// tags are always a list in JSON output
+		code, out, _ = runCaptured(t, runList, conf, "--json", "author:strugatsky")
+		var books []bookEntry
+		decodeOutput(t, backend, out, &books)
+		if code != 0 || len(books) != 1 || !strings.Contains(out, `"tags": []`) {
+			t.Fatalf("%s: list exit code %d, output %s, want 0 and one book", backend, code, out)
+		}
+		b := books[0]
+		if b.FilePath != picnic || b.LastName != "Strugatsky" || b.Title != "Roadside Picnic" ||
+			b.Language != "en" || b.Progress != 0 || b.Missing || b.Id == "" || b.Added == "" {
+			t.Errorf("%s: listed book %+v", backend, b)
+		}
+
+		code, out, _ = runCaptured(t, runList, conf)
+		lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+		line := b.Id + "\t0%\tStrugatsky, Arkady\tRoadside Picnic\t" + picnic
+		if code != 0 || len(lines) != 2 || !strings.Contains(out, line+"\n") {
+			t.Errorf("%s: list exit code %d, output %q, want 0 and line %q", backend, code, out, line)
+		}
+
+		code, _, errs = runCaptured(t, runList, conf, "done:abc")
+		if code != 2 || !strings.Contains(errs, "invalid filter") {
+			t.Errorf("%s: list with invalid filter exit code %d, errors %q, want 2", backend, code, errs)
+		}
+
+		code, out, _ = runCaptured(t, runInfo, conf, "--json", picnic)
+		var info fileEntry
+		decodeOutput(t, backend, out, &info)
+		if code != 0 || info.Format != "fb2" || info.Title != "Roadside Picnic" || info.Paragraphs != 2 ||
+			info.Chapters != 1 || info.Library == nil || info.Library.Id != b.Id {
+			t.Errorf("%s: info exit code %d, %+v, want the library book %s", backend, code, info, b.Id)
+		}
+
+		code, out, _ = runCaptured(t, runFindText, conf, "--json", "zone")
+		var hits []textHitEntry
+		decodeOutput(t, backend, out, &hits)
+		if code != 0 || len(hits) != 1 || hits[0].FilePath != picnic || hits[0].Title != "Roadside Picnic" ||
+			hits[0].Para != 1 {
+			t.Errorf("%s: search exit code %d, hits %+v, want paragraph 1 of %s", backend, code, hits, picnic)
+		}
+
+		// unknown ids are reported, other books are removed
+		code, out, errs = runCaptured(t, runRemove, conf, "--json", "unknown", b.Id)
+		var removed []bookEntry
+		decodeOutput(t, backend, out, &removed)
+		if code != 1 || len(removed) != 1 || removed[0].Id != b.Id || !strings.Contains(errs, "'unknown'") {
+			t.Errorf("%s: remove exit code %d, removed %+v, errors %q, want 1 and book %s", backend, code, removed, errs, b.Id)
+		}
+
+		code, out, _ = runCaptured(t, runStats, conf, "--json")
+		var st libraryStats
+		decodeOutput(t, backend, out, &st)
+		if code != 0 || st.Books != 1 || st.Unread != 1 || st.Missing != 0 {
+			t.Errorf("%s: stats exit code %d, %+v, want one unread book", backend, code, st)
+		}
+	}
+}
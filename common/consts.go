@@ -5,8 +5,21 @@ const (
 	LASTFILE     = "last"
 	DBFILE       = "book.db"
 	DBCOLLECTION = "books"
+	SQLITEFILE   = "book.sqlite"
 	VENDOR       = ".rionnag"
 	APPNAME      = "termfb2"
+	// directory inside DBFILE for book records that cannot be read
+	QUARANTINEDIR = "quarantine"
+	// file inside DBFILE with the version of scribble records
+	DBVERSIONFILE = "version"
+	// directory of the full-text index of library books
+	INDEXDIR = "book.idx"
+)
+
+// library database backends
+const (
+	DB_SCRIBBLE = "scribble"
+	DB_SQLITE   = "sqlite"
 )
 
 const (
@@ -16,5 +29,26 @@ const (
 	FIELD_COMPLETED = "completed"
 	FIELD_GENRE     = "genre"
 	FIELD_PERCENT   = "percent"
+	FIELD_RATING    = "rating"
+	FIELD_TAGS      = "tags"
+	// sequence name and the number of the book in the sequence
+	FIELD_SEQUENCE = "sequence"
+	// how well books match a fuzzy filter, the best matches go first
+	// in descending order
+	FIELD_SCORE = "score"
 )
 
+// library filter modes
+const (
+	FILTER_SUBSTRING = "substring"
+	FILTER_FUZZY     = "fuzzy"
+)
+
+// POS_VERSION is the current version of the reading position format. Records
+// with older version keep only the formatted line number
+const POS_VERSION = 1
+
+// WORDS_PER_PAGE is the size of a page in reading statistics. It does not
+// depend on the reader size, so the statistics of different sessions can
+// be compared
+const WORDS_PER_PAGE = 250
@@ -0,0 +1,17 @@
+package common
+
+import (
+	"fmt"
+)
+
+// QuarantineError is returned when some library records cannot be read.
+// The records are moved to Dir, so the library works without them and
+// the records can be fixed manually
+type QuarantineError struct {
+	Dir   string
+	Files []string
+}
+
+func (e *QuarantineError) Error() string {
+	return fmt.Sprintf("%d damaged library records have been moved to '%s'", len(e.Files), e.Dir)
+}
@@ -1,30 +1,117 @@
 package common
 
+// Bookmark is a named position inside a book
+type Bookmark struct {
+	Name   string
+	Para   int
+	Offset int
+	Added  string
+}
+
+// Session is a period of continuous reading of a book. Start and End are
+// in RFC3339 format, positions are width independent
+type Session struct {
+	Start       string
+	End         string
+	StartPara   int
+	StartOffset int
+	EndPara     int
+	EndOffset   int
+	// the number of words read during the session. Jumps to other parts
+	// of the book are not counted
+	Words int
+	// the number of sessions merged into this one, 0 if the session has
+	// not been compacted. A merged session lasts as long as all its
+	// sessions together
+	Merged int
+}
+
 type BookRecord struct {
 	// internal
 	FilePath string
 	Id       string
+	// SHA-1 of the book file content, empty for books added by old versions
+	Hash string
+	// FB2 document id or EPUB identifier
+	DocId     string
 	Added     string
 	Completed string
 	LineLast  int
 	LineTotal int
+	// width independent position: paragraph index and rune offset inside it
+	ParaLast   int
+	OffsetLast int
+	PosVersion int
+	// number of paragraphs of the main text, 0 if the position was saved
+	// before it was stored. Used to show the reading progress
+	ParaTotal int
+	// bookmarks sorted by their position in the book
+	Bookmarks []Bookmark
+	// reading sessions in the order they started
+	Sessions []Session
+	// shelves the book is on, e.g. "to read" or "work"
+	Tags []string
+	// 1 to MAX_RATING stars, 0 if the book is not rated
+	Rating int
 	// from FB2
 	FirstName string
 	LastName  string
 	Title     string
 	Sequence  string
+	// number of the book in the sequence, 0 if it is unknown
+	SeqNumber int
 	Language  string
 	Genre     string
 }
 
+// Position is a reading position in a book. Line and Total depend on the
+// reader width and are used only to restore positions saved by old
+// versions. Para and Offset point to the exact text and do not depend on
+// book formatting. ParaTotal is the number of paragraphs of the main text
+type Position struct {
+	Line      int
+	Total     int
+	Para      int
+	Offset    int
+	ParaTotal int
+}
+
+// BookDb is a book library. All methods that change the library return
+// an error if the change has not been saved
 type BookDb interface {
-	ReadDatabase()
+	ReadDatabase() error
 	SetFilter(filter string)
 	Filter() string
+	// FilterError returns the error of the filter query, nil if the
+	// filter is valid
+	FilterError() error
+	// SetFilterMode selects how plain text filters match books:
+	// FILTER_SUBSTRING or FILTER_FUZZY
+	SetFilterMode(mode string)
 	FilteredBooks() []BookRecord
-	DeleteBookByIndex(index int)
+	DeleteBookByIndex(index int) error
 	BookList() []BookRecord
-	UpdateBookInDb(bookPath string, position, length int, bookInfo *BookRecord)
+	// ListError returns the error of the last reading of FilteredBooks or
+	// BookList, nil if they are read successfully. The lists keep previous
+	// books if reading fails
+	ListError() error
+	UpdateBookInDb(bookPath string, pos Position, bookInfo *BookRecord) error
+	AddBook(bookInfo *BookRecord) error
+	BookByHash(hash string) (BookRecord, bool)
+	// BooksByContent returns books which content hash or document id is
+	// the same as the given one. Empty hash and id never match
+	BooksByContent(hash, docId string) []BookRecord
+	RelinkBook(oldPath, newPath, hash string) error
+	SetMissingOnly(missing bool)
+	MissingOnly() bool
 	SetSortMode(field string, asc bool)
 	BookByFilePath(filePath string) (BookRecord, bool)
+	Bookmarks(bookPath string) []Bookmark
+	AddBookmark(bookPath string, bookmark Bookmark) error
+	RenameBookmark(bookPath string, index int, name string) error
+	DeleteBookmark(bookPath string, index int) error
+	Sessions(bookPath string) []Session
+	SaveSession(bookPath string, session Session) error
+	SetTags(bookPath string, tags []string) error
+	SetRating(bookPath string, rating int) error
 }
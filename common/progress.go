@@ -0,0 +1,23 @@
+package common
+
+// Percent returns the reading progress of the book in percents. It is
+// the saved paragraph relative to the number of paragraphs of the main
+// text, so it does not depend on the reader width.
+// Records saved before the paragraph count was stored have only the
+// formatted line number. They are not updated when the library is loaded
+// because it requires parsing every book file: their progress is
+// calculated from the line number until the book is opened and its
+// position is saved again
+func (b *BookRecord) Percent() int {
+	if b.ParaTotal > 0 {
+		if b.ParaLast >= b.ParaTotal {
+			// the position is in the notes after the main text
+			return 100
+		}
+		return b.ParaLast * 100 / b.ParaTotal
+	}
+	if b.LineTotal == 0 {
+		return 0
+	}
+	return b.LineLast * 100 / b.LineTotal
+}
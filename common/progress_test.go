@@ -0,0 +1,28 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		name    string
+		book    BookRecord
+		percent int
+	}{
+		{"unread", BookRecord{}, 0},
+		{"paragraphs", BookRecord{ParaLast: 30, ParaTotal: 120}, 25},
+		{"start", BookRecord{ParaLast: 0, ParaTotal: 120, LineLast: 0, LineTotal: 3000}, 0},
+		{"notes", BookRecord{ParaLast: 130, ParaTotal: 120}, 100},
+		// the line number is ignored if the paragraph count is known
+		{"width", BookRecord{ParaLast: 60, ParaTotal: 120, LineLast: 100, LineTotal: 1000}, 50},
+		// old records are not migrated until the book is opened
+		{"old", BookRecord{ParaLast: 0, LineLast: 250, LineTotal: 1000}, 25},
+		{"old version 1", BookRecord{ParaLast: 60, OffsetLast: 5, PosVersion: 1, LineLast: 300, LineTotal: 1000}, 30},
+	}
+	for _, test := range tests {
+		if p := test.book.Percent(); p != test.percent {
+			t.Errorf("%s: Percent() = %d, want %d", test.name, p, test.percent)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package common
+
+import (
+	"time"
+)
+
+const (
+	// MAX_SESSIONS is the number of not merged sessions of a book after
+	// which old sessions are merged
+	MAX_SESSIONS = 60
+	// KEEP_SESSIONS is the number of the latest sessions that are never
+	// merged
+	KEEP_SESSIONS = 20
+	// DAILY_SESSIONS_DAYS is how many days sessions are merged by day. Older
+	// sessions are merged by month
+	DAILY_SESSIONS_DAYS = 90
+)
+
+// SessionCount returns the number of reading sessions the session stands for
+func (s *Session) SessionCount() int {
+	if s.Merged == 0 {
+		return 1
+	}
+	return s.Merged
+}
+
+// NeedsCompacting returns true if the book has too many sessions that have
+// not been merged
+func NeedsCompacting(sessions []Session) bool {
+	count := 0
+	for _, s := range sessions {
+		if s.Merged == 0 {
+			count++
+		}
+	}
+	return count > MAX_SESSIONS
+}
+
+// CompactSessions merges old sessions of a book, so the number of stored
+// sessions does not grow without limit. Sessions must be sorted by their
+// start time. All sessions except the latest KEEP_SESSIONS are merged by
+// the day they start, and sessions older than DAILY_SESSIONS_DAYS are
+// merged by the month, so statistics by day, week and month do not change.
+// Sessions are not merged until NeedsCompacting returns true
+func CompactSessions(sessions []Session, now time.Time) []Session {
+	if !NeedsCompacting(sessions) {
+		return sessions
+	}
+
+	monthly := now.AddDate(0, 0, -DAILY_SESSIONS_DAYS)
+	merged := make([]Session, 0)
+	lastKey := ""
+	old := len(sessions) - KEEP_SESSIONS
+	for _, s := range sessions[:old] {
+		start, err := time.Parse(time.RFC3339, s.Start)
+		if err != nil {
+			merged = append(merged, s)
+			lastKey = ""
+			continue
+		}
+
+		key := start.Format("2006-01-02")
+		if start.Before(monthly) {
+			key = start.Format("2006-01")
+		}
+		if key == lastKey {
+			mergeSession(&merged[len(merged)-1], s)
+			continue
+		}
+		lastKey = key
+		merged = append(merged, s)
+		merged[len(merged)-1].Merged = s.SessionCount()
+	}
+	return append(merged, sessions[old:]...)
+}
+
+// sessionLength returns the duration of the session. Broken times make
+// empty sessions
+func sessionLength(s Session) (time.Time, time.Duration) {
+	start, err := time.Parse(time.RFC3339, s.Start)
+	if err != nil {
+		return start, 0
+	}
+	end, err := time.Parse(time.RFC3339, s.End)
+	if err != nil || end.Before(start) {
+		return start, 0
+	}
+	return start, end.Sub(start)
+}
+
+// mergeSession adds the later session to the merged one. The merged
+// session starts when the first session starts and lasts as long as all
+// its sessions together
+func mergeSession(to *Session, s Session) {
+	start, length := sessionLength(*to)
+	_, added := sessionLength(s)
+	to.End = start.Add(length + added).Format(time.RFC3339)
+	to.EndPara = s.EndPara
+	to.EndOffset = s.EndOffset
+	to.Words += s.Words
+	to.Merged += s.SessionCount()
+}
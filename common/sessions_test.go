@@ -0,0 +1,121 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newSession makes a session that starts at the time and lasts the number
+// of minutes. The session ends at the paragraph equal to its words
+func newSession(start string, minutes, words int) Session {
+	t, err := time.Parse(time.RFC3339, start)
+	end := ""
+	if err == nil {
+		end = t.Add(time.Duration(minutes) * time.Minute).Format(time.RFC3339)
+	}
+	return Session{Start: start, End: end, EndPara: words, Words: words}
+}
+
+// mergedSession is a compacted session
+func mergedSession(start string, minutes, words, merged int) Session {
+	s := newSession(start, minutes, words)
+	s.Merged = merged
+	return s
+}
+
+// sameDay returns count sessions of the day that last one minute each,
+// ten minutes apart, starting from the minute
+func sameDay(day string, from, count int) []Session {
+	sessions := make([]Session, count)
+	start, _ := time.Parse(time.RFC3339, day+"T00:00:00Z")
+	for i := range sessions {
+		t := start.Add(time.Duration(10*(from+i)) * time.Minute)
+		sessions[i] = newSession(t.Format(time.RFC3339), 1, 1)
+	}
+	return sessions
+}
+
+func TestNeedsCompacting(t *testing.T) {
+	tests := []struct {
+		name     string
+		sessions []Session
+		needs    bool
+	}{
+		{"empty", nil, false},
+		{"limit", sameDay("2024-06-01", 0, MAX_SESSIONS), false},
+		{"over limit", sameDay("2024-06-01", 0, MAX_SESSIONS+1), true},
+		// merged sessions are not counted
+		{"merged", append(sameDay("2024-06-01", 0, MAX_SESSIONS),
+			mergedSession("2024-06-02T00:00:00Z", 10, 10, 5)), false},
+	}
+	for _, test := range tests {
+		if needs := NeedsCompacting(test.sessions); needs != test.needs {
+			t.Errorf("%s: NeedsCompacting = %v, want %v", test.name, needs, test.needs)
+		}
+	}
+}
+
+func TestCompactSessions(t *testing.T) {
+	// sessions older than 2024-03-17T12:00 are merged by month
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	sessions := []Session{
+		newSession("2024-01-10T10:00:00Z", 30, 100),
+		newSession("2024-01-20T10:00:00Z", 30, 200),
+		mergedSession("2024-02-01T10:00:00Z", 60, 300, 3),
+		newSession("2024-02-02T10:00:00Z", 10, 10),
+		newSession("2024-03-17T11:00:00Z", 10, 20),
+		newSession("2024-03-17T13:00:00Z", 10, 30),
+		newSession("2024-03-18T10:00:00Z", 10, 40),
+		newSession("2024-05-01T10:00:00Z", 10, 50),
+		newSession("2024-05-01T20:00:00Z", 10, 60),
+		// a broken session is kept and is not merged with other sessions
+		newSession("broken", 0, 70),
+		newSession("2024-05-01T21:00:00Z", 10, 80),
+	}
+	sessions = append(sessions, sameDay("2024-06-01", 0, 40)...)
+	kept := sameDay("2024-06-01", 40, KEEP_SESSIONS)
+	sessions = append(sessions, kept...)
+
+	want := []Session{
+		{Start: "2024-01-10T10:00:00Z", End: "2024-01-10T11:00:00Z", EndPara: 200, Words: 300, Merged: 2},
+		{Start: "2024-02-01T10:00:00Z", End: "2024-02-01T11:10:00Z", EndPara: 10, Words: 310, Merged: 4},
+		// the month boundary is DAILY_SESSIONS_DAYS before now
+		mergedSession("2024-03-17T11:00:00Z", 10, 20, 1),
+		mergedSession("2024-03-17T13:00:00Z", 10, 30, 1),
+		mergedSession("2024-03-18T10:00:00Z", 10, 40, 1),
+		{Start: "2024-05-01T10:00:00Z", End: "2024-05-01T10:20:00Z", EndPara: 60, Words: 110, Merged: 2},
+		newSession("broken", 0, 70),
+		mergedSession("2024-05-01T21:00:00Z", 10, 80, 1),
+		mergedSession("2024-06-01T00:00:00Z", 40, 40, 40),
+	}
+	want = append(want, kept...)
+	want[len(want)-KEEP_SESSIONS-1].EndPara = 1
+
+	compacted := CompactSessions(sessions, now)
+	if !reflect.DeepEqual(compacted, want) {
+		t.Errorf("compacted sessions:\n%+v\nwant:\n%+v", compacted, want)
+	}
+	if NeedsCompacting(compacted) {
+		t.Errorf("compacted sessions need compacting")
+	}
+
+	// the number of sessions and words does not change, the first merged
+	// session of February stands for three sessions
+	count, words := 0, 0
+	for _, s := range compacted {
+		count += s.SessionCount()
+		words += s.Words
+	}
+	if count != len(sessions)+2 || words != 40+KEEP_SESSIONS+960 {
+		t.Errorf("compacted sessions stand for %d sessions and %d words, want %d and %d",
+			count, words, len(sessions)+2, 40+KEEP_SESSIONS+960)
+	}
+
+	// sessions are not merged until there are too many of them
+	few := sessions[len(sessions)-MAX_SESSIONS:]
+	if compacted := CompactSessions(few, now); !reflect.DeepEqual(compacted, few) {
+		t.Errorf("%d sessions have been compacted to %d", len(few), len(compacted))
+	}
+}
@@ -0,0 +1,30 @@
+package common
+
+import (
+	"strings"
+)
+
+// MAX_RATING is the highest book rating. Rating 0 means the book is not rated
+const MAX_RATING = 5
+
+// ParseTags splits a comma separated list of tags. Empty tags and
+// duplicates (ignoring case) are removed
+func ParseTags(s string) []string {
+	tags := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		key := strings.ToLower(t)
+		if t == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		tags = append(tags, t)
+	}
+	return tags
+}
+
+// JoinTags makes a comma separated list of tags that ParseTags can read
+func JoinTags(tags []string) string {
+	return strings.Join(tags, ", ")
+}
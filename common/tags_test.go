@@ -0,0 +1,29 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		text string
+		tags []string
+	}{
+		{"", []string{}},
+		{" , ,", []string{}},
+		{"sf", []string{"sf"}},
+		{" sf , to read ,classic", []string{"sf", "to read", "classic"}},
+		{"SF, sf, Sf", []string{"SF"}},
+	}
+	for _, test := range tests {
+		tags := ParseTags(test.text)
+		if !reflect.DeepEqual(tags, test.tags) {
+			t.Errorf("ParseTags(%q) = %q, want %q", test.text, tags, test.tags)
+		}
+		// joined tags are parsed back to the same list
+		if back := ParseTags(JoinTags(tags)); !reflect.DeepEqual(back, tags) {
+			t.Errorf("ParseTags(JoinTags(%q)) = %q", tags, back)
+		}
+	}
+}
@@ -4,15 +4,17 @@ import (
 	"bufio"
 	"fmt"
 	ui "github.com/VladimirMarkelov/clui"
-	fbutils "github.com/VladimirMarkelov/fb2text"
+	"github.com/VladimirMarkelov/termfb2/book"
 	"github.com/VladimirMarkelov/termfb2/common"
 	"github.com/VladimirMarkelov/termfb2/db"
+	"github.com/VladimirMarkelov/termfb2/index"
 	homedir "github.com/mitchellh/go-homedir"
 	term "github.com/nsf/termbox-go"
 	"os"
 	path "path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -23,6 +25,27 @@ type Config struct {
 	BackColor term.Attribute
 	TextColor term.Attribute
 	Justify   bool
+	// line width of books exported as plain text
+	ExportWidth int
+	// words per minute used to estimate the time left until enough
+	// reading sessions are recorded
+	ReadingSpeed int
+	// color of links to footnotes in clui color format
+	LinkColor string
+	// background colors of found text and the current search match
+	SearchColor        string
+	SearchCurrentColor string
+	// colors of paragraphs and inline styles in clui color format. They
+	// are combined, e.g, a title color and a strong text color make
+	// the color of a strong text inside a title
+	TitleColor    string
+	SubtitleColor string
+	EpigraphColor string
+	PoemColor     string
+	StrongColor   string
+	EmphasisColor string
+	StrikeColor   string
+	CodeColor     string
 
 	// info about last opened book
 	// lastPosition and lastLength are used in case of DB is off
@@ -30,13 +53,55 @@ type Config struct {
 	LastPosition int
 	LastLength   int
 	LastError    int
+	// width independent position: paragraph and rune offset inside it.
+	// LastPara is -1 if the last file was saved by an old version and
+	// contains only the line number
+	LastPara   int
+	LastOffset int
 
-	Lines []string
+	Book  *book.Book
+	Lines []book.Line
+	// the number of words from every line to the end of the book text
+	WordsLeft []int
+	// the reading speed used to estimate the time left
+	WordsPerMinute float64
+	// the width the book lines were formatted for
+	LineWidth int
+	// the selected link: paragraph index and index of the link inside
+	// the paragraph. SelectedPara is -1 if no link is selected
+	SelectedPara int
+	SelectedLink int
+	// indices of lines to return to after reading footnotes. They are
+	// updated when the book is reformatted
+	LinkHistory []int
+	// the last searched text, all its occurrences in the book and
+	// the index of the current one (-1 if no match is selected)
+	SearchText   string
+	Matches      []book.Match
+	CurrentMatch int
 
-	UseDb    bool
-	DbDriver common.BookDb
+	UseDb bool
+	// library backend: common.DB_SCRIBBLE or common.DB_SQLITE
+	DbBackend string
+	// how plain text filters match books: common.FILTER_SUBSTRING or
+	// common.FILTER_FUZZY
+	FilterMode string
+	DbDriver   common.BookDb
+	// full-text index of library books, see TextIndex
+	textIndex *index.Index
 
-	Info fbutils.BookInfo
+	Info book.Info
+	// content hash of the opened book file
+	BookHash string
+	// the current reading session of the opened book, nil if sessions
+	// are not recorded. SessionSaved is the time it was last written
+	Session      *common.Session
+	SessionSaved time.Time
+
+	// keys bound to every action (see Actions): context - action - keys
+	Keys map[string]map[string][]Key
+	// descriptions of invalid lines of the configuration file
+	ConfigErrors []string
 }
 
 func InitConfig() *Config {
@@ -44,6 +109,7 @@ func InitConfig() *Config {
 
 	conf.detectPaths()
 	conf.readOptions()
+	conf.ReadLastFileInfo()
 
 	return conf
 }
@@ -74,6 +140,8 @@ func (conf *Config) ReadLastFileInfo() {
 	conf.LastFile = ""
 	conf.LastPosition = 0
 	conf.LastLength = 0
+	conf.LastPara = -1
+	conf.LastOffset = 0
 
 	file, err := os.Open(path.Join(conf.confPath, common.LASTFILE))
 	if err != nil {
@@ -102,6 +170,20 @@ func (conf *Config) ReadLastFileInfo() {
 			conf.LastLength = 0
 		}
 	}
+	ok = scanner.Scan()
+	if ok {
+		if n, err := strconv.Atoi(strings.TrimSpace(scanner.Text())); err == nil {
+			conf.LastPara = n
+		}
+	}
+	ok = scanner.Scan()
+	if ok && conf.LastPara != -1 {
+		if n, err := strconv.Atoi(strings.TrimSpace(scanner.Text())); err == nil {
+			conf.LastOffset = n
+		} else {
+			conf.LastPara = -1
+		}
+	}
 }
 
 func (conf *Config) SaveLastFileInfo() {
@@ -120,27 +202,66 @@ func (conf *Config) SaveLastFileInfo() {
 	file.WriteString(fmt.Sprintf("%v\n", conf.LastFile))
 	file.WriteString(fmt.Sprintf("%v\n", conf.LastPosition))
 	file.WriteString(fmt.Sprintf("%v\n", conf.LastLength))
+	file.WriteString(fmt.Sprintf("%v\n", conf.LastPara))
+	file.WriteString(fmt.Sprintf("%v\n", conf.LastOffset))
 }
 
 func (conf *Config) readOptions() {
 	conf.BackColor = term.ColorDefault
 	conf.TextColor = term.ColorDefault
+	conf.LinkColor = "bright blue"
+	conf.SearchColor = "yellow"
+	conf.SearchCurrentColor = "green"
+	conf.TitleColor = "bold"
+	conf.SubtitleColor = "bold"
+	conf.EpigraphColor = "cyan"
+	conf.PoemColor = "green"
+	conf.StrongColor = "bold"
+	conf.EmphasisColor = "underline"
+	conf.StrikeColor = "bright black"
+	conf.CodeColor = "cyan"
+	conf.SelectedPara = -1
+	conf.CurrentMatch = -1
 	conf.UseDb = true
+	conf.DbBackend = common.DB_SCRIBBLE
+	conf.FilterMode = common.FILTER_SUBSTRING
+	conf.ExportWidth = 80
+	conf.ReadingSpeed = 200
 	conf.LastFile = ""
 
+	// key bindings are set up after reading the whole file, so a preset
+	// can be selected after other bindings. Default bindings are used if
+	// the file does not exist
+	keyLines := make([]keyBinding, 0)
+	defer func() {
+		conf.initKeys(keyLines)
+	}()
+
 	file, err := os.Open(path.Join(conf.confPath, common.CONFIGFILE))
 	if err != nil {
 		return
 	}
 	defer file.Close()
 
+	section := ""
+	lineNo := 0
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
+		lineNo++
 		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "/") {
 			continue
 		}
 
+		// options go before any section, key bindings are in [keys] section
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			section = strings.ToLower(strings.TrimSpace(trimmed[1 : len(trimmed)-1]))
+			if section != "keys" {
+				conf.configError(lineNo, "unknown section '%s'", trimmed)
+			}
+			continue
+		}
+
 		items := strings.SplitN(line, "=", 2)
 		if len(items) != 2 {
 			continue
@@ -149,22 +270,111 @@ func (conf *Config) readOptions() {
 		name := strings.TrimSpace(items[0])
 		value := strings.TrimSpace(items[1])
 
+		if section == "keys" {
+			keyLines = append(keyLines, keyBinding{line: lineNo, name: name, value: value})
+			continue
+		}
+		if section != "" {
+			continue
+		}
+
 		if strings.EqualFold(name, "useDb") {
 			conf.UseDb = (value == "1" || strings.EqualFold(value, "on") || strings.EqualFold(value, "true"))
+		} else if strings.EqualFold(name, "dbDriver") {
+			if strings.EqualFold(value, common.DB_SQLITE) {
+				conf.DbBackend = common.DB_SQLITE
+			} else {
+				conf.DbBackend = common.DB_SCRIBBLE
+			}
+		} else if strings.EqualFold(name, "filterMode") {
+			if strings.EqualFold(value, common.FILTER_FUZZY) {
+				conf.FilterMode = common.FILTER_FUZZY
+			} else {
+				conf.FilterMode = common.FILTER_SUBSTRING
+			}
 		} else if strings.EqualFold(name, "textColor") {
 			conf.TextColor = ui.StringToColor(value)
 		} else if strings.EqualFold(name, "backColor") {
 			conf.BackColor = ui.StringToColor(value)
 		} else if strings.EqualFold(name, "justify") {
 			conf.Justify = (value == "1" || strings.EqualFold(value, "on") || strings.EqualFold(value, "true"))
+		} else if strings.EqualFold(name, "exportWidth") {
+			if width, err := strconv.Atoi(value); err == nil && width > 0 {
+				conf.ExportWidth = width
+			}
+		} else if strings.EqualFold(name, "readingSpeed") {
+			if speed, err := strconv.Atoi(value); err == nil && speed > 0 {
+				conf.ReadingSpeed = speed
+			}
+		} else if strings.EqualFold(name, "linkColor") {
+			conf.LinkColor = value
+		} else if strings.EqualFold(name, "searchColor") {
+			conf.SearchColor = value
+		} else if strings.EqualFold(name, "searchCurrentColor") {
+			conf.SearchCurrentColor = value
+		} else if strings.EqualFold(name, "titleColor") {
+			conf.TitleColor = value
+		} else if strings.EqualFold(name, "subtitleColor") {
+			conf.SubtitleColor = value
+		} else if strings.EqualFold(name, "epigraphColor") {
+			conf.EpigraphColor = value
+		} else if strings.EqualFold(name, "poemColor") {
+			conf.PoemColor = value
+		} else if strings.EqualFold(name, "strongColor") {
+			conf.StrongColor = value
+		} else if strings.EqualFold(name, "emphasisColor") {
+			conf.EmphasisColor = value
+		} else if strings.EqualFold(name, "strikeColor") {
+			conf.StrikeColor = value
+		} else if strings.EqualFold(name, "codeColor") {
+			conf.CodeColor = value
 		}
 	}
 }
 
-func (conf *Config) InitDatabase() {
+// InitDatabase opens the library and reads all book records. If the
+// library cannot be opened, it is turned off for the session. An error
+// while reading records keeps the library on: it contains the books that
+// have been read successfully
+func (conf *Config) InitDatabase() error {
 	if !conf.UseDb {
-		return
+		return nil
+	}
+
+	var (
+		bookDb common.BookDb
+		err    error
+	)
+	if conf.DbBackend == common.DB_SQLITE {
+		bookDb, err = db.InitSqliteDb(conf.confPath)
+	} else {
+		bookDb, err = db.InitDb(conf.confPath)
 	}
+	if err != nil {
+		conf.UseDb = false
+		return fmt.Errorf("failed to open library: %v", err)
+	}
+
+	conf.DbDriver = bookDb
+	bookDb.SetFilterMode(conf.FilterMode)
+	bookDb.SetSortMode(conf.DefaultSort())
+	return bookDb.ReadDatabase()
+}
 
-	conf.DbDriver = db.InitDb(conf.confPath)
+// TextIndex returns the full-text index of library books. It is kept
+// next to the library
+func (conf *Config) TextIndex() *index.Index {
+	if conf.textIndex == nil {
+		conf.textIndex = index.Open(path.Join(conf.confPath, common.INDEXDIR))
+	}
+	return conf.textIndex
+}
+
+// DefaultSort returns the sort mode of the library when no column is
+// sorted: the best fuzzy matches first or by author
+func (conf *Config) DefaultSort() (string, bool) {
+	if conf.FilterMode == common.FILTER_FUZZY {
+		return common.FIELD_SCORE, false
+	}
+	return common.FIELD_AUTHOR, true
 }
@@ -0,0 +1,390 @@
+package config
+
+import (
+	"fmt"
+	"github.com/VladimirMarkelov/termfb2/common"
+	term "github.com/nsf/termbox-go"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// dialogs that have their own key bindings. An action name can be used in
+// a few contexts, e.g. help, and the same keys are bound to all of them
+const (
+	CtxReader  = "reader"
+	CtxLibrary = "library"
+)
+
+// actions of the reader
+const (
+	ActLineDown     = "lineDown"
+	ActLineUp       = "lineUp"
+	ActPageDown     = "pageDown"
+	ActPageUp       = "pageUp"
+	ActHalfPageDown = "halfPageDown"
+	ActHalfPageUp   = "halfPageUp"
+	ActTop          = "top"
+	ActBottom       = "bottom"
+	ActLibrary      = "library"
+	ActNextLink     = "nextLink"
+	ActFollowLink   = "followLink"
+	ActBack         = "back"
+	ActSearch       = "search"
+	ActNextMatch    = "nextMatch"
+	ActPrevMatch    = "prevMatch"
+	ActClearSearch  = "clearSearch"
+	ActAddBookmark  = "addBookmark"
+	ActBookmarks    = "bookmarks"
+	ActToc          = "toc"
+	ActStats        = "stats"
+	ActQuit         = "quit"
+	ActHelp         = "help"
+)
+
+// actions of the library dialog
+const (
+	ActClose    = "close"
+	ActOpen     = "open"
+	ActSort     = "sort"
+	ActUnsort   = "unsort"
+	ActDelete   = "delete"
+	ActRelocate = "relocate"
+	ActImport   = "import"
+	ActMissing  = "missing"
+	ActTags     = "tags"
+	ActRating   = "rating"
+	ActFindText = "findText"
+	ActTree     = "tree"
+)
+
+// Action is a command that can be bound to keys in termfb2.conf
+type Action struct {
+	Name    string
+	Context string
+	Help    string
+	// default key names
+	Keys []string
+}
+
+// Actions lists all actions in the order they are shown to a user
+var Actions = []Action{
+	{ActLineDown, CtxReader, "scroll line down", []string{"Down", "j"}},
+	{ActLineUp, CtxReader, "scroll line up", []string{"Up", "k"}},
+	{ActPageDown, CtxReader, "scroll page down", []string{"PgDn", "Space", "d"}},
+	{ActPageUp, CtxReader, "scroll page up", []string{"PgUp", "u"}},
+	{ActHalfPageDown, CtxReader, "scroll half page down", nil},
+	{ActHalfPageUp, CtxReader, "scroll half page up", nil},
+	{ActTop, CtxReader, "go to the book start", []string{"Home"}},
+	{ActBottom, CtxReader, "go to the book end", []string{"End"}},
+	{ActLibrary, CtxReader, "open the library", []string{"F2"}},
+	{ActNextLink, CtxReader, "select the next visible link", []string{"Tab"}},
+	{ActFollowLink, CtxReader, "jump to the selected footnote", []string{"Enter"}},
+	{ActBack, CtxReader, "return from the footnote", []string{"Backspace"}},
+	{ActSearch, CtxReader, "search text", []string{"/"}},
+	{ActNextMatch, CtxReader, "go to the next found text", []string{"n"}},
+	{ActPrevMatch, CtxReader, "go to the previous found text", []string{"N"}},
+	{ActClearSearch, CtxReader, "remove search highlighting", []string{"Esc"}},
+	{ActAddBookmark, CtxReader, "add a bookmark", []string{"m"}},
+	{ActBookmarks, CtxReader, "open the bookmark list", []string{"b"}},
+	{ActToc, CtxReader, "open the table of contents", []string{"t"}},
+	{ActStats, CtxReader, "show reading statistics", []string{"s"}},
+	{ActQuit, CtxReader, "close the application", nil},
+	{ActHelp, CtxReader, "show hotkeys", []string{"F1"}},
+
+	{ActClose, CtxLibrary, "close the library", []string{"Esc"}},
+	{ActOpen, CtxLibrary, "open the selected book", []string{"Enter"}},
+	{ActSort, CtxLibrary, "sort by the selected column", []string{"F4"}},
+	{ActUnsort, CtxLibrary, "turn column sorting off: best fuzzy matches first or by author", []string{"Ctrl+R"}},
+	{ActDelete, CtxLibrary, "remove the selected book", []string{"Delete"}},
+	{ActRelocate, CtxLibrary, "change the book file path", []string{"F3"}},
+	{ActImport, CtxLibrary, "import books from a directory", []string{"F7"}},
+	{ActMissing, CtxLibrary, "show only books with missing files", []string{"F8"}},
+	{ActTags, CtxLibrary, "edit tags of the selected book", []string{"F6"}},
+	{ActRating, CtxLibrary, "rate the selected book", []string{"F9"}},
+	{ActFindText, CtxLibrary, "search text in all books", []string{"F5"}},
+	{ActTree, CtxLibrary, "show books grouped by author and sequence", []string{"Ctrl+T"}},
+	{ActHelp, CtxLibrary, "show hotkeys", []string{"F1"}},
+}
+
+// keyPresets are built-in sets of bindings selected with 'preset' option
+// in [keys] section. They replace the default keys of listed actions
+var keyPresets = map[string]map[string][]string{
+	"vi": {
+		ActLineDown:     {"j", "Down", "Ctrl+E"},
+		ActLineUp:       {"k", "Up", "Ctrl+Y"},
+		ActPageDown:     {"Ctrl+F", "PgDn", "Space"},
+		ActPageUp:       {"Ctrl+B", "PgUp"},
+		ActHalfPageDown: {"Ctrl+D"},
+		ActHalfPageUp:   {"Ctrl+U"},
+		ActTop:          {"g", "Home"},
+		ActBottom:       {"G", "End"},
+	},
+	"less": {
+		ActLineDown:     {"j", "e", "Down", "Ctrl+N", "Ctrl+E"},
+		ActLineUp:       {"k", "y", "Up", "Ctrl+P", "Ctrl+Y"},
+		ActPageDown:     {"Space", "f", "PgDn", "Ctrl+F"},
+		ActPageUp:       {"b", "w", "PgUp", "Ctrl+B"},
+		ActHalfPageDown: {"d", "Ctrl+D"},
+		ActHalfPageUp:   {"u", "Ctrl+U"},
+		ActTop:          {"g", "<", "Home"},
+		ActBottom:       {"G", ">", "End"},
+		ActBookmarks:    {"B"},
+		ActQuit:         {"q", "Q"},
+	},
+}
+
+// Key is a key press: either a special key or a character
+type Key struct {
+	Key term.Key
+	Ch  rune
+}
+
+// specialKeys are names of keys that do not produce characters. The names
+// are used both to parse and to display keys
+var specialKeys = []struct {
+	name string
+	key  term.Key
+}{
+	{"F1", term.KeyF1}, {"F2", term.KeyF2}, {"F3", term.KeyF3}, {"F4", term.KeyF4},
+	{"F5", term.KeyF5}, {"F6", term.KeyF6}, {"F7", term.KeyF7}, {"F8", term.KeyF8},
+	{"F9", term.KeyF9}, {"F10", term.KeyF10}, {"F11", term.KeyF11}, {"F12", term.KeyF12},
+	{"Insert", term.KeyInsert}, {"Delete", term.KeyDelete},
+	{"Home", term.KeyHome}, {"End", term.KeyEnd},
+	{"PgUp", term.KeyPgup}, {"PgDn", term.KeyPgdn},
+	{"Up", term.KeyArrowUp}, {"Down", term.KeyArrowDown},
+	{"Left", term.KeyArrowLeft}, {"Right", term.KeyArrowRight},
+	{"Enter", term.KeyEnter}, {"Esc", term.KeyEsc}, {"Tab", term.KeyTab},
+	{"Backspace", term.KeyBackspace}, {"Space", term.KeySpace},
+}
+
+// ParseKey converts a key name from the configuration file to a key.
+// A name is a single character, a special key name (F1-F12, Enter, Esc,
+// Tab, Backspace, Space, Insert, Delete, Home, End, PgUp, PgDn, Up, Down,
+// Left, Right), or Ctrl+A - Ctrl+Z. Special key names are case-insensitive
+func ParseKey(name string) (Key, error) {
+	if utf8.RuneCountInString(name) == 1 {
+		r, _ := utf8.DecodeRuneInString(name)
+		return normalize(Key{Ch: r}), nil
+	}
+
+	for _, sk := range specialKeys {
+		if strings.EqualFold(sk.name, name) {
+			return Key{Key: sk.key}, nil
+		}
+	}
+	lower := strings.ToLower(name)
+	if len(lower) == len("ctrl+a") && strings.HasPrefix(lower, "ctrl+") {
+		ch := lower[len(lower)-1]
+		if ch >= 'a' && ch <= 'z' {
+			return Key{Key: term.KeyCtrlA + term.Key(ch-'a')}, nil
+		}
+	}
+	return Key{}, fmt.Errorf("unknown key '%s'", name)
+}
+
+// String returns the key name in the format ParseKey accepts
+func (k Key) String() string {
+	if k.Ch != 0 {
+		return string(k.Ch)
+	}
+	for _, sk := range specialKeys {
+		if sk.key == k.Key {
+			return sk.name
+		}
+	}
+	if k.Key >= term.KeyCtrlA && k.Key <= term.KeyCtrlZ {
+		return "Ctrl+" + string(rune('A'+k.Key-term.KeyCtrlA))
+	}
+	return fmt.Sprintf("Key%d", k.Key)
+}
+
+// normalize makes keys that terminals send in different ways equal
+func normalize(k Key) Key {
+	switch {
+	case k.Ch == ' ':
+		return Key{Key: term.KeySpace}
+	case k.Ch != 0:
+		return Key{Ch: k.Ch}
+	case k.Key == term.KeyBackspace2:
+		return Key{Key: term.KeyBackspace}
+	}
+	return k
+}
+
+// findActions returns descriptions of the action in all contexts
+func findActions(name string) []Action {
+	found := make([]Action, 0, 1)
+	for _, a := range Actions {
+		if strings.EqualFold(a.Name, name) {
+			found = append(found, a)
+		}
+	}
+	return found
+}
+
+// keyBinding is a line of [keys] section
+type keyBinding struct {
+	line   int
+	name   string
+	value  string
+	keys   []Key
+	action Action
+}
+
+// bindKeys assigns the keys to the action. The keys are removed from other
+// actions of the same context, so the latest binding wins
+func (conf *Config) bindKeys(action Action, keys []Key) {
+	for _, a := range Actions {
+		if a.Context != action.Context || a.Name == action.Name {
+			continue
+		}
+		left := conf.Keys[a.Context][a.Name][:0]
+		for _, k := range conf.Keys[a.Context][a.Name] {
+			if !containsKey(keys, k) {
+				left = append(left, k)
+			}
+		}
+		conf.Keys[a.Context][a.Name] = left
+	}
+	// the list must not be shared: it is changed in place when the keys
+	// are bound to other actions
+	conf.Keys[action.Context][action.Name] = append([]Key(nil), keys...)
+}
+
+func containsKey(keys []Key, key Key) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// parseKeyList converts a list of key names separated with spaces or
+// commas. It returns an error for the first invalid key name
+func parseKeyList(value string) ([]Key, error) {
+	names := strings.FieldsFunc(value, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ','
+	})
+	keys := make([]Key, 0, len(names))
+	for _, name := range names {
+		k, err := ParseKey(name)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// initKeys sets up key bindings: default ones, then the selected preset,
+// and then bindings from [keys] section. Invalid lines are skipped and
+// described in conf.ConfigErrors
+func (conf *Config) initKeys(lines []keyBinding) {
+	conf.Keys = make(map[string]map[string][]Key)
+	for _, a := range Actions {
+		if conf.Keys[a.Context] == nil {
+			conf.Keys[a.Context] = make(map[string][]Key)
+		}
+		keys, _ := parseKeyList(strings.Join(a.Keys, " "))
+		conf.Keys[a.Context][a.Name] = keys
+	}
+
+	bindings := make([]keyBinding, 0, len(lines))
+	for _, b := range lines {
+		if strings.EqualFold(b.name, "preset") {
+			preset, ok := keyPresets[strings.ToLower(b.value)]
+			if !ok {
+				conf.configError(b.line, "unknown preset '%s', available presets are vi and less", b.value)
+				continue
+			}
+			for _, a := range Actions {
+				if names, ok := preset[a.Name]; ok {
+					keys, _ := parseKeyList(strings.Join(names, " "))
+					conf.bindKeys(a, keys)
+				}
+			}
+			continue
+		}
+
+		actions := findActions(b.name)
+		if len(actions) == 0 {
+			conf.configError(b.line, "unknown action '%s'", b.name)
+			continue
+		}
+		keys, err := parseKeyList(b.value)
+		if err != nil {
+			conf.configError(b.line, "%v", err)
+			continue
+		}
+		for _, action := range actions {
+			if action.Context == CtxLibrary && hasPrintable(keys) {
+				conf.configError(b.line, "action '%s' cannot use a character key in the library: characters are used by the filter", action.Name)
+				continue
+			}
+			b.action, b.keys = action, keys
+			bindings = append(bindings, b)
+		}
+	}
+
+	// a key bound to two actions in the configuration file is a mistake,
+	// while keys bound by default and by presets are just reassigned
+	for i, b := range bindings {
+		dup := false
+		for _, prev := range bindings[:i] {
+			if prev.action.Context != b.action.Context || prev.action.Name == b.action.Name {
+				continue
+			}
+			for _, k := range b.keys {
+				if containsKey(prev.keys, k) {
+					conf.configError(b.line, "key '%s' is already bound to action '%s'", k, prev.action.Name)
+					dup = true
+					break
+				}
+			}
+			if dup {
+				break
+			}
+		}
+		if !dup {
+			conf.bindKeys(b.action, b.keys)
+		}
+	}
+}
+
+func hasPrintable(keys []Key) bool {
+	for _, k := range keys {
+		if k.Ch != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (conf *Config) configError(line int, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	conf.ConfigErrors = append(conf.ConfigErrors, fmt.Sprintf("%s line %d: %s", common.CONFIGFILE, line, msg))
+}
+
+// ActionForKey returns the name of the action bound to the key in the
+// context. It returns an empty string if the key is not bound
+func (conf *Config) ActionForKey(ctx string, key term.Key, ch rune) string {
+	k := normalize(Key{Key: key, Ch: ch})
+	for _, a := range Actions {
+		if a.Context == ctx && containsKey(conf.Keys[ctx][a.Name], k) {
+			return a.Name
+		}
+	}
+	return ""
+}
+
+// KeyNames returns names of keys bound to the action separated with commas
+func (conf *Config) KeyNames(ctx, action string) string {
+	keys := conf.Keys[ctx][action]
+	names := make([]string, 0, len(keys))
+	for _, k := range keys {
+		names = append(names, k.String())
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	term "github.com/nsf/termbox-go"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// keysConfig sets up key bindings from lines of [keys] section in the
+// format 'action = keys'
+func keysConfig(lines ...string) *Config {
+	bindings := make([]keyBinding, 0, len(lines))
+	for i, s := range lines {
+		parts := strings.SplitN(s, "=", 2)
+		bindings = append(bindings, keyBinding{line: i + 1,
+			name: strings.TrimSpace(parts[0]), value: strings.TrimSpace(parts[1])})
+	}
+	conf := new(Config)
+	conf.initKeys(bindings)
+	return conf
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name string
+		key  Key
+		text string
+	}{
+		{"j", Key{Ch: 'j'}, "j"},
+		{"Ж", Key{Ch: 'Ж'}, "Ж"},
+		{" ", Key{Key: term.KeySpace}, "Space"},
+		{"space", Key{Key: term.KeySpace}, "Space"},
+		{"pgdn", Key{Key: term.KeyPgdn}, "PgDn"},
+		{"F10", Key{Key: term.KeyF10}, "F10"},
+		{"ctrl+d", Key{Key: term.KeyCtrlD}, "Ctrl+D"},
+	}
+	for _, test := range tests {
+		k, err := ParseKey(test.name)
+		if err != nil || k != test.key {
+			t.Errorf("ParseKey(%q) = %v, %v, want %v", test.name, k, err, test.key)
+		}
+		if k.String() != test.text {
+			t.Errorf("%q: key name %q, want %q", test.name, k.String(), test.text)
+		}
+	}
+
+	for _, name := range []string{"", "Ctrl+1", "Ctrl+", "Shift+A", "F13"} {
+		if k, err := ParseKey(name); err == nil {
+			t.Errorf("ParseKey(%q) = %v, want an error", name, k)
+		}
+	}
+}
+
+func TestInitKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		// context - key - action, an empty action means the key is not bound
+		actions map[string]map[string]string
+		errors  []string
+	}{
+		{"defaults", nil,
+			map[string]map[string]string{
+				CtxReader:  {"j": ActLineDown, "Down": ActLineDown, "d": ActPageDown, "q": "", "F1": ActHelp},
+				CtxLibrary: {"F4": ActSort, "Ctrl+R": ActUnsort, "F1": ActHelp},
+			}, nil},
+		// presets replace the default keys of their actions
+		{"vi preset", []string{"preset = vi"},
+			map[string]map[string]string{
+				CtxReader: {"Ctrl+D": ActHalfPageDown, "d": "", "g": ActTop, "Home": ActTop, "Space": ActPageDown},
+			}, nil},
+		{"less preset", []string{"preset = Less"},
+			map[string]map[string]string{
+				CtxReader: {"d": ActHalfPageDown, "u": ActHalfPageUp, "b": ActPageUp, "B": ActBookmarks, "q": ActQuit},
+			}, nil},
+		{"unknown preset", []string{"preset = emacs"},
+			map[string]map[string]string{CtxReader: {"d": ActPageDown}},
+			[]string{"termfb2.conf line 1: unknown preset 'emacs', available presets are vi and less"}},
+		// keys of default and preset bindings are reassigned silently
+		{"override default", []string{"toc = j, F10"},
+			map[string]map[string]string{
+				CtxReader: {"j": ActToc, "F10": ActToc, "t": "", "Down": ActLineDown},
+			}, nil},
+		{"override preset", []string{"preset = less", "bookmarks = d"},
+			map[string]map[string]string{
+				CtxReader: {"d": ActBookmarks, "B": "", "Ctrl+D": ActHalfPageDown},
+			}, nil},
+		// the preset is applied before all bindings of the file
+		{"preset after binding", []string{"bookmarks = d", "preset = less"},
+			map[string]map[string]string{CtxReader: {"d": ActBookmarks, "B": ""}}, nil},
+		// a key bound to two actions in the file is a conflict, the first
+		// binding wins
+		{"conflict", []string{"search = x", "toc = y x"},
+			map[string]map[string]string{CtxReader: {"x": ActSearch, "y": "", "t": ActToc}},
+			[]string{"termfb2.conf line 2: key 'x' is already bound to action 'search'"}},
+		{"same action twice", []string{"toc = x", "toc = y"},
+			map[string]map[string]string{CtxReader: {"x": "", "y": ActToc}}, nil},
+		// actions of different contexts do not conflict
+		{"different contexts", []string{"stats = F9"},
+			map[string]map[string]string{CtxReader: {"F9": ActStats}, CtxLibrary: {"F9": ActRating}}, nil},
+		{"all contexts", []string{"help = F10"},
+			map[string]map[string]string{
+				CtxReader:  {"F10": ActHelp, "F1": ""},
+				CtxLibrary: {"F10": ActHelp, "F1": ""},
+			}, nil},
+		{"library characters", []string{"sort = s"},
+			map[string]map[string]string{CtxLibrary: {"s": "", "F4": ActSort}},
+			[]string{"termfb2.conf line 1: action 'sort' cannot use a character key in the library: characters are used by the filter"}},
+		{"invalid lines", []string{"fly = x", "toc = Ctrl+1"},
+			map[string]map[string]string{CtxReader: {"x": "", "t": ActToc}},
+			[]string{"termfb2.conf line 1: unknown action 'fly'", "termfb2.conf line 2: unknown key 'Ctrl+1'"}},
+	}
+	for _, test := range tests {
+		conf := keysConfig(test.lines...)
+		if !reflect.DeepEqual(conf.ConfigErrors, test.errors) {
+			t.Errorf("%s: errors %q, want %q", test.name, conf.ConfigErrors, test.errors)
+		}
+		for ctx, keys := range test.actions {
+			for name, action := range keys {
+				k, err := ParseKey(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := conf.ActionForKey(ctx, k.Key, k.Ch); got != action {
+					t.Errorf("%s: key %s in %s is bound to %q, want %q", test.name, name, ctx, got, action)
+				}
+			}
+		}
+	}
+}
+
+func TestKeyNames(t *testing.T) {
+	conf := keysConfig("preset = vi")
+	if names := conf.KeyNames(CtxReader, ActPageDown); names != "Ctrl+F, PgDn, Space" {
+		t.Errorf("page down keys %q, want %q", names, "Ctrl+F, PgDn, Space")
+	}
+	// the terminal sends Backspace as two different keys
+	if action := conf.ActionForKey(CtxReader, term.KeyBackspace2, 0); action != ActBack {
+		t.Errorf("Backspace is bound to %q, want %q", action, ActBack)
+	}
+}
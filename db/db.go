@@ -3,9 +3,11 @@ package db
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/VladimirMarkelov/termfb2/book"
 	"github.com/VladimirMarkelov/termfb2/common"
 	scribble "github.com/nanobox-io/golang-scribble"
 	"github.com/nu7hatch/gouuid"
+	"io/ioutil"
 	"os"
 	path "path/filepath"
 	"sort"
@@ -15,82 +17,271 @@ import (
 
 type ScribbleDb struct {
 	dbDriver *scribble.Driver
+	// directory of the scribble database
+	dir string
 
 	bookMap      map[string]common.BookRecord
 	bookList     []common.BookRecord
 	bookFiltered []common.BookRecord
+	// file paths of books by content hash and by document id
+	hashPaths  map[string][]string
+	docIdPaths map[string][]string
 
 	filter   string
 	sortMode string
 	sortAsc  bool
+	// show only books which files do not exist
+	missingOnly bool
+	// the parsed filter, nil if the filter is plain text. If the filter
+	// cannot be parsed, no book is shown
+	query     *Query
+	filterErr error
+	// plain text filter mode and fuzzy scores of filtered books by id
+	filterMode string
+	scores     map[string]int
 }
 
-func InitDb(dbPath string) *ScribbleDb {
+// InitDb opens the scribble library. Call ReadDatabase to load books
+func InitDb(dbPath string) (*ScribbleDb, error) {
 	db := new(ScribbleDb)
 	db.sortMode = common.FIELD_AUTHOR
 	db.sortAsc = true
-	db.dbDriver, _ = scribble.New(path.Join(dbPath, common.DBFILE), nil)
-
-	db.ReadDatabase()
-
-	return db
-}
+	db.dir = path.Join(dbPath, common.DBFILE)
+	db.bookMap = make(map[string]common.BookRecord, 0)
+	db.hashPaths = make(map[string][]string)
+	db.docIdPaths = make(map[string][]string)
 
-func (db *ScribbleDb) ReadDatabase() {
-	records, err := db.dbDriver.ReadAll(common.DBCOLLECTION)
+	var err error
+	db.dbDriver, err = scribble.New(db.dir, nil)
 	if err != nil {
-		return
+		return nil, err
 	}
 
+	return db, nil
+}
+
+// ReadDatabase loads all books. Records that cannot be read are moved to
+// the quarantine directory, and common.QuarantineError is returned. All
+// other books are loaded in this case
+func (db *ScribbleDb) ReadDatabase() error {
 	db.bookMap = make(map[string]common.BookRecord, 0)
 	db.bookList = make([]common.BookRecord, 0)
 	db.bookFiltered = make([]common.BookRecord, 0)
-	for _, r := range records {
+	db.hashPaths = make(map[string][]string)
+	db.docIdPaths = make(map[string][]string)
+
+	collection := path.Join(db.dir, common.DBCOLLECTION)
+	files, err := ioutil.ReadDir(collection)
+	if os.IsNotExist(err) {
+		// no books have been added yet
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	damaged := make([]string, 0)
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
 		b := common.BookRecord{}
-		if err := json.Unmarshal([]byte(r), &b); err != nil {
-			fmt.Printf("Failed to parse book list: %v\n", err)
-			os.Exit(1)
+		data, err := ioutil.ReadFile(path.Join(collection, f.Name()))
+		if err == nil {
+			err = json.Unmarshal(data, &b)
+		}
+		if err != nil {
+			damaged = append(damaged, f.Name())
+			continue
 		}
 		db.bookMap[b.FilePath] = b
 		db.bookList = append(db.bookList, b)
-		db.bookFiltered = append(db.bookFiltered, b)
+		db.addContent(b)
 	}
+	err = db.upgrade()
+	db.bookFiltered = db.bookFilter()
 	db.bookArraySort()
+
+	if err == nil && len(damaged) != 0 {
+		return quarantineRecords(db.dir, damaged)
+	}
+	return err
+}
+
+// scribbleVersion is the version of scribble records. Version 1 fills
+// sequence numbers of books added before they were stored
+const scribbleVersion = 1
+
+// upgrade updates loaded records that were saved by older versions. It is
+// done once, the version is kept in a file of the database directory
+func (db *ScribbleDb) upgrade() error {
+	versionFile := path.Join(db.dir, common.DBVERSIONFILE)
+	version := 0
+	if data, err := ioutil.ReadFile(versionFile); err == nil {
+		fmt.Sscan(string(data), &version)
+	}
+	if version >= scribbleVersion {
+		return nil
+	}
+
+	for _, b := range db.bookList {
+		if !fillSequenceNumber(&b) {
+			continue
+		}
+		if err := db.saveBook(b); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(versionFile, []byte(fmt.Sprintf("%d\n", scribbleVersion)), 0644)
+}
+
+// quarantineRecords moves damaged records out of the book collection of
+// the scribble library in dbDir, so they do not break the library and can
+// be fixed manually
+func quarantineRecords(dbDir string, files []string) error {
+	dir := path.Join(dbDir, common.QUARANTINEDIR)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.Rename(path.Join(dbDir, common.DBCOLLECTION, f), path.Join(dir, f)); err != nil {
+			return err
+		}
+	}
+
+	return &common.QuarantineError{Dir: dir, Files: files}
+}
+
+// saveBook writes the book to the disk and updates it in all book lists
+func (db *ScribbleDb) saveBook(book common.BookRecord) error {
+	if err := db.dbDriver.Write(common.DBCOLLECTION, book.Id, &book); err != nil {
+		return err
+	}
+	db.replaceBook(book)
+	return nil
+}
+
+// addPath adds the file path to the list of the key. Empty keys are skipped
+func addPath(paths map[string][]string, key, filePath string) {
+	if key != "" {
+		paths[key] = append(paths[key], filePath)
+	}
+}
+
+// removePath removes the file path from the list of the key
+func removePath(paths map[string][]string, key, filePath string) {
+	list := paths[key]
+	for i, p := range list {
+		if p == filePath {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(paths, key)
+	} else {
+		paths[key] = list
+	}
+}
+
+// addContent adds the book to the maps of books by hash and document id
+func (db *ScribbleDb) addContent(book common.BookRecord) {
+	addPath(db.hashPaths, book.Hash, book.FilePath)
+	addPath(db.docIdPaths, book.DocId, book.FilePath)
+}
+
+// removeContent removes the book from the maps of books by hash and
+// document id
+func (db *ScribbleDb) removeContent(book common.BookRecord) {
+	removePath(db.hashPaths, book.Hash, book.FilePath)
+	removePath(db.docIdPaths, book.DocId, book.FilePath)
 }
 
-func (db *ScribbleDb) UpdateBookInDb(bookPath string, pos, length int, bookInfo *common.BookRecord) {
+// replaceBook updates the book in all book lists after the book record changes
+func (db *ScribbleDb) replaceBook(book common.BookRecord) {
+	db.bookMap[book.FilePath] = book
+	for i, b := range db.bookList {
+		if b.Id == book.Id {
+			db.removeContent(b)
+			db.addContent(book)
+			db.bookList[i] = book
+		}
+	}
+	for i, b := range db.bookFiltered {
+		if b.Id == book.Id {
+			db.bookFiltered[i] = book
+		}
+	}
+}
+
+func (db *ScribbleDb) UpdateBookInDb(bookPath string, pos common.Position, bookInfo *common.BookRecord) error {
 	book, found := db.bookMap[bookPath]
 	if found {
-		if book.LineLast != pos || book.LineTotal != length {
-			book.LineLast = pos
-			book.LineTotal = length
-			if pos+1 == length && book.Completed == "" {
+		if book.LineLast != pos.Line || book.LineTotal != pos.Total ||
+			book.ParaLast != pos.Para || book.OffsetLast != pos.Offset ||
+			book.ParaTotal != pos.ParaTotal || book.PosVersion != common.POS_VERSION ||
+			(book.Hash == "" && bookInfo.Hash != "") ||
+			(book.DocId == "" && bookInfo.DocId != "") ||
+			(book.SeqNumber == 0 && bookInfo.SeqNumber != 0) {
+			book.LineLast = pos.Line
+			book.LineTotal = pos.Total
+			book.ParaLast = pos.Para
+			book.OffsetLast = pos.Offset
+			book.ParaTotal = pos.ParaTotal
+			book.PosVersion = common.POS_VERSION
+			// books added by old versions do not have hash and id
+			if book.Hash == "" {
+				book.Hash = bookInfo.Hash
+			}
+			if book.DocId == "" {
+				book.DocId = bookInfo.DocId
+			}
+			// sequence numbers are read since the library tree was added
+			if book.SeqNumber == 0 {
+				book.SeqNumber = bookInfo.SeqNumber
+			}
+			if pos.Line+1 == pos.Total && book.Completed == "" {
 				t := time.Now()
 				book.Completed = t.Format(time.RFC3339)
 			}
-			db.dbDriver.Write(common.DBCOLLECTION, book.Id, &book)
+			return db.saveBook(book)
 		}
 	} else {
-		var book common.BookRecord
-		uid, _ := uuid.NewV4()
-		book.Id = uid.String()
-		book.Title = bookInfo.Title
-		book.FirstName = bookInfo.FirstName
-		book.LastName = bookInfo.LastName
-		book.Sequence = bookInfo.Sequence
-		book.Language = bookInfo.Language
-		book.Genre = bookInfo.Genre
+		book := *bookInfo
 		book.FilePath = bookPath
-		t := time.Now()
-		book.Added = t.Format(time.RFC3339)
-		book.LineLast = pos
-		book.LineTotal = length
-		if db.bookMap == nil {
-			db.bookMap = make(map[string]common.BookRecord, 0)
-		}
-		db.bookMap[book.FilePath] = book
-		db.dbDriver.Write(common.DBCOLLECTION, book.Id, &book)
+		book.LineLast = pos.Line
+		book.LineTotal = pos.Total
+		book.ParaLast = pos.Para
+		book.OffsetLast = pos.Offset
+		book.ParaTotal = pos.ParaTotal
+		book.PosVersion = common.POS_VERSION
+		return db.AddBook(&book)
+	}
+
+	return nil
+}
+
+// AddBook adds a new book to the library. The book gets a new id and
+// the current time as the date it is added
+func (db *ScribbleDb) AddBook(bookInfo *common.BookRecord) error {
+	book := *bookInfo
+	uid, err := uuid.NewV4()
+	if err != nil {
+		return err
 	}
+	book.Id = uid.String()
+	t := time.Now()
+	book.Added = t.Format(time.RFC3339)
+	if err := db.dbDriver.Write(common.DBCOLLECTION, book.Id, &book); err != nil {
+		return err
+	}
+
+	db.bookMap[book.FilePath] = book
+	db.bookList = append(db.bookList, book)
+	db.addContent(book)
+	db.bookFiltered = db.bookFilter()
+	db.bookArraySort()
+	return nil
 }
 
 func (db *ScribbleDb) bookMapToArray() []common.BookRecord {
@@ -104,29 +295,59 @@ func (db *ScribbleDb) bookMapToArray() []common.BookRecord {
 
 func (db *ScribbleDb) bookFilter() []common.BookRecord {
 	list := make([]common.BookRecord, 0)
+	db.scores = nil
 
 	if len(db.bookList) == 0 {
 		return list
 	}
 
+	if db.filterErr != nil {
+		return list
+	}
+
 	flt := strings.ToLower(db.filter)
+	fuzzy := db.filterMode == common.FILTER_FUZZY && db.query == nil && db.filter != ""
 	for _, b := range db.bookList {
-		if db.filter == "" {
+		if db.missingOnly && fileExists(b.FilePath) {
+			continue
+		}
+		if db.filter == "" || fuzzy {
 			list = append(list, b)
-		} else {
-			if strings.Index(strings.ToLower(b.FirstName), flt) != -1 ||
-				strings.Index(strings.ToLower(b.LastName), flt) != -1 ||
-				strings.Index(strings.ToLower(b.Title), flt) != -1 ||
-				strings.Index(strings.ToLower(b.FilePath), flt) != -1 ||
-				strings.Index(strings.ToLower(b.Sequence), flt) != -1 {
+		} else if db.query != nil {
+			if db.query.Match(&b) {
 				list = append(list, b)
 			}
+		} else if strings.Contains(searchText(&b), flt) {
+			list = append(list, b)
 		}
 	}
 
+	if fuzzy {
+		list, db.scores = fuzzyFilter(list, db.filter)
+	}
 	return list
 }
 
+func fileExists(filePath string) bool {
+	_, err := os.Stat(filePath)
+	return !os.IsNotExist(err)
+}
+
+// fillSequenceNumber reads the number of a book in its sequence from the
+// book file if the record has a sequence without a number. It returns true
+// if the number has been found
+func fillSequenceNumber(b *common.BookRecord) bool {
+	if b.Sequence == "" || b.SeqNumber != 0 {
+		return false
+	}
+	info, err := book.ParseBookInfo(b.FilePath)
+	if err != nil || info.SeqNumber == 0 {
+		return false
+	}
+	b.SeqNumber = info.SeqNumber
+	return true
+}
+
 func (db *ScribbleDb) compareByAuthorTitleSequence(b1 *common.BookRecord, b2 *common.BookRecord, asc bool) bool {
 	if b1.LastName < b2.LastName {
 		return asc
@@ -194,14 +415,8 @@ func (db *ScribbleDb) bookArraySort() {
 		})
 	case common.FIELD_PERCENT:
 		sort.SliceStable(db.bookFiltered, func(i, j int) bool {
-			prc1 := 0
-			if db.bookFiltered[i].LineTotal != 0 {
-				prc1 = db.bookFiltered[i].LineLast * 100 / db.bookFiltered[i].LineTotal
-			}
-			prc2 := 0
-			if db.bookFiltered[j].LineTotal != 0 {
-				prc2 = db.bookFiltered[j].LineLast * 100 / db.bookFiltered[j].LineTotal
-			}
+			prc1 := db.bookFiltered[i].Percent()
+			prc2 := db.bookFiltered[j].Percent()
 
 			if prc1 < prc2 {
 				return db.sortAsc
@@ -211,6 +426,45 @@ func (db *ScribbleDb) bookArraySort() {
 				return db.compareByAuthorTitleSequence(&db.bookFiltered[i], &db.bookFiltered[j], db.sortAsc)
 			}
 		})
+	case common.FIELD_RATING:
+		sort.SliceStable(db.bookFiltered, func(i, j int) bool {
+			if db.bookFiltered[i].Rating < db.bookFiltered[j].Rating {
+				return db.sortAsc
+			} else if db.bookFiltered[i].Rating > db.bookFiltered[j].Rating {
+				return !db.sortAsc
+			} else {
+				return db.compareByAuthorTitleSequence(&db.bookFiltered[i], &db.bookFiltered[j], db.sortAsc)
+			}
+		})
+	case common.FIELD_TAGS:
+		sort.SliceStable(db.bookFiltered, func(i, j int) bool {
+			tags1 := common.JoinTags(db.bookFiltered[i].Tags)
+			tags2 := common.JoinTags(db.bookFiltered[j].Tags)
+			if tags1 < tags2 {
+				return db.sortAsc
+			} else if tags1 > tags2 {
+				return !db.sortAsc
+			} else {
+				return db.compareByAuthorTitleSequence(&db.bookFiltered[i], &db.bookFiltered[j], db.sortAsc)
+			}
+		})
+	case common.FIELD_SEQUENCE:
+		sort.SliceStable(db.bookFiltered, func(i, j int) bool {
+			b1, b2 := &db.bookFiltered[i], &db.bookFiltered[j]
+			if b1.Sequence != b2.Sequence {
+				return (b1.Sequence < b2.Sequence) == db.sortAsc
+			} else if b1.SeqNumber != b2.SeqNumber {
+				return (b1.SeqNumber < b2.SeqNumber) == db.sortAsc
+			} else {
+				return db.compareByAuthorTitleSequence(b1, b2, db.sortAsc)
+			}
+		})
+	case common.FIELD_SCORE:
+		// books with the same score are sorted by author
+		sort.SliceStable(db.bookFiltered, func(i, j int) bool {
+			return db.compareByAuthorTitleSequence(&db.bookFiltered[i], &db.bookFiltered[j], true)
+		})
+		sortByScore(db.bookFiltered, db.scores, db.sortAsc)
 	case common.FIELD_AUTHOR:
 		sort.SliceStable(db.bookFiltered, func(i, j int) bool {
 			return db.compareByAuthorTitleSequence(&db.bookFiltered[i], &db.bookFiltered[j], db.sortAsc)
@@ -229,6 +483,7 @@ func (db *ScribbleDb) SetFilter(filter string) {
 	}
 
 	db.filter = filter
+	db.query, db.filterErr = parseFilter(filter)
 	db.bookFiltered = db.bookFilter()
 	db.bookArraySort()
 }
@@ -237,17 +492,35 @@ func (db *ScribbleDb) Filter() string {
 	return db.filter
 }
 
+func (db *ScribbleDb) FilterError() error {
+	return db.filterErr
+}
+
+func (db *ScribbleDb) SetFilterMode(mode string) {
+	if mode == db.filterMode {
+		return
+	}
+
+	db.filterMode = mode
+	db.bookFiltered = db.bookFilter()
+	db.bookArraySort()
+}
+
 func (db *ScribbleDb) FilteredBooks() []common.BookRecord {
 	return db.bookFiltered
 }
 
-func (db *ScribbleDb) DeleteBookByIndex(index int) {
+func (db *ScribbleDb) DeleteBookByIndex(index int) error {
 	if index < 0 || index >= len(db.bookFiltered) {
-		return
+		return nil
 	}
 
 	book := db.bookFiltered[index]
-	db.dbDriver.Delete(common.DBCOLLECTION, book.Id)
+	if err := db.dbDriver.Delete(common.DBCOLLECTION, book.Id); err != nil {
+		return err
+	}
+	delete(db.bookMap, book.FilePath)
+	db.removeContent(book)
 	if index == len(db.bookFiltered)-1 {
 		db.bookFiltered = db.bookFiltered[:index]
 	} else {
@@ -268,6 +541,13 @@ func (db *ScribbleDb) DeleteBookByIndex(index int) {
 			db.bookList = append(db.bookList[:ind], db.bookList[ind+1:]...)
 		}
 	}
+
+	return nil
+}
+
+// ListError always returns nil: all books are kept in memory
+func (db *ScribbleDb) ListError() error {
+	return nil
 }
 
 func (db *ScribbleDb) BookList() []common.BookRecord {
@@ -289,3 +569,183 @@ func (db *ScribbleDb) BookByFilePath(filePath string) (common.BookRecord, bool)
 	b, found := db.bookMap[filePath]
 	return b, found
 }
+
+func (db *ScribbleDb) BookByHash(hash string) (common.BookRecord, bool) {
+	if paths := db.hashPaths[hash]; len(paths) != 0 {
+		return db.bookMap[paths[0]], true
+	}
+	return common.BookRecord{}, false
+}
+
+func (db *ScribbleDb) BooksByContent(hash, docId string) []common.BookRecord {
+	books := make([]common.BookRecord, 0)
+	for _, p := range db.hashPaths[hash] {
+		books = append(books, db.bookMap[p])
+	}
+	for _, p := range db.docIdPaths[docId] {
+		if b := db.bookMap[p]; hash == "" || b.Hash != hash {
+			books = append(books, b)
+		}
+	}
+	return books
+}
+
+// RelinkBook changes the path of a book file, e.g, after the file has been
+// moved to another directory. hash is the content hash of the new file
+func (db *ScribbleDb) RelinkBook(oldPath, newPath, hash string) error {
+	book, found := db.bookMap[oldPath]
+	if !found {
+		return nil
+	}
+
+	book.FilePath = newPath
+	if hash != "" {
+		book.Hash = hash
+	}
+	if err := db.saveBook(book); err != nil {
+		return err
+	}
+	delete(db.bookMap, oldPath)
+	db.bookFiltered = db.bookFilter()
+	db.bookArraySort()
+	return nil
+}
+
+func (db *ScribbleDb) SetMissingOnly(missing bool) {
+	if missing == db.missingOnly {
+		return
+	}
+
+	db.missingOnly = missing
+	db.bookFiltered = db.bookFilter()
+	db.bookArraySort()
+}
+
+func (db *ScribbleDb) MissingOnly() bool {
+	return db.missingOnly
+}
+
+func (db *ScribbleDb) Bookmarks(bookPath string) []common.Bookmark {
+	b, found := db.bookMap[bookPath]
+	if !found {
+		return nil
+	}
+	return b.Bookmarks
+}
+
+func (db *ScribbleDb) AddBookmark(bookPath string, bookmark common.Bookmark) error {
+	book, found := db.bookMap[bookPath]
+	if !found {
+		return fmt.Errorf("book '%s' is not in the library", bookPath)
+	}
+
+	if bookmark.Added == "" {
+		t := time.Now()
+		bookmark.Added = t.Format(time.RFC3339)
+	}
+
+	// keep bookmarks sorted by position
+	idx := sort.Search(len(book.Bookmarks), func(i int) bool {
+		bm := book.Bookmarks[i]
+		return bm.Para > bookmark.Para || (bm.Para == bookmark.Para && bm.Offset > bookmark.Offset)
+	})
+	marks := make([]common.Bookmark, 0, len(book.Bookmarks)+1)
+	marks = append(marks, book.Bookmarks[:idx]...)
+	marks = append(marks, bookmark)
+	book.Bookmarks = append(marks, book.Bookmarks[idx:]...)
+
+	return db.saveBook(book)
+}
+
+func (db *ScribbleDb) RenameBookmark(bookPath string, index int, name string) error {
+	book, found := db.bookMap[bookPath]
+	if !found || index < 0 || index >= len(book.Bookmarks) {
+		return nil
+	}
+
+	marks := make([]common.Bookmark, len(book.Bookmarks))
+	copy(marks, book.Bookmarks)
+	marks[index].Name = name
+	book.Bookmarks = marks
+
+	return db.saveBook(book)
+}
+
+func (db *ScribbleDb) DeleteBookmark(bookPath string, index int) error {
+	book, found := db.bookMap[bookPath]
+	if !found || index < 0 || index >= len(book.Bookmarks) {
+		return nil
+	}
+
+	marks := make([]common.Bookmark, 0, len(book.Bookmarks)-1)
+	marks = append(marks, book.Bookmarks[:index]...)
+	book.Bookmarks = append(marks, book.Bookmarks[index+1:]...)
+
+	return db.saveBook(book)
+}
+
+func (db *ScribbleDb) Sessions(bookPath string) []common.Session {
+	return db.bookMap[bookPath].Sessions
+}
+
+// SaveSession adds a reading session to the book or replaces the session
+// that has the same start time. Old sessions are merged when the book has
+// too many of them, so the book record stays small
+func (db *ScribbleDb) SaveSession(bookPath string, session common.Session) error {
+	book, found := db.bookMap[bookPath]
+	if !found {
+		return fmt.Errorf("book '%s' is not in the library", bookPath)
+	}
+
+	// keep sessions sorted by their start time
+	idx := sort.Search(len(book.Sessions), func(i int) bool {
+		return book.Sessions[i].Start >= session.Start
+	})
+	sessions := make([]common.Session, 0, len(book.Sessions)+1)
+	sessions = append(sessions, book.Sessions[:idx]...)
+	sessions = append(sessions, session)
+	if idx < len(book.Sessions) && book.Sessions[idx].Start == session.Start {
+		idx++
+	}
+	book.Sessions = common.CompactSessions(append(sessions, book.Sessions[idx:]...), time.Now())
+
+	return db.saveBook(book)
+}
+
+// SetTags replaces all tags of the book
+func (db *ScribbleDb) SetTags(bookPath string, tags []string) error {
+	book, found := db.bookMap[bookPath]
+	if !found {
+		return fmt.Errorf("book '%s' is not in the library", bookPath)
+	}
+
+	book.Tags = tags
+	if err := db.saveBook(book); err != nil {
+		return err
+	}
+	// the book may stop matching the filter or change its position
+	db.bookFiltered = db.bookFilter()
+	db.bookArraySort()
+	return nil
+}
+
+// SetRating sets the book rating: from 1 to common.MAX_RATING stars or 0
+// to remove the rating
+func (db *ScribbleDb) SetRating(bookPath string, rating int) error {
+	book, found := db.bookMap[bookPath]
+	if !found {
+		return fmt.Errorf("book '%s' is not in the library", bookPath)
+	}
+	if rating < 0 || rating > common.MAX_RATING {
+		return fmt.Errorf("rating must be from 0 to %d", common.MAX_RATING)
+	}
+
+	book.Rating = rating
+	if err := db.saveBook(book); err != nil {
+		return err
+	}
+	// the book may stop matching the filter or change its position
+	db.bookFiltered = db.bookFilter()
+	db.bookArraySort()
+	return nil
+}
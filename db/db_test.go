@@ -0,0 +1,527 @@
+package db
+
+import (
+	"github.com/VladimirMarkelov/termfb2/common"
+	"io/ioutil"
+	"os"
+	path "path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// libraries opens empty libraries of all backends, so the same tests run
+// against both of them
+func libraries(t *testing.T) map[string]common.BookDb {
+	return openLibraries(t, t.TempDir())
+}
+
+// openLibraries opens libraries of all backends in the directory
+func openLibraries(t *testing.T, dir string) map[string]common.BookDb {
+	scribbleDb, err := InitDb(path.Join(dir, "scribble"))
+	if err == nil {
+		err = scribbleDb.ReadDatabase()
+	}
+	if err != nil {
+		t.Fatalf("failed to open scribble library: %v", err)
+	}
+	sqliteDb, err := InitSqliteDb(path.Join(dir, "sqlite"))
+	if err == nil {
+		err = sqliteDb.ReadDatabase()
+	}
+	if err != nil {
+		t.Fatalf("failed to open sqlite library: %v", err)
+	}
+	t.Cleanup(func() { sqliteDb.db.Close() })
+	return map[string]common.BookDb{common.DB_SCRIBBLE: scribbleDb, common.DB_SQLITE: sqliteDb}
+}
+
+// addLibraryBooks adds the books to the library with AddBook
+func addLibraryBooks(t *testing.T, name string, bookDb common.BookDb, books []common.BookRecord) {
+	for i := range books {
+		if err := bookDb.AddBook(&books[i]); err != nil {
+			t.Fatalf("%s: failed to add a book: %v", name, err)
+		}
+	}
+}
+
+// bookPaths returns file paths of the books in their order
+func bookPaths(books []common.BookRecord) []string {
+	paths := make([]string, len(books))
+	for i, b := range books {
+		paths[i] = b.FilePath
+	}
+	return paths
+}
+
+func TestSetTags(t *testing.T) {
+	for name, bookDb := range libraries(t) {
+		addLibraryBooks(t, name, bookDb, []common.BookRecord{
+			{FilePath: "/a.fb2", Title: "A"},
+			{FilePath: "/b.fb2", Title: "B", Tags: []string{"classic"}},
+		})
+		bookDb.SetFilter("tag:sf")
+
+		tests := []struct {
+			path     string
+			tags     []string
+			filtered []string
+		}{
+			{"/a.fb2", []string{"sf", "to read"}, []string{"/a.fb2"}},
+			{"/b.fb2", []string{"SF"}, []string{"/a.fb2", "/b.fb2"}},
+			{"/a.fb2", []string{}, []string{"/b.fb2"}},
+		}
+		for _, test := range tests {
+			if err := bookDb.SetTags(test.path, test.tags); err != nil {
+				t.Fatalf("%s: SetTags(%s) failed: %v", name, test.path, err)
+			}
+			b, _ := bookDb.BookByFilePath(test.path)
+			if len(b.Tags) != 0 || len(test.tags) != 0 {
+				if !reflect.DeepEqual(b.Tags, test.tags) {
+					t.Errorf("%s: %s has tags %q, want %q", name, test.path, b.Tags, test.tags)
+				}
+			}
+			// the filtered list follows changed tags
+			if paths := bookPaths(bookDb.FilteredBooks()); !reflect.DeepEqual(paths, test.filtered) {
+				t.Errorf("%s: 'tag:sf' found %v after tagging %s, want %v", name, paths, test.path, test.filtered)
+			}
+		}
+		if err := bookDb.SetTags("/missing.fb2", []string{"sf"}); err == nil {
+			t.Errorf("%s: no error for a book that is not in the library", name)
+		}
+	}
+}
+
+func TestSetRating(t *testing.T) {
+	for name, bookDb := range libraries(t) {
+		addLibraryBooks(t, name, bookDb, []common.BookRecord{
+			{FilePath: "/a.fb2", Title: "A"},
+			{FilePath: "/b.fb2", Title: "B", Rating: 4},
+		})
+		bookDb.SetFilter("rating:>=4")
+
+		tests := []struct {
+			path     string
+			rating   int
+			fails    bool
+			filtered []string
+		}{
+			{"/a.fb2", 5, false, []string{"/a.fb2", "/b.fb2"}},
+			{"/b.fb2", 3, false, []string{"/a.fb2"}},
+			{"/a.fb2", 0, false, []string{}},
+			{"/b.fb2", common.MAX_RATING + 1, true, []string{}},
+			{"/b.fb2", -1, true, []string{}},
+			{"/missing.fb2", 4, true, []string{}},
+		}
+		for _, test := range tests {
+			err := bookDb.SetRating(test.path, test.rating)
+			if (err != nil) != test.fails {
+				t.Errorf("%s: SetRating(%s, %d) error = %v, want failure %v", name, test.path, test.rating, err, test.fails)
+				continue
+			}
+			if b, _ := bookDb.BookByFilePath(test.path); !test.fails && b.Rating != test.rating {
+				t.Errorf("%s: %s has rating %d, want %d", name, test.path, b.Rating, test.rating)
+			}
+			// the filtered list follows changed ratings
+			if paths := bookPaths(bookDb.FilteredBooks()); !reflect.DeepEqual(paths, test.filtered) {
+				t.Errorf("%s: 'rating:>=4' found %v after rating %s, want %v", name, paths, test.path, test.filtered)
+			}
+		}
+
+		// books are sorted by rating
+		bookDb.SetFilter("")
+		bookDb.SetRating("/b.fb2", 2)
+		bookDb.SetRating("/a.fb2", 4)
+		bookDb.SetSortMode(common.FIELD_RATING, false)
+		if paths := bookPaths(bookDb.FilteredBooks()); !reflect.DeepEqual(paths, []string{"/a.fb2", "/b.fb2"}) {
+			t.Errorf("%s: books sorted by rating %v, want [/a.fb2 /b.fb2]", name, paths)
+		}
+		bookDb.SetRating("/b.fb2", 5)
+		if paths := bookPaths(bookDb.FilteredBooks()); !reflect.DeepEqual(paths, []string{"/b.fb2", "/a.fb2"}) {
+			t.Errorf("%s: books sorted by rating %v after rating change, want [/b.fb2 /a.fb2]", name, paths)
+		}
+	}
+}
+
+func TestSortBySequence(t *testing.T) {
+	for name, bookDb := range libraries(t) {
+		addLibraryBooks(t, name, bookDb, []common.BookRecord{
+			{FilePath: "/noon2.fb2", LastName: "A", Sequence: "Noon", SeqNumber: 2},
+			{FilePath: "/noon1.fb2", LastName: "B", Sequence: "Noon", SeqNumber: 1},
+			{FilePath: "/single.fb2", LastName: "C"},
+			{FilePath: "/alpha.fb2", LastName: "D", Sequence: "Alpha", SeqNumber: 3},
+			{FilePath: "/noon.fb2", LastName: "E", Sequence: "Noon"},
+		})
+
+		tests := []struct {
+			asc   bool
+			paths []string
+		}{
+			{true, []string{"/single.fb2", "/alpha.fb2", "/noon.fb2", "/noon1.fb2", "/noon2.fb2"}},
+			{false, []string{"/noon2.fb2", "/noon1.fb2", "/noon.fb2", "/alpha.fb2", "/single.fb2"}},
+		}
+		for _, test := range tests {
+			bookDb.SetSortMode(common.FIELD_SEQUENCE, test.asc)
+			if paths := bookPaths(bookDb.FilteredBooks()); !reflect.DeepEqual(paths, test.paths) {
+				t.Errorf("%s: books sorted by sequence (asc %v) %v, want %v", name, test.asc, paths, test.paths)
+			}
+		}
+	}
+}
+
+func TestSortByScore(t *testing.T) {
+	for name, bookDb := range libraries(t) {
+		addLibraryBooks(t, name, bookDb, []common.BookRecord{
+			{FilePath: "/misspelled.fb2", LastName: "Strugotsky", Title: "A"},
+			{FilePath: "/picnic.fb2", LastName: "Strugatsky", Title: "Roadside Picnic"},
+			{FilePath: "/cyrillic.fb2", LastName: "Стругацкий", Title: "Пикник на обочине"},
+		})
+		bookDb.SetFilterMode(common.FILTER_FUZZY)
+		bookDb.SetFilter("strugatsky")
+
+		// turning column sorting off returns to the best matches first
+		tests := []struct {
+			field string
+			asc   bool
+			paths []string
+		}{
+			{common.FIELD_SCORE, false, []string{"/picnic.fb2", "/cyrillic.fb2", "/misspelled.fb2"}},
+			{common.FIELD_TITLE, true, []string{"/misspelled.fb2", "/picnic.fb2", "/cyrillic.fb2"}},
+			{common.FIELD_SCORE, false, []string{"/picnic.fb2", "/cyrillic.fb2", "/misspelled.fb2"}},
+		}
+		for _, test := range tests {
+			bookDb.SetSortMode(test.field, test.asc)
+			if paths := bookPaths(bookDb.FilteredBooks()); !reflect.DeepEqual(paths, test.paths) {
+				t.Errorf("%s: books sorted by %s %v, want %v", name, test.field, paths, test.paths)
+			}
+		}
+	}
+}
+
+func TestFilterBackends(t *testing.T) {
+	books := []common.BookRecord{
+		{FilePath: "/books/picnic.fb2", FirstName: "Arkady", LastName: "Strugatsky", Title: "Roadside Picnic",
+			Sequence: "Noon Universe", Language: "en", Genre: "sf", Tags: []string{"sf", "to read"}, Rating: 5,
+			LineLast: 90, LineTotal: 100},
+		{FilePath: "/books/war_and_peace.fb2", FirstName: "Leo", LastName: "Tolstoy", Title: "War and Peace",
+			Language: "en", Tags: []string{"classic"}, Rating: 3, Completed: "2023-06-01T10:00:00Z"},
+		{FilePath: "/books/пикник.fb2", FirstName: "Аркадий", LastName: "Стругацкий", Title: "Пикник на обочине",
+			Language: "ru", Tags: []string{"SF"}, Completed: "2024-03-01T10:00:00Z"},
+		{FilePath: "/books/smith.txt", FirstName: "John", LastName: "Smith", Title: "Notes"},
+	}
+	tests := []struct {
+		filter string
+		mode   string
+		paths  []string
+	}{
+		{"", common.FILTER_SUBSTRING, []string{"/books/picnic.fb2", "/books/smith.txt",
+			"/books/war_and_peace.fb2", "/books/пикник.fb2"}},
+		// the author is the first and the last name together
+		{"john smith", common.FILTER_SUBSTRING, []string{"/books/smith.txt"}},
+		{`"john smith"`, common.FILTER_SUBSTRING, []string{"/books/smith.txt"}},
+		{`author:"john smith"`, common.FILTER_SUBSTRING, []string{"/books/smith.txt"}},
+		{"smith john", common.FILTER_SUBSTRING, []string{}},
+		{"arkady", common.FILTER_SUBSTRING, []string{"/books/picnic.fb2"}},
+		{"АРКАДИЙ", common.FILTER_SUBSTRING, []string{"/books/пикник.fb2"}},
+		{"ад", common.FILTER_SUBSTRING, []string{"/books/пикник.fb2"}},
+		// a filter never matches parts of two fields or two tags
+		{"picnic noon", common.FILTER_SUBSTRING, []string{}},
+		{"sf to", common.FILTER_SUBSTRING, []string{}},
+		{"sf, to", common.FILTER_SUBSTRING, []string{}},
+		{"to read", common.FILTER_SUBSTRING, []string{"/books/picnic.fb2"}},
+		{"universe", common.FILTER_SUBSTRING, []string{"/books/picnic.fb2"}},
+		{"smith.txt", common.FILTER_SUBSTRING, []string{"/books/smith.txt"}},
+		{"_and_", common.FILTER_SUBSTRING, []string{"/books/war_and_peace.fb2"}},
+		{"%", common.FILTER_SUBSTRING, []string{}},
+		{"tag:sf", common.FILTER_SUBSTRING, []string{"/books/picnic.fb2", "/books/пикник.fb2"}},
+		{"tag:s", common.FILTER_SUBSTRING, []string{}},
+		{"-tag:sf lang:en", common.FILTER_SUBSTRING, []string{"/books/war_and_peace.fb2"}},
+		{"title:picnic", common.FILTER_SUBSTRING, []string{"/books/picnic.fb2"}},
+		{"seq:noon", common.FILTER_SUBSTRING, []string{"/books/picnic.fb2"}},
+		{"genre:sf", common.FILTER_SUBSTRING, []string{"/books/picnic.fb2"}},
+		{"path:smith", common.FILTER_SUBSTRING, []string{"/books/smith.txt"}},
+		{"rating:>=3 -rating:5", common.FILTER_SUBSTRING, []string{"/books/war_and_peace.fb2"}},
+		{"done:>50", common.FILTER_SUBSTRING, []string{"/books/picnic.fb2"}},
+		{"completed:2024", common.FILTER_SUBSTRING, []string{"/books/пикник.fb2"}},
+		{"completed:<=2024-03", common.FILTER_SUBSTRING, []string{"/books/war_and_peace.fb2", "/books/пикник.fb2"}},
+		{"-completed:2024", common.FILTER_SUBSTRING, []string{"/books/picnic.fb2", "/books/smith.txt",
+			"/books/war_and_peace.fb2"}},
+		{"strugatsky", common.FILTER_FUZZY, []string{"/books/picnic.fb2", "/books/пикник.fb2"}},
+		{"tolstoi", common.FILTER_FUZZY, []string{"/books/war_and_peace.fb2"}},
+	}
+
+	for name, bookDb := range libraries(t) {
+		addLibraryBooks(t, name, bookDb, books)
+		for _, test := range tests {
+			bookDb.SetFilterMode(test.mode)
+			bookDb.SetFilter(test.filter)
+			if err := bookDb.FilterError(); err != nil {
+				t.Errorf("%s: filter %q is invalid: %v", name, test.filter, err)
+				continue
+			}
+			paths := bookPaths(bookDb.FilteredBooks())
+			sort.Strings(paths)
+			if !reflect.DeepEqual(paths, test.paths) {
+				t.Errorf("%s: filter %q (%s) found %v, want %v", name, test.filter, test.mode, paths, test.paths)
+			}
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	type step struct {
+		path string
+		pos  common.Position
+	}
+	tests := []struct {
+		name string
+		// the reader saves positions of the books
+		steps  []step
+		filter string
+		// books sorted by progress
+		paths    []string
+		percents []int
+	}{
+		// the book saved by an old version keeps its line based progress
+		// until it is opened again
+		{"stored", nil, "", []string{"/unread.fb2", "/new.fb2", "/old.fb2"}, []int{0, 10, 25}},
+		{"done", nil, "done:10", []string{"/new.fb2"}, []int{10}},
+		{"line progress", nil, "done:90", []string{}, []int{}},
+		// the reader width changes the number of lines but not the progress
+		{"width", []step{{"/new.fb2", common.Position{Line: 50, Total: 500, Para: 10, Offset: 3, ParaTotal: 100}}},
+			"", []string{"/unread.fb2", "/new.fb2", "/old.fb2"}, []int{0, 10, 25}},
+		{"migrated", []step{{"/old.fb2", common.Position{Line: 1200, Total: 2000, Para: 20, ParaTotal: 100}}},
+			"", []string{"/unread.fb2", "/new.fb2", "/old.fb2"}, []int{0, 10, 20}},
+		{"migrated done", nil, "done:>=20", []string{"/old.fb2"}, []int{20}},
+		{"notes", []step{{"/new.fb2", common.Position{Line: 10, Total: 500, Para: 120, ParaTotal: 100}}},
+			"", []string{"/unread.fb2", "/old.fb2", "/new.fb2"}, []int{0, 20, 100}},
+	}
+
+	for name, bookDb := range libraries(t) {
+		addLibraryBooks(t, name, bookDb, []common.BookRecord{
+			{FilePath: "/old.fb2", LastName: "A", LineLast: 250, LineTotal: 1000},
+			{FilePath: "/new.fb2", LastName: "B", LineLast: 900, LineTotal: 1000,
+				ParaLast: 10, OffsetLast: 3, ParaTotal: 100, PosVersion: common.POS_VERSION},
+			{FilePath: "/unread.fb2", LastName: "C"},
+		})
+		bookDb.SetSortMode(common.FIELD_PERCENT, true)
+		for _, test := range tests {
+			for _, s := range test.steps {
+				if err := bookDb.UpdateBookInDb(s.path, s.pos, &common.BookRecord{}); err != nil {
+					t.Fatalf("%s: %s: failed to save the position: %v", name, test.name, err)
+				}
+			}
+			bookDb.SetFilter(test.filter)
+			books := bookDb.FilteredBooks()
+			percents := make([]int, 0, len(books))
+			for _, b := range books {
+				percents = append(percents, b.Percent())
+			}
+			if paths := bookPaths(books); !reflect.DeepEqual(paths, test.paths) {
+				t.Errorf("%s: %s: books %v, want %v", name, test.name, paths, test.paths)
+			}
+			if !reflect.DeepEqual(percents, test.percents) {
+				t.Errorf("%s: %s: progress %v, want %v", name, test.name, percents, test.percents)
+			}
+		}
+
+		b, _ := bookDb.BookByFilePath("/old.fb2")
+		if b.PosVersion != common.POS_VERSION || b.ParaTotal != 100 {
+			t.Errorf("%s: opened book has position version %d and %d paragraphs, want %d and 100",
+				name, b.PosVersion, b.ParaTotal, common.POS_VERSION)
+		}
+	}
+}
+
+// bookmarkNames returns names of the bookmarks in their order
+func bookmarkNames(marks []common.Bookmark) []string {
+	names := make([]string, 0, len(marks))
+	for _, bm := range marks {
+		names = append(names, bm.Name)
+	}
+	return names
+}
+
+func TestBookmarks(t *testing.T) {
+	const bookPath = "/books/picnic.fb2"
+	tests := []struct {
+		name  string
+		edit  func(bookDb common.BookDb) error
+		names []string
+	}{
+		// bookmarks are sorted by position, a new bookmark goes after
+		// the bookmarks at the same position
+		{"add", func(bookDb common.BookDb) error {
+			for _, bm := range []common.Bookmark{
+				{Name: "c", Para: 30},
+				{Name: "a", Para: 10, Offset: 5},
+				{Name: "b", Para: 10, Offset: 5},
+				{Name: "start", Added: "2024-01-01T10:00:00Z"},
+				{Name: "a0", Para: 10, Offset: 4},
+			} {
+				if err := bookDb.AddBookmark(bookPath, bm); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, []string{"start", "a0", "a", "b", "c"}},
+		{"rename", func(bookDb common.BookDb) error {
+			return bookDb.RenameBookmark(bookPath, 3, "b2")
+		}, []string{"start", "a0", "a", "b2", "c"}},
+		{"delete", func(bookDb common.BookDb) error {
+			return bookDb.DeleteBookmark(bookPath, 1)
+		}, []string{"start", "a", "b2", "c"}},
+		{"out of range", func(bookDb common.BookDb) error {
+			if err := bookDb.RenameBookmark(bookPath, 4, "x"); err != nil {
+				return err
+			}
+			return bookDb.DeleteBookmark(bookPath, -1)
+		}, []string{"start", "a", "b2", "c"}},
+	}
+
+	dir := t.TempDir()
+	for name, bookDb := range openLibraries(t, dir) {
+		if err := bookDb.AddBookmark(bookPath, common.Bookmark{Name: "a"}); err == nil {
+			t.Errorf("%s: a bookmark is added to a book that is not in the library", name)
+		}
+		addLibraryBooks(t, name, bookDb, []common.BookRecord{{FilePath: bookPath}, {FilePath: "/books/other.fb2"}})
+		for _, test := range tests {
+			if err := test.edit(bookDb); err != nil {
+				t.Fatalf("%s: %s: %v", name, test.name, err)
+			}
+			if names := bookmarkNames(bookDb.Bookmarks(bookPath)); !reflect.DeepEqual(names, test.names) {
+				t.Errorf("%s: %s: bookmarks %v, want %v", name, test.name, names, test.names)
+			}
+		}
+		marks := bookDb.Bookmarks(bookPath)
+		if marks[0].Added != "2024-01-01T10:00:00Z" || marks[1].Added == "" {
+			t.Errorf("%s: bookmarks are added at %q and %q, want the given time and the current one",
+				name, marks[0].Added, marks[1].Added)
+		}
+		if len(bookDb.Bookmarks("/books/other.fb2")) != 0 {
+			t.Errorf("%s: bookmarks are added to another book", name)
+		}
+	}
+
+	// bookmarks are kept in the library
+	for name, bookDb := range openLibraries(t, dir) {
+		want := []string{"start", "a", "b2", "c"}
+		if names := bookmarkNames(bookDb.Bookmarks(bookPath)); !reflect.DeepEqual(names, want) {
+			t.Errorf("%s: bookmarks %v after reopening, want %v", name, names, want)
+		}
+		marks := bookDb.Bookmarks(bookPath)
+		if len(marks) > 1 && (marks[1].Para != 10 || marks[1].Offset != 5) {
+			t.Errorf("%s: bookmark position %d:%d after reopening, want 10:5", name, marks[1].Para, marks[1].Offset)
+		}
+	}
+}
+
+func TestScribbleQuarantine(t *testing.T) {
+	dir := t.TempDir()
+	collection := path.Join(dir, common.DBFILE, common.DBCOLLECTION)
+	if err := os.MkdirAll(collection, 0755); err != nil {
+		t.Fatal(err)
+	}
+	records := map[string]string{
+		"1.json":    `{"Id": "1", "FilePath": "/books/a.fb2", "Title": "First"}`,
+		"bad.json":  `{"Id": `,
+		"notes.txt": `not a record`,
+	}
+	for name, text := range records {
+		if err := ioutil.WriteFile(path.Join(collection, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := InitDb(dir)
+	if err != nil {
+		t.Fatalf("failed to open the library: %v", err)
+	}
+	err = db.ReadDatabase()
+	qerr, ok := err.(*common.QuarantineError)
+	quarantine := path.Join(dir, common.DBFILE, common.QUARANTINEDIR)
+	if !ok || qerr.Dir != quarantine || !reflect.DeepEqual(qerr.Files, []string{"bad.json"}) {
+		t.Fatalf("reading error %#v, want quarantine of bad.json", err)
+	}
+	// the rest of the library is loaded
+	if paths := bookPaths(db.BookList()); !reflect.DeepEqual(paths, []string{"/books/a.fb2"}) {
+		t.Errorf("library books %v, want [/books/a.fb2]", paths)
+	}
+	if _, err := os.Stat(path.Join(quarantine, "bad.json")); err != nil {
+		t.Errorf("damaged record has not been moved: %v", err)
+	}
+	if _, err := os.Stat(path.Join(collection, "notes.txt")); err != nil {
+		t.Errorf("a file that is not a record has been moved: %v", err)
+	}
+
+	if err := db.ReadDatabase(); err != nil {
+		t.Errorf("error after the damaged record has been moved: %v", err)
+	}
+}
+
+// breakLibrary makes the library storage unwritable
+func breakLibrary(t *testing.T, bookDb common.BookDb) {
+	switch d := bookDb.(type) {
+	case *ScribbleDb:
+		collection := path.Join(d.dir, common.DBCOLLECTION)
+		if err := os.RemoveAll(collection); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(collection, []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	case *SqliteDb:
+		d.db.Close()
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	const bookPath = "/books/picnic.fb2"
+	changes := []struct {
+		name   string
+		change func(bookDb common.BookDb) error
+	}{
+		{"add", func(bookDb common.BookDb) error {
+			return bookDb.AddBook(&common.BookRecord{FilePath: "/books/new.fb2"})
+		}},
+		{"position", func(bookDb common.BookDb) error {
+			return bookDb.UpdateBookInDb(bookPath, common.Position{Line: 10, Total: 100, Para: 5}, &common.BookRecord{})
+		}},
+		{"new book position", func(bookDb common.BookDb) error {
+			return bookDb.UpdateBookInDb("/books/new.fb2", common.Position{Para: 5}, &common.BookRecord{})
+		}},
+		{"rating", func(bookDb common.BookDb) error { return bookDb.SetRating(bookPath, 5) }},
+		{"tags", func(bookDb common.BookDb) error { return bookDb.SetTags(bookPath, []string{"sf"}) }},
+		{"bookmark", func(bookDb common.BookDb) error {
+			return bookDb.AddBookmark(bookPath, common.Bookmark{Name: "mark"})
+		}},
+		{"session", func(bookDb common.BookDb) error {
+			return bookDb.SaveSession(bookPath, common.Session{Start: "2024-01-01T10:00:00Z"})
+		}},
+		{"relink", func(bookDb common.BookDb) error { return bookDb.RelinkBook(bookPath, "/books/moved.fb2", "") }},
+		{"delete", func(bookDb common.BookDb) error { return bookDb.DeleteBookByIndex(0) }},
+	}
+
+	for name, bookDb := range libraries(t) {
+		addLibraryBooks(t, name, bookDb, []common.BookRecord{{FilePath: bookPath, Title: "Picnic", Rating: 3}})
+		if err := bookDb.SetRating(bookPath, common.MAX_RATING+1); err == nil {
+			t.Errorf("%s: invalid rating is saved", name)
+		}
+		if err := bookDb.SetTags("/books/unknown.fb2", []string{"sf"}); err == nil {
+			t.Errorf("%s: tags of an unknown book are saved", name)
+		}
+
+		bookDb.FilteredBooks()
+		breakLibrary(t, bookDb)
+		for _, c := range changes {
+			if err := c.change(bookDb); err == nil {
+				t.Errorf("%s: %s: no error when the library cannot be written", name, c.name)
+			}
+		}
+		// the lists keep the books that have been read before
+		books := bookDb.FilteredBooks()
+		if len(books) != 1 || books[0].FilePath != bookPath || books[0].Rating != 3 || len(books[0].Tags) != 0 {
+			t.Errorf("%s: library books %+v after failed changes, want the book without changes", name, books)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"github.com/VladimirMarkelov/termfb2/common"
+	path "path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// cyrillic maps Russian, Ukrainian and Belarusian letters to Latin ones
+var cyrillic = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "i", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'і': "i", 'ї': "i", 'є': "e", 'ґ': "g", 'ў': "u",
+}
+
+// diacritics maps Latin letters with diacritics to their base letters
+var diacritics = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i", 'ı': "i",
+	'ł': "l", 'ľ': "l", 'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o", 'œ': "oe",
+	'ř': "r", 'ś': "s", 'š': "s", 'ş': "s", 'ß': "ss", 'ť': "t", 'ţ': "t",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// spellings replaces letter combinations that are spelled differently by
+// transliteration systems, so "Strugatsky", "Strugackij" and "Стругацкий"
+// get the same normalized form
+var spellings = strings.NewReplacer(
+	"shch", "sc", "tch", "c", "ts", "c", "tz", "c", "kh", "h", "ph", "f",
+	"w", "v", "ye", "e", "y", "i", "j", "i", "x", "ks", "q", "k",
+)
+
+// Normalize converts a text to lowercase Latin letters without diacritics
+// and unifies transliteration variants. Doubled letters are collapsed and
+// everything except letters and digits becomes a space
+func Normalize(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if latin, ok := cyrillic[r]; ok {
+			b.WriteString(latin)
+		} else if latin, ok := diacritics[r]; ok {
+			b.WriteString(latin)
+		} else if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		} else if r != '\'' && r != '`' {
+			b.WriteRune(' ')
+		}
+	}
+
+	runes := []rune(spellings.Replace(b.String()))
+	out := make([]rune, 0, len(runes))
+	for i, r := range runes {
+		if i == 0 || r != runes[i-1] || unicode.IsDigit(r) {
+			out = append(out, r)
+		}
+	}
+	return string(out)
+}
+
+// maxTypos returns the number of typos allowed in a word of the filter
+func maxTypos(word []rune) int {
+	return len(word) / 4
+}
+
+// distance is Levenshtein distance between two words
+func distance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// wordScore returns how well the filter word matches the text word: 100
+// if the text word starts with the filter word, a bit less if it contains
+// the word, and less for every typo. It returns 0 if the words differ
+func wordScore(word []rune, textWord string) int {
+	if strings.HasPrefix(textWord, string(word)) {
+		return 100
+	}
+	if strings.Contains(textWord, string(word)) {
+		return 90
+	}
+
+	typos := maxTypos(word)
+	if typos == 0 {
+		return 0
+	}
+	// the filter word is compared to the beginning of the text word to
+	// allow typing the first letters of a long word
+	text := []rune(textWord)
+	best := typos + 1
+	for n := len(word) - typos; n <= len(word)+typos && n <= len(text); n++ {
+		if n <= 0 {
+			continue
+		}
+		if d := distance(word, text[:n]); d < best {
+			best = d
+		}
+	}
+	if best > typos {
+		return 0
+	}
+	return 80 - 20*best
+}
+
+// FuzzyScore returns how well the filter matches the text: from 1 to 100
+// if every filter word is found in the text, allowing transliteration and
+// typos, and 0 if the text does not match
+func FuzzyScore(filter, text string) int {
+	words := strings.Fields(Normalize(filter))
+	if len(words) == 0 {
+		return 100
+	}
+	textWords := strings.Fields(Normalize(text))
+
+	total := 0
+	for _, w := range words {
+		word := []rune(w)
+		best := 0
+		for _, tw := range textWords {
+			if s := wordScore(word, tw); s > best {
+				best = s
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+	return total / len(words)
+}
+
+// fuzzyText is the text of a book a fuzzy filter looks in
+func fuzzyText(b *common.BookRecord) string {
+	fields := []string{b.FirstName, b.LastName, b.Title, b.Sequence, common.JoinTags(b.Tags), path.Base(b.FilePath)}
+	return strings.Join(fields, " ")
+}
+
+// fuzzyFilter returns books that match the filter and scores of all
+// matched books by their ids
+func fuzzyFilter(books []common.BookRecord, filter string) ([]common.BookRecord, map[string]int) {
+	list := make([]common.BookRecord, 0)
+	scores := make(map[string]int)
+	for _, b := range books {
+		if score := FuzzyScore(filter, fuzzyText(&b)); score > 0 {
+			list = append(list, b)
+			scores[b.Id] = score
+		}
+	}
+	return list, scores
+}
+
+// sortByScore sorts books by their fuzzy scores. Books with the same
+// score keep their order
+func sortByScore(books []common.BookRecord, scores map[string]int, asc bool) {
+	sort.SliceStable(books, func(i, j int) bool {
+		s1, s2 := scores[books[i].Id], scores[books[j].Id]
+		if asc {
+			return s1 < s2
+		}
+		return s1 > s2
+	})
+}
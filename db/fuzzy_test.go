@@ -0,0 +1,111 @@
+package db
+
+import (
+	"github.com/VladimirMarkelov/termfb2/common"
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		text string
+		norm string
+	}{
+		{"Стругацкий", "strugacki"},
+		{"Strugatsky", "strugacki"},
+		{"Strugackij", "strugacki"},
+		{"Толстой", "tolstoi"},
+		{"Tolstoy", "tolstoi"},
+		{"Щукин", "scukin"},
+		{"Жук", "zhuk"},
+		{"Émile Zola", "emile zola"},
+		{"Straße", "strase"},
+		{"D'Artagnan", "dartagnan"},
+		{"Hello, World!", "helo vorld "},
+		{"1001 ночь", "1001 noch"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if norm := Normalize(test.text); norm != test.norm {
+			t.Errorf("Normalize(%q) = %q, want %q", test.text, norm, test.norm)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		dist int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"abc", "abc", 0},
+		{"abc", "abd", 1},
+		{"kitten", "sitting", 3},
+		{"струг", "строг", 1},
+	}
+	for _, test := range tests {
+		if d := distance([]rune(test.a), []rune(test.b)); d != test.dist {
+			t.Errorf("distance(%q, %q) = %d, want %d", test.a, test.b, d, test.dist)
+		}
+	}
+}
+
+func TestFuzzyScore(t *testing.T) {
+	tests := []struct {
+		filter string
+		text   string
+		score  int
+	}{
+		{"", "anything", 100},
+		{"strug", "Strugatsky", 100},
+		{"strugatsky", "Аркадий Стругацкий", 100},
+		{"tolstoi", "Лев Толстой", 100},
+		{"picnic road", "Roadside Picnic", 100},
+		{"gatsk", "Strugatsky", 90},
+		// one typo
+		{"strugotsky", "Strugatsky", 60},
+		{"strugatskie", "Strugatsky", 60},
+		// two typos
+		{"stragotsky", "Strugatsky", 40},
+		// short words allow no typos
+		{"abc", "abd", 0},
+		{"picnic moon", "Roadside Picnic", 0},
+	}
+	for _, test := range tests {
+		if score := FuzzyScore(test.filter, test.text); score != test.score {
+			t.Errorf("FuzzyScore(%q, %q) = %d, want %d", test.filter, test.text, score, test.score)
+		}
+	}
+}
+
+func TestFuzzyFilter(t *testing.T) {
+	books := []common.BookRecord{
+		{Id: "1", LastName: "Strugatsky", Title: "Roadside Picnic"},
+		{Id: "2", LastName: "Tolstoy", Title: "War and Peace"},
+		{Id: "3", LastName: "Стругацкий", Title: "Пикник на обочине"},
+		{Id: "4", LastName: "Strugotsky", Title: "Misspelled"},
+	}
+	tests := []struct {
+		filter string
+		asc    bool
+		ids    []string
+	}{
+		{"strugatsky", false, []string{"1", "3", "4"}},
+		{"strugatsky", true, []string{"4", "1", "3"}},
+		{"peace", false, []string{"2"}},
+		{"dostoevsky", false, []string{}},
+	}
+	for _, test := range tests {
+		list, scores := fuzzyFilter(books, test.filter)
+		sortByScore(list, scores, test.asc)
+		ids := make([]string, len(list))
+		for i, b := range list {
+			ids[i] = b.Id
+		}
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("fuzzy filter %q found %v, want %v", test.filter, ids, test.ids)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"github.com/VladimirMarkelov/termfb2/common"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Query is a parsed library filter, e.g.
+//
+//	author:strugatsky lang:ru done:<50 added:>2024-01 -tag:read "exact phrase"
+//
+// A book matches the query if it matches all terms
+type Query struct {
+	terms []queryTerm
+}
+
+// queryTerm is a single condition of a query. A term that starts with '-'
+// matches books that do not match the condition. The field, the operator
+// and the argument are kept to translate the term to SQL
+type queryTerm struct {
+	negate bool
+	field  string
+	op     string
+	arg    string
+	match  func(b *common.BookRecord) bool
+}
+
+// textFields are fields that match books which field contains the value
+var textFields = map[string]func(b *common.BookRecord) string{
+	"author":   bookAuthor,
+	"title":    func(b *common.BookRecord) string { return b.Title },
+	"seq":      func(b *common.BookRecord) string { return b.Sequence },
+	"genre":    func(b *common.BookRecord) string { return b.Genre },
+	"lang":     func(b *common.BookRecord) string { return b.Language },
+	"sequence": func(b *common.BookRecord) string { return b.Sequence },
+	"path":     func(b *common.BookRecord) string { return b.FilePath },
+	"id":       func(b *common.BookRecord) string { return b.Id },
+}
+
+// numberFields are fields compared as numbers: done:50, done:<50, rating:>=4
+var numberFields = map[string]func(b *common.BookRecord) int{
+	"done":   (*common.BookRecord).Percent,
+	"rating": func(b *common.BookRecord) int { return b.Rating },
+}
+
+// dateFields are dates compared with the precision of the value:
+// added:2024 is any day of 2024, added:>2024-01 is after January of 2024
+var dateFields = map[string]func(b *common.BookRecord) string{
+	"added":     func(b *common.BookRecord) string { return b.Added },
+	"completed": func(b *common.BookRecord) string { return b.Completed },
+}
+
+// tagField matches books that have the tag, ignoring case
+const tagField = "tag"
+
+var dateValue = regexp.MustCompile(`^\d{4}(-\d{2}(-\d{2})?)?$`)
+
+// isQueryField returns true if the name is a field of a query term
+func isQueryField(name string) bool {
+	_, text := textFields[name]
+	_, number := numberFields[name]
+	_, date := dateFields[name]
+	return text || number || date || name == tagField
+}
+
+// termField splits a term into a lowercase field name and a value. The
+// field is empty if the term does not start with a known field name
+func termField(term string) (string, string) {
+	idx := strings.Index(term, ":")
+	if idx <= 0 {
+		return "", term
+	}
+	name := strings.ToLower(term[:idx])
+	if !isQueryField(name) {
+		return "", term
+	}
+	return name, term[idx+1:]
+}
+
+// IsQuery returns true if the filter uses the query syntax: field:value
+// terms or quoted phrases. Other filters are plain text that is looked
+// for in author, title, sequence, file path and tags
+func IsQuery(filter string) bool {
+	if strings.Contains(filter, `"`) {
+		return true
+	}
+	for _, word := range strings.Fields(filter) {
+		if field, _ := termField(strings.TrimPrefix(word, "-")); field != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// splitQuery splits the filter into terms separated by spaces. Spaces
+// inside quotes do not split terms
+func splitQuery(filter string) ([]string, error) {
+	terms := make([]string, 0)
+	var term strings.Builder
+	inQuote := false
+	for _, r := range filter {
+		if r == '"' {
+			inQuote = !inQuote
+		}
+		if unicode.IsSpace(r) && !inQuote {
+			if term.Len() != 0 {
+				terms = append(terms, term.String())
+				term.Reset()
+			}
+			continue
+		}
+		term.WriteRune(r)
+	}
+	if inQuote {
+		return nil, errors.New("missing closing quote")
+	}
+	if term.Len() != 0 {
+		terms = append(terms, term.String())
+	}
+	return terms, nil
+}
+
+// parseFilter returns the query of the filter. The query is nil if the
+// filter is plain text
+func parseFilter(filter string) (*Query, error) {
+	if !IsQuery(filter) {
+		return nil, nil
+	}
+	return ParseQuery(filter)
+}
+
+// ParseQuery parses a filter that uses the query syntax (see IsQuery)
+func ParseQuery(filter string) (*Query, error) {
+	terms, err := splitQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Query{terms: make([]queryTerm, 0, len(terms))}
+	for _, s := range terms {
+		term := queryTerm{}
+		if len(s) > 1 && s[0] == '-' {
+			term.negate = true
+			s = s[1:]
+		}
+		field, value := termField(s)
+		value = strings.Replace(value, `"`, "", -1)
+		if value == "" {
+			if field == "" {
+				continue
+			}
+			return nil, fmt.Errorf("'%s' has no value", s)
+		}
+
+		if term.match, err = termMatcher(field, value); err != nil {
+			return nil, fmt.Errorf("'%s': %v", s, err)
+		}
+		term.field = field
+		term.op, term.arg = "=", value
+		if isComparisonField(field) {
+			term.op, term.arg = splitComparison(value)
+		}
+		q.terms = append(q.terms, term)
+	}
+	return q, nil
+}
+
+// bookAuthor is the author text that filters look in. All library
+// backends use it, so a filter finds the same books in all of them
+func bookAuthor(b *common.BookRecord) string {
+	return b.FirstName + " " + b.LastName
+}
+
+// tagsText is the text of tags that filters look in. Tags are separated
+// by new lines, and the list starts and ends with a new line, so a whole
+// tag can be found, and a filter never matches parts of two tags
+func tagsText(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return "\n" + strings.Join(tags, "\n") + "\n"
+}
+
+// plainFields are the book fields that a plain text filter looks in, in
+// the order of plainColumns
+func plainFields(b *common.BookRecord) []string {
+	return []string{bookAuthor(b), b.Title, b.Sequence, b.FilePath, tagsText(b.Tags)}
+}
+
+// searchText is the lowercase text that a plain text filter looks in.
+// Fields are separated by new lines, so a filter matches a single field
+func searchText(b *common.BookRecord) string {
+	return strings.ToLower(strings.Join(plainFields(b), "\n"))
+}
+
+// termMatcher creates a condition of the field. A value without a field
+// is looked for in the same fields as a plain text filter
+func termMatcher(field, value string) (func(b *common.BookRecord) bool, error) {
+	lower := strings.ToLower(value)
+	if getText, ok := textFields[field]; ok {
+		return func(b *common.BookRecord) bool {
+			return strings.Contains(strings.ToLower(getText(b)), lower)
+		}, nil
+	}
+
+	if getNumber, ok := numberFields[field]; ok {
+		op, arg := splitComparison(value)
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a number", arg)
+		}
+		return func(b *common.BookRecord) bool {
+			return compare(getNumber(b)-n, op)
+		}, nil
+	}
+
+	if getDate, ok := dateFields[field]; ok {
+		op, arg := splitComparison(value)
+		if !dateValue.MatchString(arg) {
+			return nil, fmt.Errorf("'%s' is not a date, use YYYY, YYYY-MM, or YYYY-MM-DD", arg)
+		}
+		return func(b *common.BookRecord) bool {
+			date := getDate(b)
+			if len(date) < len(arg) {
+				return false
+			}
+			return compare(strings.Compare(date[:len(arg)], arg), op)
+		}, nil
+	}
+
+	if field == tagField {
+		return func(b *common.BookRecord) bool {
+			for _, tag := range b.Tags {
+				if strings.EqualFold(tag, value) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	return func(b *common.BookRecord) bool {
+		return strings.Contains(searchText(b), lower)
+	}, nil
+}
+
+// isComparisonField returns true if values of the field can start with
+// a comparison operator
+func isComparisonField(name string) bool {
+	_, number := numberFields[name]
+	_, date := dateFields[name]
+	return number || date
+}
+
+// splitComparison splits a value into a comparison operator and an
+// argument. The operator is "=" if the value does not start with one
+func splitComparison(value string) (string, string) {
+	for _, op := range []string{"<=", ">=", "<", ">", "="} {
+		if strings.HasPrefix(value, op) {
+			return op, value[len(op):]
+		}
+	}
+	return "=", value
+}
+
+// compare checks the result of comparison (negative, zero, or positive)
+// with the operator
+func compare(diff int, op string) bool {
+	switch op {
+	case "<":
+		return diff < 0
+	case "<=":
+		return diff <= 0
+	case ">":
+		return diff > 0
+	case ">=":
+		return diff >= 0
+	}
+	return diff == 0
+}
+
+// Match returns true if the book matches all terms of the query
+func (q *Query) Match(b *common.BookRecord) bool {
+	for _, t := range q.terms {
+		if t.match(b) == t.negate {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"github.com/VladimirMarkelov/termfb2/common"
+	"reflect"
+	"testing"
+)
+
+func TestIsQuery(t *testing.T) {
+	tests := []struct {
+		filter string
+		query  bool
+	}{
+		{"strugatsky", false},
+		{"road side picnic", false},
+		{"author:strugatsky", true},
+		{"AUTHOR:strugatsky", true},
+		{"-tag:read", true},
+		{`"side picnic"`, true},
+		{"unknown:field", false},
+		{":value", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if q := IsQuery(test.filter); q != test.query {
+			t.Errorf("IsQuery(%q) = %v, want %v", test.filter, q, test.query)
+		}
+	}
+}
+
+func TestSplitQuery(t *testing.T) {
+	tests := []struct {
+		filter string
+		terms  []string
+		fails  bool
+	}{
+		{"", []string{}, false},
+		{"  a   b ", []string{"a", "b"}, false},
+		{`title:"side picnic" lang:ru`, []string{`title:"side picnic"`, "lang:ru"}, false},
+		{`"a  b"`, []string{`"a  b"`}, false},
+		{`title:"side picnic`, nil, true},
+	}
+	for _, test := range tests {
+		terms, err := splitQuery(test.filter)
+		if (err != nil) != test.fails {
+			t.Errorf("splitQuery(%q) error = %v, want failure %v", test.filter, err, test.fails)
+			continue
+		}
+		if !test.fails && !reflect.DeepEqual(terms, test.terms) {
+			t.Errorf("splitQuery(%q) = %q, want %q", test.filter, terms, test.terms)
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	type term struct {
+		negate bool
+		field  string
+		op     string
+		arg    string
+	}
+	tests := []struct {
+		filter string
+		terms  []term
+		fails  bool
+	}{
+		{"author:strugatsky", []term{{false, "author", "=", "strugatsky"}}, false},
+		{"Title:Picnic", []term{{false, "title", "=", "Picnic"}}, false},
+		{`title:"side picnic"`, []term{{false, "title", "=", "side picnic"}}, false},
+		{`"side picnic"`, []term{{false, "", "=", "side picnic"}}, false},
+		{"-tag:read", []term{{true, "tag", "=", "read"}}, false},
+		{"done:<50", []term{{false, "done", "<", "50"}}, false},
+		{"rating:>=4", []term{{false, "rating", ">=", "4"}}, false},
+		{"added:2024-01", []term{{false, "added", "=", "2024-01"}}, false},
+		{"completed:>2023", []term{{false, "completed", ">", "2023"}}, false},
+		{"lang:ru -", []term{{false, "lang", "=", "ru"}, {false, "", "=", "-"}}, false},
+		{`lang:ru ""`, []term{{false, "lang", "=", "ru"}}, false},
+		{"done:<=x", nil, true},
+		{"added:24-01", nil, true},
+		{"added:2024-1", nil, true},
+		{"author:", nil, true},
+		{`title:"picnic`, nil, true},
+	}
+	for _, test := range tests {
+		q, err := ParseQuery(test.filter)
+		if (err != nil) != test.fails {
+			t.Errorf("ParseQuery(%q) error = %v, want failure %v", test.filter, err, test.fails)
+			continue
+		}
+		if test.fails {
+			continue
+		}
+		terms := make([]term, 0, len(q.terms))
+		for _, qt := range q.terms {
+			terms = append(terms, term{qt.negate, qt.field, qt.op, qt.arg})
+		}
+		if !reflect.DeepEqual(terms, test.terms) {
+			t.Errorf("ParseQuery(%q) = %+v, want %+v", test.filter, terms, test.terms)
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	b := common.BookRecord{
+		FilePath:  "/books/picnic.fb2",
+		Id:        "abc123",
+		Added:     "2024-01-15T10:00:00Z",
+		Completed: "",
+		LineLast:  40,
+		LineTotal: 100,
+		Tags:      []string{"Sci-Fi", "to read"},
+		Rating:    4,
+		FirstName: "Arkady",
+		LastName:  "Strugatsky",
+		Title:     "Roadside Picnic",
+		Sequence:  "Noon Universe",
+		Language:  "en",
+		Genre:     "sf",
+	}
+	tests := []struct {
+		filter string
+		match  bool
+	}{
+		{"author:strugatsky", true},
+		{"author:boris", false},
+		{"title:PICNIC lang:en", true},
+		{"title:picnic lang:ru", false},
+		{`"roadside picnic"`, true},
+		{`"picnic roadside"`, false},
+		{"noon", true},
+		{"seq:noon", true},
+		{"path:picnic.fb2", true},
+		{"id:abc", true},
+		{"genre:sf", true},
+		{"tag:sci-fi", true},
+		{"tag:sci", false},
+		{"-tag:sci-fi", false},
+		{"-tag:read", true},
+		{"done:40", true},
+		{"done:<40", false},
+		{"done:<=40", true},
+		{"done:>39", true},
+		{"rating:>=4", true},
+		{"rating:5", false},
+		{"added:2024", true},
+		{"added:2024-01-15", true},
+		{"added:>2024-01", false},
+		{"added:>=2024-01", true},
+		{"added:<2024-02", true},
+		{"completed:2024", false},
+		{"-completed:2024", true},
+	}
+	for _, test := range tests {
+		q, err := ParseQuery(test.filter)
+		if err != nil {
+			t.Errorf("ParseQuery(%q) failed: %v", test.filter, err)
+			continue
+		}
+		if m := q.Match(&b); m != test.match {
+			t.Errorf("query %q matches = %v, want %v", test.filter, m, test.match)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"fmt"
+	"github.com/VladimirMarkelov/termfb2/common"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// percentExpr is the reading progress of a book in percents, the same as
+// BookRecord.Percent. The library has an index on it to sort books by
+// progress
+const percentExpr = "CASE WHEN para_total > 0 THEN MIN(para_last * 100 / para_total, 100) " +
+	"WHEN line_total = 0 THEN 0 ELSE line_last * 100 / line_total END"
+
+// linePercentExpr is the reading progress before the paragraph count was
+// stored. Only old migrations use it
+const linePercentExpr = "CASE WHEN line_total = 0 THEN 0 ELSE line_last * 100 / line_total END"
+
+// searchColumns are columns of the full-text search table books_fts. They
+// keep lowercase text of book fields because SQLite changes case only of
+// ASCII letters
+const searchColumns = "author, title, sequence, genre, language, file_path, tags, id"
+
+// minMatchLength is the shortest text the trigram search index can find.
+// Shorter texts are looked for with LIKE that reads the whole search table
+const minMatchLength = 3
+
+// searchFields are search table columns of query text fields
+var searchFields = map[string]string{
+	"author":   "author",
+	"title":    "title",
+	"seq":      "sequence",
+	"sequence": "sequence",
+	"genre":    "genre",
+	"lang":     "language",
+	"path":     "file_path",
+	"id":       "id",
+}
+
+// plainColumns are search table columns a plain text filter is looked for
+// in. They keep plainFields of books
+var plainColumns = []string{"author", "title", "sequence", "file_path", "tags"}
+
+// sqlColumns are book columns and expressions of query number and date fields
+var sqlColumns = map[string]string{
+	"done":      percentExpr,
+	"rating":    "rating",
+	"added":     "added",
+	"completed": "completed",
+}
+
+// searchValues returns values of the search table columns for the book.
+// Tags are kept as tagsText, so a whole tag can be found with LIKE
+func searchValues(b *common.BookRecord) []interface{} {
+	fields := []string{bookAuthor(b), b.Title, b.Sequence, b.Genre,
+		b.Language, b.FilePath, tagsText(b.Tags), b.Id}
+	values := make([]interface{}, len(fields))
+	for i, f := range fields {
+		values[i] = strings.ToLower(f)
+	}
+	return values
+}
+
+// escapeLike escapes special characters of LIKE patterns
+func escapeLike(text string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(text)
+}
+
+// likePattern escapes the filter to use it in LIKE expression
+func likePattern(filter string) string {
+	return "%" + escapeLike(strings.ToLower(filter)) + "%"
+}
+
+// matchPhrase makes an FTS5 query that looks for the whole text in any of
+// the columns
+func matchPhrase(columns []string, text string) string {
+	return "{" + strings.Join(columns, " ") + `} : "` + strings.Replace(text, `"`, `""`, -1) + `"`
+}
+
+// searchCondition returns the condition of books that contain the text in
+// any of the search table columns
+func searchCondition(columns []string, text string) (string, []interface{}) {
+	text = strings.ToLower(text)
+	if utf8.RuneCountInString(text) >= minMatchLength {
+		return "search_id IN (SELECT rowid FROM books_fts WHERE books_fts MATCH ?)",
+			[]interface{}{matchPhrase(columns, text)}
+	}
+
+	likes := make([]string, len(columns))
+	args := make([]interface{}, len(columns))
+	for i, c := range columns {
+		likes[i] = c + ` LIKE ? ESCAPE '\'`
+		args[i] = likePattern(text)
+	}
+	return "search_id IN (SELECT rowid FROM books_fts WHERE " + strings.Join(likes, " OR ") + ")", args
+}
+
+// tagCondition returns the condition of books that have the tag ignoring
+// case. The search index finds books which tags contain the text, and LIKE
+// keeps only books with the whole tag
+func tagCondition(tag string) (string, []interface{}) {
+	tag = strings.ToLower(tag)
+	cond := `tags LIKE ? ESCAPE '\'`
+	args := []interface{}{"%\n" + escapeLike(tag) + "\n%"}
+	if utf8.RuneCountInString(tag) >= minMatchLength {
+		cond = "books_fts MATCH ? AND " + cond
+		args = append([]interface{}{matchPhrase([]string{"tags"}, tag)}, args...)
+	}
+	return "search_id IN (SELECT rowid FROM books_fts WHERE " + cond + ")", args
+}
+
+// dateCondition compares the date column with the precision of the
+// argument. Dates are RFC3339 strings, so all dates that start with the
+// argument are not less than the argument and less than the argument
+// followed by '~'. Empty dates do not match any comparison
+func dateCondition(column, op, arg string) (string, []interface{}) {
+	after := arg + "~"
+	switch op {
+	case "<":
+		return fmt.Sprintf("(%s <> '' AND %s < ?)", column, column), []interface{}{arg}
+	case "<=":
+		return fmt.Sprintf("(%s <> '' AND %s < ?)", column, column), []interface{}{after}
+	case ">":
+		return column + " >= ?", []interface{}{after}
+	case ">=":
+		return column + " >= ?", []interface{}{arg}
+	}
+	return fmt.Sprintf("(%s >= ? AND %s < ?)", column, column), []interface{}{arg, after}
+}
+
+// termCondition translates a query term to SQL condition of books table
+func termCondition(t queryTerm) (string, []interface{}) {
+	var cond string
+	var args []interface{}
+	if column, ok := searchFields[t.field]; ok {
+		cond, args = searchCondition([]string{column}, t.arg)
+	} else if _, ok := numberFields[t.field]; ok {
+		// the argument has been checked when the query was parsed
+		n, _ := strconv.Atoi(t.arg)
+		cond, args = "("+sqlColumns[t.field]+") "+t.op+" ?", []interface{}{n}
+	} else if _, ok := dateFields[t.field]; ok {
+		cond, args = dateCondition(sqlColumns[t.field], t.op, t.arg)
+	} else if t.field == tagField {
+		cond, args = tagCondition(t.arg)
+	} else {
+		cond, args = searchCondition(plainColumns, t.arg)
+	}
+
+	if t.negate {
+		cond = "NOT (" + cond + ")"
+	}
+	return cond, args
+}
+
+// queryCondition returns WHERE clause of books that match all terms of
+// the query
+func queryCondition(q *Query) (string, []interface{}) {
+	conds := make([]string, 0, len(q.terms))
+	args := make([]interface{}, 0)
+	for _, t := range q.terms {
+		cond, a := termCondition(t)
+		conds = append(conds, cond)
+		args = append(args, a...)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
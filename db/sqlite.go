@@ -0,0 +1,1067 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/VladimirMarkelov/termfb2/common"
+	scribble "github.com/nanobox-io/golang-scribble"
+	"github.com/nu7hatch/gouuid"
+	"io/ioutil"
+	"os"
+	path "path/filepath"
+	"strings"
+	"time"
+
+	// pure Go SQLite driver, registers itself as "sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// SqliteDb keeps the library in a single SQLite database. Filtering and
+// sorting are done by the database using the full-text search table and
+// indices, the results are cached until the library changes
+type SqliteDb struct {
+	db *sql.DB
+
+	bookList     []common.BookRecord
+	bookFiltered []common.BookRecord
+	// true if the cached lists must be reread from the database. A flag is
+	// cleared only after the list is read successfully
+	listDirty     bool
+	filteredDirty bool
+	// errors of the last reading of the cached lists
+	listErr     error
+	filteredErr error
+
+	filter      string
+	sortMode    string
+	sortAsc     bool
+	missingOnly bool
+	// the parsed filter, nil if the filter is plain text. Queries are
+	// translated to SQL
+	query     *Query
+	filterErr error
+	// plain text filter mode. Fuzzy filters cannot use the search index,
+	// so they are checked in Go after reading all books
+	filterMode string
+	// books of the scribble library that have not been imported. The
+	// error is returned by the first ReadDatabase
+	importErr error
+}
+
+// a migration changes the database schema to the next version
+type migration func(tx *sql.Tx) error
+
+// all book columns in the order they are read by scanBook
+const bookColumns = "id, file_path, hash, doc_id, added, completed, " +
+	"line_last, line_total, para_last, offset_last, pos_version, " +
+	"first_name, last_name, title, sequence, language, genre, tags, rating, seq_number, para_total"
+
+// searchIndexColumns are book columns read by addSearchIndex migration.
+// para_total is added by a later migration, so it is read as 0
+const searchIndexColumns = "id, file_path, hash, doc_id, added, completed, " +
+	"line_last, line_total, para_last, offset_last, pos_version, " +
+	"first_name, last_name, title, sequence, language, genre, tags, rating, seq_number, 0"
+
+// InitSqliteDb opens the library database and updates its schema to
+// the latest version. A new database gets all books from the scribble
+// library if it exists. Call ReadDatabase to load books
+func InitSqliteDb(dbPath string) (*SqliteDb, error) {
+	db := new(SqliteDb)
+	db.sortMode = common.FIELD_AUTHOR
+	db.sortAsc = true
+
+	if err := os.MkdirAll(dbPath, 0755); err != nil {
+		return nil, err
+	}
+	var err error
+	db.db, err = sql.Open("sqlite", path.Join(dbPath, common.SQLITEFILE))
+	if err != nil {
+		return nil, err
+	}
+	// SQLite does not allow concurrent writes
+	db.db.SetMaxOpenConns(1)
+
+	migrations := []migration{
+		createSchema,
+		markImport,
+		func(tx *sql.Tx) error {
+			return createSessions(tx, dbPath)
+		},
+		addTagsAndRating,
+		addSequenceNumber,
+		fillSequenceNumbers,
+		addSearchIndex,
+		addMergedSessions,
+		addSequenceIndex,
+		addParaTotal,
+	}
+	err = db.migrate(migrations)
+	if err == nil {
+		// the scribble library is imported after all migrations, so books
+		// are inserted with all fields of the latest schema
+		var pending bool
+		if pending, err = db.importPending(); err == nil && pending {
+			err = db.importScribble(dbPath)
+		}
+	}
+	if err != nil {
+		db.db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// migrate applies all migrations that have not been applied yet. Every
+// migration runs in its own transaction together with the version update
+func (db *SqliteDb) migrate(migrations []migration) error {
+	if _, err := db.db.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)"); err != nil {
+		return err
+	}
+	version := 0
+	err := db.db.QueryRow("SELECT version FROM schema_version").Scan(&version)
+	if err == sql.ErrNoRows {
+		if _, err = db.db.Exec("INSERT INTO schema_version (version) VALUES (0)"); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.db.Begin()
+		if err != nil {
+			return err
+		}
+		if err = migrations[version](tx); err == nil {
+			_, err = tx.Exec("UPDATE schema_version SET version = ?", version+1)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("database migration %d failed: %v", version+1, err)
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createSchema(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE books (
+			id TEXT PRIMARY KEY,
+			file_path TEXT NOT NULL UNIQUE,
+			hash TEXT NOT NULL DEFAULT '',
+			doc_id TEXT NOT NULL DEFAULT '',
+			added TEXT NOT NULL DEFAULT '',
+			completed TEXT NOT NULL DEFAULT '',
+			line_last INTEGER NOT NULL DEFAULT 0,
+			line_total INTEGER NOT NULL DEFAULT 0,
+			para_last INTEGER NOT NULL DEFAULT 0,
+			offset_last INTEGER NOT NULL DEFAULT 0,
+			pos_version INTEGER NOT NULL DEFAULT 0,
+			first_name TEXT NOT NULL DEFAULT '',
+			last_name TEXT NOT NULL DEFAULT '',
+			title TEXT NOT NULL DEFAULT '',
+			sequence TEXT NOT NULL DEFAULT '',
+			language TEXT NOT NULL DEFAULT '',
+			genre TEXT NOT NULL DEFAULT '',
+			search_text TEXT NOT NULL DEFAULT ''
+		)`,
+		"CREATE INDEX books_hash ON books (hash)",
+		"CREATE INDEX books_doc_id ON books (doc_id)",
+		"CREATE INDEX books_author ON books (last_name, first_name, title, sequence)",
+		"CREATE INDEX books_title ON books (title)",
+		"CREATE INDEX books_genre ON books (genre)",
+		"CREATE INDEX books_added ON books (added)",
+		"CREATE INDEX books_completed ON books (completed)",
+		`CREATE TABLE bookmarks (
+			book_id TEXT NOT NULL,
+			name TEXT NOT NULL DEFAULT '',
+			para INTEGER NOT NULL DEFAULT 0,
+			para_offset INTEGER NOT NULL DEFAULT 0,
+			added TEXT NOT NULL DEFAULT ''
+		)`,
+		"CREATE INDEX bookmarks_book ON bookmarks (book_id, para, para_offset)",
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markImport creates the marker table of a new database that must get
+// books from the scribble library. The marker is dropped in the same
+// transaction that imports the books, so a failed import is retried the
+// next time the database is opened
+func markImport(tx *sql.Tx) error {
+	_, err := tx.Exec("CREATE TABLE scribble_import (pending INTEGER NOT NULL DEFAULT 1)")
+	return err
+}
+
+// importPending returns true if the scribble library has not been
+// imported yet
+func (db *SqliteDb) importPending() (bool, error) {
+	var count int
+	err := db.db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'scribble_import'").Scan(&count)
+	return count != 0, err
+}
+
+// importScribble copies all books from the scribble library to the new
+// database. Records that cannot be read are moved to the quarantine
+// directory of the scribble library, and books that cannot be inserted are
+// skipped. Both are reported by ReadDatabase, other books are imported
+func (db *SqliteDb) importScribble(dbPath string) error {
+	dir := path.Join(dbPath, common.DBFILE)
+	collection := path.Join(dir, common.DBCOLLECTION)
+	files, err := ioutil.ReadDir(collection)
+	if os.IsNotExist(err) {
+		// no scribble library - nothing to import
+		_, err = db.db.Exec("DROP TABLE scribble_import")
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	damaged := make([]string, 0)
+	failed := make([]string, 0)
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		b := common.BookRecord{}
+		data, err := ioutil.ReadFile(path.Join(collection, f.Name()))
+		if err == nil {
+			err = json.Unmarshal(data, &b)
+		}
+		if err != nil {
+			damaged = append(damaged, f.Name())
+			continue
+		}
+		fillSequenceNumber(&b)
+
+		// a book is inserted into a few tables, so a failed book is
+		// rolled back to the savepoint to not leave parts of it
+		if _, err := tx.Exec("SAVEPOINT import_book"); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := insertBook(tx, &b); err != nil {
+			failed = append(failed, fmt.Sprintf("'%s': %v", b.FilePath, err))
+			_, err = tx.Exec("ROLLBACK TO import_book")
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if _, err := tx.Exec("RELEASE import_book"); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.Exec("DROP TABLE scribble_import"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if len(failed) != 0 {
+		db.importErr = fmt.Errorf("%d books of the scribble library have not been imported: %s",
+			len(failed), strings.Join(failed, "; "))
+	}
+	if len(damaged) != 0 {
+		qerr := quarantineRecords(dir, damaged)
+		if db.importErr != nil {
+			qerr = fmt.Errorf("%v; %v", db.importErr, qerr)
+		}
+		db.importErr = qerr
+	}
+	return nil
+}
+
+// createSessions adds the table of reading sessions. Sessions of books
+// imported from the scribble library are copied to it
+func createSessions(tx *sql.Tx, dbPath string) error {
+	stmts := []string{
+		`CREATE TABLE sessions (
+			book_id TEXT NOT NULL,
+			start_time TEXT NOT NULL,
+			end_time TEXT NOT NULL DEFAULT '',
+			start_para INTEGER NOT NULL DEFAULT 0,
+			start_offset INTEGER NOT NULL DEFAULT 0,
+			end_para INTEGER NOT NULL DEFAULT 0,
+			end_offset INTEGER NOT NULL DEFAULT 0,
+			words INTEGER NOT NULL DEFAULT 0,
+			UNIQUE (book_id, start_time)
+		)`,
+		"CREATE INDEX sessions_start ON sessions (start_time)",
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	driver, err := scribble.New(path.Join(dbPath, common.DBFILE), nil)
+	if err != nil {
+		return nil
+	}
+	records, err := driver.ReadAll(common.DBCOLLECTION)
+	if err != nil {
+		return nil
+	}
+	for _, r := range records {
+		b := common.BookRecord{}
+		if err := json.Unmarshal([]byte(r), &b); err != nil {
+			continue
+		}
+		for _, session := range b.Sessions {
+			// books that have not been imported are skipped
+			tx.Exec("INSERT OR IGNORE INTO sessions (book_id, start_time, end_time, start_para, start_offset, "+
+				"end_para, end_offset, words) SELECT id, ?, ?, ?, ?, ?, ?, ? FROM books WHERE id = ?",
+				session.Start, session.End, session.StartPara, session.StartOffset,
+				session.EndPara, session.EndOffset, session.Words, b.Id)
+		}
+	}
+	return nil
+}
+
+// addTagsAndRating adds book tags and rating. Tags are kept as a comma
+// separated list
+func addTagsAndRating(tx *sql.Tx) error {
+	stmts := []string{
+		"ALTER TABLE books ADD COLUMN tags TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE books ADD COLUMN rating INTEGER NOT NULL DEFAULT 0",
+		"CREATE INDEX books_rating ON books (rating)",
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addSequenceNumber adds the number of the book in its sequence
+func addSequenceNumber(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE books ADD COLUMN seq_number INTEGER NOT NULL DEFAULT 0")
+	return err
+}
+
+// fillSequenceNumbers reads sequence numbers of books added before the
+// numbers were stored from their files. Missing and unreadable files are
+// skipped
+func fillSequenceNumbers(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, file_path, sequence FROM books WHERE sequence <> '' AND seq_number = 0")
+	if err != nil {
+		return err
+	}
+	books := make([]common.BookRecord, 0)
+	for rows.Next() {
+		b := common.BookRecord{}
+		if err := rows.Scan(&b.Id, &b.FilePath, &b.Sequence); err != nil {
+			rows.Close()
+			return err
+		}
+		books = append(books, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, b := range books {
+		if !fillSequenceNumber(&b) {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE books SET seq_number = ? WHERE id = ?", b.SeqNumber, b.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addSearchIndex adds the full-text search table that replaces search_text
+// column, and indices to sort books by tags and reading progress. Every
+// book is linked to its row of the search table with search_id. The
+// trigram tokenizer finds any text of at least three characters
+func addSearchIndex(tx *sql.Tx) error {
+	stmts := []string{
+		"ALTER TABLE books ADD COLUMN search_id INTEGER NOT NULL DEFAULT 0",
+		"CREATE VIRTUAL TABLE books_fts USING fts5(" + searchColumns + ", tokenize = 'trigram')",
+		"CREATE INDEX books_search_id ON books (search_id)",
+		"CREATE INDEX books_tags ON books (tags)",
+		"CREATE INDEX books_percent ON books (" + linePercentExpr + ")",
+		"UPDATE books SET search_text = ''",
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.Query("SELECT " + searchIndexColumns + " FROM books")
+	if err != nil {
+		return err
+	}
+	books := make([]common.BookRecord, 0)
+	for rows.Next() {
+		b, err := scanBook(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		books = append(books, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, b := range books {
+		if err := insertSearchRow(tx, &b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addMergedSessions adds the number of sessions merged into a session
+func addMergedSessions(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE sessions ADD COLUMN merged INTEGER NOT NULL DEFAULT 0")
+	return err
+}
+
+// addSequenceIndex adds the index to sort books by sequences
+func addSequenceIndex(tx *sql.Tx) error {
+	_, err := tx.Exec("CREATE INDEX books_sequence ON books (sequence, seq_number)")
+	return err
+}
+
+// addParaTotal adds the number of paragraphs of the main text to show the
+// reading progress that does not depend on the reader width. Existing
+// books get it when their position is saved next time
+func addParaTotal(tx *sql.Tx) error {
+	stmts := []string{
+		"ALTER TABLE books ADD COLUMN para_total INTEGER NOT NULL DEFAULT 0",
+		"DROP INDEX books_percent",
+		"CREATE INDEX books_percent ON books (" + percentExpr + ")",
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execer is a part of sql.DB and sql.Tx interfaces used to write books
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertSearchRow adds the book text to the search table and links the
+// book to the new row
+func insertSearchRow(ex execer, b *common.BookRecord) error {
+	res, err := ex.Exec("INSERT INTO books_fts ("+searchColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		searchValues(b)...)
+	if err != nil {
+		return err
+	}
+	rowid, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	_, err = ex.Exec("UPDATE books SET search_id = ? WHERE id = ?", rowid, b.Id)
+	return err
+}
+
+func insertBook(ex execer, b *common.BookRecord) error {
+	_, err := ex.Exec("INSERT INTO books ("+bookColumns+") "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		b.Id, b.FilePath, b.Hash, b.DocId, b.Added, b.Completed,
+		b.LineLast, b.LineTotal, b.ParaLast, b.OffsetLast, b.PosVersion,
+		b.FirstName, b.LastName, b.Title, b.Sequence, b.Language, b.Genre,
+		common.JoinTags(b.Tags), b.Rating, b.SeqNumber, b.ParaTotal)
+	if err != nil {
+		return err
+	}
+	if err := insertSearchRow(ex, b); err != nil {
+		return err
+	}
+	for _, bm := range b.Bookmarks {
+		if err := insertBookmark(ex, b.Id, bm); err != nil {
+			return err
+		}
+	}
+	for _, s := range b.Sessions {
+		if err := insertSession(ex, b.Id, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func updateBook(ex execer, b *common.BookRecord) error {
+	_, err := ex.Exec("UPDATE books SET file_path = ?, hash = ?, doc_id = ?, added = ?, completed = ?, "+
+		"line_last = ?, line_total = ?, para_last = ?, offset_last = ?, pos_version = ?, "+
+		"first_name = ?, last_name = ?, title = ?, sequence = ?, language = ?, genre = ?, "+
+		"tags = ?, rating = ?, seq_number = ?, para_total = ? WHERE id = ?",
+		b.FilePath, b.Hash, b.DocId, b.Added, b.Completed,
+		b.LineLast, b.LineTotal, b.ParaLast, b.OffsetLast, b.PosVersion,
+		b.FirstName, b.LastName, b.Title, b.Sequence, b.Language, b.Genre,
+		common.JoinTags(b.Tags), b.Rating, b.SeqNumber, b.ParaTotal, b.Id)
+	if err != nil {
+		return err
+	}
+	args := append(searchValues(b), b.Id)
+	_, err = ex.Exec("UPDATE books_fts SET author = ?, title = ?, sequence = ?, genre = ?, language = ?, "+
+		"file_path = ?, tags = ?, id = ? WHERE rowid = (SELECT search_id FROM books WHERE id = ?)", args...)
+	return err
+}
+
+func insertBookmark(ex execer, bookId string, bm common.Bookmark) error {
+	_, err := ex.Exec("INSERT INTO bookmarks (book_id, name, para, para_offset, added) VALUES (?, ?, ?, ?, ?)",
+		bookId, bm.Name, bm.Para, bm.Offset, bm.Added)
+	return err
+}
+
+// insertSession adds the session or replaces the session of the book
+// that has the same start time
+func insertSession(ex execer, bookId string, s common.Session) error {
+	_, err := ex.Exec("INSERT OR REPLACE INTO sessions (book_id, start_time, end_time, start_para, start_offset, "+
+		"end_para, end_offset, words, merged) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		bookId, s.Start, s.End, s.StartPara, s.StartOffset, s.EndPara, s.EndOffset, s.Words, s.Merged)
+	return err
+}
+
+// scanner is a part of sql.Row and sql.Rows interfaces
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanBook(s scanner) (common.BookRecord, error) {
+	var b common.BookRecord
+	var tags string
+	err := s.Scan(&b.Id, &b.FilePath, &b.Hash, &b.DocId, &b.Added, &b.Completed,
+		&b.LineLast, &b.LineTotal, &b.ParaLast, &b.OffsetLast, &b.PosVersion,
+		&b.FirstName, &b.LastName, &b.Title, &b.Sequence, &b.Language, &b.Genre,
+		&tags, &b.Rating, &b.SeqNumber, &b.ParaTotal)
+	b.Tags = common.ParseTags(tags)
+	return b, err
+}
+
+func (db *SqliteDb) queryBooks(query string, args ...interface{}) ([]common.BookRecord, error) {
+	list := make([]common.BookRecord, 0)
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		b, err := scanBook(rows)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, b)
+	}
+	return list, rows.Err()
+}
+
+// bookBy returns the first book that has the column equal to the value
+func (db *SqliteDb) bookBy(column, value string) (common.BookRecord, bool) {
+	b, found, _ := db.findBook(column, value)
+	return b, found
+}
+
+// findBook returns the first book with the column value. Unlike bookBy,
+// it returns an error if the library cannot be read
+func (db *SqliteDb) findBook(column, value string) (common.BookRecord, bool, error) {
+	row := db.db.QueryRow("SELECT "+bookColumns+" FROM books WHERE "+column+" = ? LIMIT 1", value)
+	b, err := scanBook(row)
+	if err == sql.ErrNoRows {
+		return b, false, nil
+	}
+	return b, err == nil, err
+}
+
+// orderBy generates ORDER BY clause for the current sort mode. Books with
+// equal values are sorted by author, title and sequence in the same order
+func (db *SqliteDb) orderBy() string {
+	dir := " ASC"
+	// books with the same fuzzy score are sorted by author
+	if !db.sortAsc && db.sortMode != common.FIELD_SCORE {
+		dir = " DESC"
+	}
+	keys := []string{"last_name", "first_name", "title", "sequence"}
+
+	switch db.sortMode {
+	case common.FIELD_TITLE:
+		keys = append([]string{"title"}, keys...)
+	case common.FIELD_GENRE:
+		keys = append([]string{"genre"}, keys...)
+	case common.FIELD_ADDED:
+		keys = append([]string{"added"}, keys...)
+	case common.FIELD_COMPLETED:
+		keys = append([]string{"completed"}, keys...)
+	case common.FIELD_RATING:
+		keys = append([]string{"rating"}, keys...)
+	case common.FIELD_TAGS:
+		keys = append([]string{"tags"}, keys...)
+	case common.FIELD_SEQUENCE:
+		keys = append([]string{"sequence", "seq_number"}, keys...)
+	case common.FIELD_PERCENT:
+		keys = append([]string{percentExpr}, keys...)
+	}
+
+	return " ORDER BY " + strings.Join(keys, dir+", ") + dir
+}
+
+// refresh rereads the filtered book list if the library or the filter has
+// changed. The list is not changed if reading fails
+func (db *SqliteDb) refresh() error {
+	if !db.filteredDirty {
+		return nil
+	}
+
+	books := make([]common.BookRecord, 0)
+	fuzzy := db.filterMode == common.FILTER_FUZZY && db.query == nil && db.filter != ""
+	if db.filterErr == nil {
+		where, args := "", []interface{}(nil)
+		if db.query != nil {
+			where, args = queryCondition(db.query)
+		} else if db.filter != "" && !fuzzy {
+			cond, a := searchCondition(plainColumns, db.filter)
+			where, args = " WHERE "+cond, a
+		}
+		var err error
+		books, err = db.queryBooks("SELECT "+bookColumns+" FROM books"+where+db.orderBy(), args...)
+		if err != nil {
+			db.filteredErr = err
+			return err
+		}
+	}
+
+	filtered := make([]common.BookRecord, 0, len(books))
+	for _, b := range books {
+		if !db.missingOnly || !fileExists(b.FilePath) {
+			filtered = append(filtered, b)
+		}
+	}
+	if fuzzy {
+		var scores map[string]int
+		filtered, scores = fuzzyFilter(filtered, db.filter)
+		if db.sortMode == common.FIELD_SCORE {
+			sortByScore(filtered, scores, db.sortAsc)
+		}
+	}
+	db.bookFiltered = filtered
+	db.filteredDirty = false
+	db.filteredErr = nil
+	return nil
+}
+
+// changed marks both cached book lists to be reread after the library
+// changes
+func (db *SqliteDb) changed() {
+	db.listDirty = true
+	db.filteredDirty = true
+}
+
+func (db *SqliteDb) ReadDatabase() error {
+	db.changed()
+	if err := db.refresh(); err != nil {
+		return err
+	}
+	// import errors are reported once
+	err := db.importErr
+	db.importErr = nil
+	return err
+}
+
+func (db *SqliteDb) SetFilter(filter string) {
+	if filter == db.filter {
+		return
+	}
+	db.filter = filter
+	db.query, db.filterErr = parseFilter(filter)
+	db.filteredDirty = true
+}
+
+func (db *SqliteDb) Filter() string {
+	return db.filter
+}
+
+func (db *SqliteDb) FilterError() error {
+	return db.filterErr
+}
+
+func (db *SqliteDb) SetFilterMode(mode string) {
+	if mode == db.filterMode {
+		return
+	}
+	db.filterMode = mode
+	db.filteredDirty = true
+}
+
+func (db *SqliteDb) FilteredBooks() []common.BookRecord {
+	db.refresh()
+	return db.bookFiltered
+}
+
+func (db *SqliteDb) DeleteBookByIndex(index int) error {
+	db.refresh()
+	if index < 0 || index >= len(db.bookFiltered) {
+		return nil
+	}
+
+	book := db.bookFiltered[index]
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"bookmarks", "sessions"} {
+		if _, err = tx.Exec("DELETE FROM "+table+" WHERE book_id = ?", book.Id); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM books_fts WHERE rowid = (SELECT search_id FROM books WHERE id = ?)", book.Id)
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM books WHERE id = ?", book.Id)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	db.changed()
+	return tx.Commit()
+}
+
+// BookList returns all books. It is read only when it is needed because
+// filtering does not use it
+func (db *SqliteDb) BookList() []common.BookRecord {
+	if !db.listDirty {
+		return db.bookList
+	}
+	books, err := db.queryBooks("SELECT " + bookColumns + " FROM books")
+	db.listErr = err
+	if err == nil {
+		db.bookList = books
+		db.listDirty = false
+	}
+	return db.bookList
+}
+
+func (db *SqliteDb) ListError() error {
+	if db.filteredErr != nil {
+		return db.filteredErr
+	}
+	return db.listErr
+}
+
+func (db *SqliteDb) UpdateBookInDb(bookPath string, pos common.Position, bookInfo *common.BookRecord) error {
+	book, found, err := db.findBook("file_path", bookPath)
+	if err != nil {
+		return err
+	}
+	if !found {
+		newBook := *bookInfo
+		newBook.FilePath = bookPath
+		newBook.LineLast = pos.Line
+		newBook.LineTotal = pos.Total
+		newBook.ParaLast = pos.Para
+		newBook.OffsetLast = pos.Offset
+		newBook.ParaTotal = pos.ParaTotal
+		newBook.PosVersion = common.POS_VERSION
+		return db.AddBook(&newBook)
+	}
+
+	if book.LineLast == pos.Line && book.LineTotal == pos.Total &&
+		book.ParaLast == pos.Para && book.OffsetLast == pos.Offset &&
+		book.ParaTotal == pos.ParaTotal && book.PosVersion == common.POS_VERSION &&
+		(book.Hash != "" || bookInfo.Hash == "") &&
+		(book.DocId != "" || bookInfo.DocId == "") &&
+		(book.SeqNumber != 0 || bookInfo.SeqNumber == 0) {
+		return nil
+	}
+
+	book.LineLast = pos.Line
+	book.LineTotal = pos.Total
+	book.ParaLast = pos.Para
+	book.OffsetLast = pos.Offset
+	book.ParaTotal = pos.ParaTotal
+	book.PosVersion = common.POS_VERSION
+	// books added by old versions do not have hash and id
+	if book.Hash == "" {
+		book.Hash = bookInfo.Hash
+	}
+	if book.DocId == "" {
+		book.DocId = bookInfo.DocId
+	}
+	// sequence numbers are read since the library tree was added
+	if book.SeqNumber == 0 {
+		book.SeqNumber = bookInfo.SeqNumber
+	}
+	if pos.Line+1 == pos.Total && book.Completed == "" {
+		t := time.Now()
+		book.Completed = t.Format(time.RFC3339)
+	}
+	return db.saveBook(&book)
+}
+
+// saveBook writes all book fields except bookmarks and sessions to the database
+func (db *SqliteDb) saveBook(book *common.BookRecord) error {
+	if err := updateBook(db.db, book); err != nil {
+		return err
+	}
+	db.changed()
+	return nil
+}
+
+// AddBook adds a new book to the library. The book gets a new id and
+// the current time as the date it is added
+func (db *SqliteDb) AddBook(bookInfo *common.BookRecord) error {
+	book := *bookInfo
+	uid, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+	book.Id = uid.String()
+	t := time.Now()
+	book.Added = t.Format(time.RFC3339)
+	if err := insertBook(db.db, &book); err != nil {
+		return err
+	}
+	db.changed()
+	return nil
+}
+
+func (db *SqliteDb) BookByHash(hash string) (common.BookRecord, bool) {
+	if hash == "" {
+		return common.BookRecord{}, false
+	}
+	return db.bookBy("hash", hash)
+}
+
+func (db *SqliteDb) BooksByContent(hash, docId string) []common.BookRecord {
+	if hash == "" && docId == "" {
+		return []common.BookRecord{}
+	}
+	// both columns are indexed, and empty values never match
+	books, _ := db.queryBooks("SELECT "+bookColumns+" FROM books WHERE hash = ? OR doc_id = ?",
+		nonEmpty(hash), nonEmpty(docId))
+	return books
+}
+
+// nonEmpty returns the value for comparisons that must not match empty
+// columns: NULL is not equal to anything
+func nonEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// RelinkBook changes the path of a book file, e.g, after the file has been
+// moved to another directory. hash is the content hash of the new file
+func (db *SqliteDb) RelinkBook(oldPath, newPath, hash string) error {
+	book, found, err := db.findBook("file_path", oldPath)
+	if !found {
+		return err
+	}
+
+	book.FilePath = newPath
+	if hash != "" {
+		book.Hash = hash
+	}
+	return db.saveBook(&book)
+}
+
+func (db *SqliteDb) SetMissingOnly(missing bool) {
+	if missing == db.missingOnly {
+		return
+	}
+	db.missingOnly = missing
+	db.filteredDirty = true
+}
+
+func (db *SqliteDb) MissingOnly() bool {
+	return db.missingOnly
+}
+
+func (db *SqliteDb) SetSortMode(field string, asc bool) {
+	if field != db.sortMode || asc != db.sortAsc {
+		db.sortMode = field
+		db.sortAsc = asc
+		db.filteredDirty = true
+	}
+}
+
+func (db *SqliteDb) BookByFilePath(filePath string) (common.BookRecord, bool) {
+	book, found := db.bookBy("file_path", filePath)
+	if found {
+		book.Bookmarks = db.Bookmarks(filePath)
+	}
+	return book, found
+}
+
+// bookmarkRows returns bookmarks of the book and their row ids in the
+// order of their position in the book
+func (db *SqliteDb) bookmarkRows(bookPath string) ([]common.Bookmark, []int64) {
+	rows, err := db.db.Query("SELECT bm.rowid, bm.name, bm.para, bm.para_offset, bm.added "+
+		"FROM bookmarks bm JOIN books b ON b.id = bm.book_id WHERE b.file_path = ? "+
+		"ORDER BY bm.para, bm.para_offset, bm.rowid", bookPath)
+	if err != nil {
+		return nil, nil
+	}
+	defer rows.Close()
+
+	var marks []common.Bookmark
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var bm common.Bookmark
+		if err := rows.Scan(&id, &bm.Name, &bm.Para, &bm.Offset, &bm.Added); err != nil {
+			continue
+		}
+		marks = append(marks, bm)
+		ids = append(ids, id)
+	}
+	return marks, ids
+}
+
+func (db *SqliteDb) Bookmarks(bookPath string) []common.Bookmark {
+	marks, _ := db.bookmarkRows(bookPath)
+	return marks
+}
+
+func (db *SqliteDb) AddBookmark(bookPath string, bookmark common.Bookmark) error {
+	book, found := db.bookBy("file_path", bookPath)
+	if !found {
+		return fmt.Errorf("book '%s' is not in the library", bookPath)
+	}
+
+	if bookmark.Added == "" {
+		t := time.Now()
+		bookmark.Added = t.Format(time.RFC3339)
+	}
+	return insertBookmark(db.db, book.Id, bookmark)
+}
+
+func (db *SqliteDb) RenameBookmark(bookPath string, index int, name string) error {
+	_, ids := db.bookmarkRows(bookPath)
+	if index < 0 || index >= len(ids) {
+		return nil
+	}
+	_, err := db.db.Exec("UPDATE bookmarks SET name = ? WHERE rowid = ?", name, ids[index])
+	return err
+}
+
+func (db *SqliteDb) DeleteBookmark(bookPath string, index int) error {
+	_, ids := db.bookmarkRows(bookPath)
+	if index < 0 || index >= len(ids) {
+		return nil
+	}
+	_, err := db.db.Exec("DELETE FROM bookmarks WHERE rowid = ?", ids[index])
+	return err
+}
+
+func (db *SqliteDb) Sessions(bookPath string) []common.Session {
+	rows, err := db.db.Query("SELECT s.start_time, s.end_time, s.start_para, s.start_offset, "+
+		"s.end_para, s.end_offset, s.words, s.merged FROM sessions s JOIN books b ON b.id = s.book_id "+
+		"WHERE b.file_path = ? ORDER BY s.start_time", bookPath)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var sessions []common.Session
+	for rows.Next() {
+		var s common.Session
+		if err := rows.Scan(&s.Start, &s.End, &s.StartPara, &s.StartOffset,
+			&s.EndPara, &s.EndOffset, &s.Words, &s.Merged); err != nil {
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// SaveSession adds a reading session to the book or replaces the session
+// that has the same start time. Old sessions are merged when the book has
+// too many of them
+func (db *SqliteDb) SaveSession(bookPath string, session common.Session) error {
+	book, found := db.bookBy("file_path", bookPath)
+	if !found {
+		return fmt.Errorf("book '%s' is not in the library", bookPath)
+	}
+
+	if err := insertSession(db.db, book.Id, session); err != nil {
+		return err
+	}
+
+	var count int
+	err := db.db.QueryRow("SELECT count(*) FROM sessions WHERE book_id = ? AND merged = 0", book.Id).Scan(&count)
+	if err != nil || count <= common.MAX_SESSIONS {
+		return err
+	}
+	return db.compactSessions(bookPath, book.Id)
+}
+
+// compactSessions replaces old sessions of the book with merged ones
+func (db *SqliteDb) compactSessions(bookPath, bookId string) error {
+	sessions := common.CompactSessions(db.Sessions(bookPath), time.Now())
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM sessions WHERE book_id = ?", bookId)
+	for i := 0; err == nil && i < len(sessions); i++ {
+		err = insertSession(tx, bookId, sessions[i])
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetTags replaces all tags of the book
+func (db *SqliteDb) SetTags(bookPath string, tags []string) error {
+	book, found := db.bookBy("file_path", bookPath)
+	if !found {
+		return fmt.Errorf("book '%s' is not in the library", bookPath)
+	}
+
+	book.Tags = tags
+	return db.saveBook(&book)
+}
+
+// SetRating sets the book rating: from 1 to common.MAX_RATING stars or 0
+// to remove the rating
+func (db *SqliteDb) SetRating(bookPath string, rating int) error {
+	book, found := db.bookBy("file_path", bookPath)
+	if !found {
+		return fmt.Errorf("book '%s' is not in the library", bookPath)
+	}
+	if rating < 0 || rating > common.MAX_RATING {
+		return fmt.Errorf("rating must be from 0 to %d", common.MAX_RATING)
+	}
+
+	book.Rating = rating
+	return db.saveBook(&book)
+}
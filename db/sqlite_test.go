@@ -0,0 +1,338 @@
+package db
+
+import (
+	"database/sql"
+	"github.com/VladimirMarkelov/termfb2/common"
+	"io/ioutil"
+	"os"
+	path "path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// schemaVersion returns the schema version of the library database
+func schemaVersion(t *testing.T, db *SqliteDb) int {
+	version := 0
+	if err := db.db.QueryRow("SELECT version FROM schema_version").Scan(&version); err != nil {
+		t.Fatalf("failed to read schema version: %v", err)
+	}
+	return version
+}
+
+// bookIds returns sorted ids of the books
+func bookIds(books []common.BookRecord) []string {
+	ids := make([]string, len(books))
+	for i, b := range books {
+		ids[i] = b.Id
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// addBooks inserts the books keeping their ids and dates
+func addBooks(t *testing.T, db *SqliteDb, books []common.BookRecord) {
+	for i := range books {
+		if err := insertBook(db.db, &books[i]); err != nil {
+			t.Fatalf("failed to add a book: %v", err)
+		}
+	}
+	db.changed()
+}
+
+func TestSqliteMigrations(t *testing.T) {
+	dir := t.TempDir()
+	db, err := InitSqliteDb(dir)
+	if err != nil {
+		t.Fatalf("failed to create the library: %v", err)
+	}
+	latest := schemaVersion(t, db)
+	if latest == 0 {
+		t.Fatalf("no migrations have been applied")
+	}
+	addBooks(t, db, []common.BookRecord{{Id: "1", FilePath: "/books/a.fb2", Title: "Title"}})
+	db.db.Close()
+
+	// opening the database again does not apply migrations twice
+	db, err = InitSqliteDb(dir)
+	if err != nil {
+		t.Fatalf("failed to open the library: %v", err)
+	}
+	defer db.db.Close()
+	if version := schemaVersion(t, db); version != latest {
+		t.Errorf("schema version %d after reopening, want %d", version, latest)
+	}
+	if err := db.ReadDatabase(); err != nil {
+		t.Fatalf("failed to read the library: %v", err)
+	}
+	if ids := bookIds(db.BookList()); !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Errorf("library books %v after reopening, want [1]", ids)
+	}
+}
+
+func TestSqliteUpgrade(t *testing.T) {
+	// a database of the first version that has tags and rating
+	dir := t.TempDir()
+	conn, err := sql.Open("sqlite", path.Join(dir, common.SQLITEFILE))
+	if err != nil {
+		t.Fatalf("failed to create the database: %v", err)
+	}
+	old := &SqliteDb{db: conn}
+	err = old.migrate([]migration{
+		createSchema,
+		func(tx *sql.Tx) error { return nil },
+		func(tx *sql.Tx) error { return createSessions(tx, dir) },
+		addTagsAndRating,
+	})
+	if err == nil {
+		_, err = conn.Exec("INSERT INTO books (id, file_path, last_name, title, sequence, tags, search_text, " +
+			"line_last, line_total) VALUES ('1', '/books/picnic.fb2', 'Strugatsky', 'Roadside Picnic', 'Noon', " +
+			"'sf,to read', 'old text', 300, 1000)")
+	}
+	if err == nil {
+		_, err = conn.Exec("INSERT INTO sessions (book_id, start_time, end_time, words) " +
+			"VALUES ('1', '2024-01-01T10:00:00Z', '2024-01-01T11:00:00Z', 100)")
+	}
+	conn.Close()
+	if err != nil {
+		t.Fatalf("failed to fill the old database: %v", err)
+	}
+
+	db, err := InitSqliteDb(dir)
+	if err != nil {
+		t.Fatalf("failed to upgrade the library: %v", err)
+	}
+	defer db.db.Close()
+	if err := db.ReadDatabase(); err != nil {
+		t.Fatalf("failed to read the library: %v", err)
+	}
+
+	// the book is added to the search index and keeps its line based
+	// progress until it is opened
+	for _, filter := range []string{"picnic", "strugatsky", "tag:sf", "seq:noon", "done:30"} {
+		db.SetFilter(filter)
+		if ids := bookIds(db.FilteredBooks()); !reflect.DeepEqual(ids, []string{"1"}) {
+			t.Errorf("filter %q found %v after upgrade, want [1]", filter, ids)
+		}
+	}
+	sessions := db.Sessions("/books/picnic.fb2")
+	if len(sessions) != 1 || sessions[0].Words != 100 || sessions[0].Merged != 0 {
+		t.Errorf("sessions %+v after upgrade, want one session with 100 words", sessions)
+	}
+}
+
+func TestSqliteImportScribble(t *testing.T) {
+	dir := t.TempDir()
+	collection := path.Join(dir, common.DBFILE, common.DBCOLLECTION)
+	if err := os.MkdirAll(collection, 0755); err != nil {
+		t.Fatal(err)
+	}
+	records := map[string]string{
+		"1.json":   `{"Id": "1", "FilePath": "/books/a.fb2", "Title": "First"}`,
+		"2.json":   `{"Id": "2", "FilePath": "/books/b.fb2", "Title": "Second"}`,
+		"dup.json": `{"Id": "3", "FilePath": "/books/a.fb2", "Title": "Duplicate"}`,
+		"bad.json": `{"Id": `,
+	}
+	for name, text := range records {
+		if err := ioutil.WriteFile(path.Join(collection, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := InitSqliteDb(dir)
+	if err != nil {
+		t.Fatalf("failed to create the library: %v", err)
+	}
+	defer db.db.Close()
+	if err := db.ReadDatabase(); err == nil {
+		t.Errorf("no error for a damaged and a duplicate record")
+	}
+	// import errors are reported once
+	if err := db.ReadDatabase(); err != nil {
+		t.Errorf("import error is reported again: %v", err)
+	}
+
+	ids := bookIds(db.BookList())
+	if len(ids) != 2 || ids[1] != "2" || (ids[0] != "1" && ids[0] != "3") {
+		t.Errorf("imported books %v, want 2 and one of 1 and 3", ids)
+	}
+	if _, err := os.Stat(path.Join(dir, common.DBFILE, common.QUARANTINEDIR, "bad.json")); err != nil {
+		t.Errorf("damaged record has not been quarantined: %v", err)
+	}
+}
+
+func TestSqliteImportRetry(t *testing.T) {
+	dir := t.TempDir()
+	collection := path.Join(dir, common.DBFILE, common.DBCOLLECTION)
+	if err := os.MkdirAll(path.Dir(collection), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// the collection cannot be read, so the import fails
+	if err := ioutil.WriteFile(collection, []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if db, err := InitSqliteDb(dir); err == nil {
+		db.db.Close()
+		t.Fatalf("no error when the scribble library cannot be read")
+	}
+
+	if err := os.Remove(collection); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(collection, 0755); err != nil {
+		t.Fatal(err)
+	}
+	record := `{"Id": "1", "FilePath": "/books/a.fb2", "Title": "First"}`
+	if err := ioutil.WriteFile(path.Join(collection, "1.json"), []byte(record), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next start imports the library
+	db, err := InitSqliteDb(dir)
+	if err != nil {
+		t.Fatalf("failed to import the library again: %v", err)
+	}
+	if err := db.ReadDatabase(); err != nil {
+		t.Fatalf("failed to read the library: %v", err)
+	}
+	if ids := bookIds(db.BookList()); !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Errorf("imported books %v, want [1]", ids)
+	}
+	db.db.Close()
+
+	// the library is imported only once
+	if err := os.Remove(path.Join(collection, "1.json")); err != nil {
+		t.Fatal(err)
+	}
+	record = `{"Id": "2", "FilePath": "/books/b.fb2", "Title": "Second"}`
+	if err := ioutil.WriteFile(path.Join(collection, "2.json"), []byte(record), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err = InitSqliteDb(dir)
+	if err != nil {
+		t.Fatalf("failed to open the library: %v", err)
+	}
+	defer db.db.Close()
+	db.ReadDatabase()
+	if ids := bookIds(db.BookList()); !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Errorf("library books %v after reopening, want [1]", ids)
+	}
+}
+
+func TestSqliteFilter(t *testing.T) {
+	db, err := InitSqliteDb(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create the library: %v", err)
+	}
+	defer db.db.Close()
+
+	books := []common.BookRecord{
+		{Id: "1", FilePath: "/books/picnic.fb2", FirstName: "Arkady", LastName: "Strugatsky",
+			Title: "Roadside Picnic", Language: "en", Tags: []string{"sf", "to read"}, Rating: 5,
+			Added: "2024-01-15T10:00:00Z", LineLast: 90, LineTotal: 100},
+		{Id: "2", FilePath: "/books/war_and_peace.fb2", LastName: "Tolstoy", Title: "War and Peace",
+			Language: "en", Tags: []string{"classic"}, Rating: 3, Added: "2023-06-01T10:00:00Z"},
+		{Id: "3", FilePath: "/books/пикник.fb2", LastName: "Стругацкий", Title: "Пикник на обочине",
+			Language: "ru", Tags: []string{"SF"}, Added: "2024-02-01T10:00:00Z", Completed: "2024-03-01T10:00:00Z"},
+	}
+	addBooks(t, db, books)
+	if err := db.ReadDatabase(); err != nil {
+		t.Fatalf("failed to read the library: %v", err)
+	}
+
+	tests := []struct {
+		filter string
+		mode   string
+		ids    []string
+	}{
+		{"", common.FILTER_SUBSTRING, []string{"1", "2", "3"}},
+		{"picnic", common.FILTER_SUBSTRING, []string{"1"}},
+		{"ПИКНИК", common.FILTER_SUBSTRING, []string{"3"}},
+		{"wa", common.FILTER_SUBSTRING, []string{"2"}},
+		{"_", common.FILTER_SUBSTRING, []string{"2"}},
+		{"%", common.FILTER_SUBSTRING, []string{}},
+		{"tag:sf", common.FILTER_SUBSTRING, []string{"1", "3"}},
+		{"tag:s", common.FILTER_SUBSTRING, []string{}},
+		{"-tag:sf", common.FILTER_SUBSTRING, []string{"2"}},
+		{"tag:read", common.FILTER_SUBSTRING, []string{}},
+		{`tag:"to read"`, common.FILTER_SUBSTRING, []string{"1"}},
+		{"lang:ru", common.FILTER_SUBSTRING, []string{"3"}},
+		{"rating:>=4", common.FILTER_SUBSTRING, []string{"1"}},
+		{"done:>50", common.FILTER_SUBSTRING, []string{"1"}},
+		{"added:2024", common.FILTER_SUBSTRING, []string{"1", "3"}},
+		{"added:<2024-02", common.FILTER_SUBSTRING, []string{"1", "2"}},
+		{"added:>2024-01", common.FILTER_SUBSTRING, []string{"3"}},
+		{"completed:2024-03", common.FILTER_SUBSTRING, []string{"3"}},
+		{"completed:<2025", common.FILTER_SUBSTRING, []string{"3"}},
+		{"id:2", common.FILTER_SUBSTRING, []string{"2"}},
+		{"strugatsky", common.FILTER_FUZZY, []string{"1", "3"}},
+		{"tolstoi", common.FILTER_FUZZY, []string{"2"}},
+	}
+	for _, test := range tests {
+		db.SetFilterMode(test.mode)
+		db.SetFilter(test.filter)
+		if err := db.FilterError(); err != nil {
+			t.Errorf("filter %q is invalid: %v", test.filter, err)
+			continue
+		}
+		if ids := bookIds(db.FilteredBooks()); !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("filter %q (%s) found %v, want %v", test.filter, test.mode, ids, test.ids)
+		}
+		if err := db.ListError(); err != nil {
+			t.Errorf("filter %q failed: %v", test.filter, err)
+		}
+	}
+
+	// deleted books are removed from the search index
+	db.SetFilterMode(common.FILTER_SUBSTRING)
+	db.SetFilter("picnic")
+	if err := db.DeleteBookByIndex(0); err != nil {
+		t.Fatalf("failed to delete a book: %v", err)
+	}
+	var count int
+	if err := db.db.QueryRow("SELECT count(*) FROM books_fts").Scan(&count); err != nil || count != 2 {
+		t.Errorf("search index has %d rows after deleting a book, want 2 (%v)", count, err)
+	}
+	if ids := bookIds(db.FilteredBooks()); len(ids) != 0 {
+		t.Errorf("filter found deleted books %v", ids)
+	}
+}
+
+func TestSqliteCompactSessions(t *testing.T) {
+	db, err := InitSqliteDb(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create the library: %v", err)
+	}
+	defer db.db.Close()
+	bookPath := "/books/a.fb2"
+	addBooks(t, db, []common.BookRecord{{Id: "1", FilePath: bookPath}})
+
+	// two sessions a day, so the old ones are merged by day
+	start := time.Now().AddDate(0, 0, -40).Truncate(24 * time.Hour)
+	total := common.MAX_SESSIONS + 1
+	for i := 0; i < total; i++ {
+		s := start.Add(time.Duration(i/2)*24*time.Hour + time.Duration(i%2)*2*time.Hour)
+		session := common.Session{
+			Start: s.Format(time.RFC3339),
+			End:   s.Add(30 * time.Minute).Format(time.RFC3339),
+			Words: 10,
+		}
+		if err := db.SaveSession(bookPath, session); err != nil {
+			t.Fatalf("failed to save a session: %v", err)
+		}
+	}
+
+	sessions := db.Sessions(bookPath)
+	if len(sessions) >= total {
+		t.Fatalf("%d sessions have not been merged", len(sessions))
+	}
+	count, words := 0, 0
+	for _, s := range sessions {
+		count += s.SessionCount()
+		words += s.Words
+	}
+	if count != total || words != total*10 {
+		t.Errorf("merged sessions stand for %d sessions and %d words, want %d and %d", count, words, total, total*10)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	ui "github.com/VladimirMarkelov/clui"
+	term "github.com/nsf/termbox-go"
+	"strings"
+)
+
+// the maximum number of errors displayed in the error dialog
+const maxShownErrors = 5
+
+// showError displays an error message in a modal dialog
+func showError(title, message string) {
+	ui.CreateAlertDialog(title, message, "OK")
+}
+
+// showErrorList displays the first errors of the list in a modal dialog.
+// Nothing is displayed if the list is empty
+func showErrorList(title string, errs []string) {
+	if len(errs) == 0 {
+		return
+	}
+	if len(errs) > maxShownErrors {
+		errs = append(errs[:maxShownErrors:maxShownErrors], fmt.Sprintf("and %d more", len(errs)-maxShownErrors))
+	}
+	showError(title, strings.Join(errs, "\n"))
+}
+
+// createInputDialog asks a user to enter a single line of text. Enter
+// closes the dialog and calls onEnter with the entered text, Escape closes
+// the dialog without doing anything
+func createInputDialog(title, text string, onEnter func(string)) {
+	cw, ch := term.Size()
+	dlgWidth := cw - 10
+	dlg := ui.AddWindow(5, ch/2-3, dlgWidth, 3, title)
+	dlg.SetConstraints(dlgWidth, ui.KeepValue)
+	dlg.SetPack(ui.Vertical)
+	dlg.SetModal(true)
+
+	edit := ui.CreateEditField(dlg, dlgWidth-2, text, 1)
+	ui.ActivateControl(dlg, edit)
+
+	dlg.OnKeyDown(func(ev ui.Event, data interface{}) bool {
+		switch ev.Key {
+		case term.KeyEsc:
+			go ui.PutEvent(ui.Event{Type: ui.EventCloseWindow})
+			return true
+		case term.KeyEnter:
+			// close the dialog before calling onEnter because the
+			// callback may open another dialog
+			text := edit.Title()
+			ui.WindowManager().DestroyWindow(dlg)
+			onEnter(text)
+			return true
+		}
+		return false
+	}, nil)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	ui "github.com/VladimirMarkelov/clui"
+	"github.com/VladimirMarkelov/termfb2/book"
+	"github.com/VladimirMarkelov/termfb2/common"
+	cf "github.com/VladimirMarkelov/termfb2/config"
+	"github.com/VladimirMarkelov/termfb2/index"
+	term "github.com/nsf/termbox-go"
+	"strings"
+)
+
+// the maximum number of paragraphs found in all books
+const maxTextHits = 500
+
+// indexBook adds the opened book to the full-text index if it is not
+// indexed yet or its file has changed
+func indexBook(conf *cf.Config, fileName string) {
+	if !conf.UseDb || len(conf.Book.Paragraphs) == 0 {
+		return
+	}
+
+	idx := conf.TextIndex()
+	if idx.Indexed(fileName, conf.BookHash) {
+		return
+	}
+	if err := idx.AddBook(fileName, conf.BookHash, conf.Book); err != nil {
+		showError("Search index error", fmt.Sprintf("Failed to index book '%s': %v", fileName, err))
+	}
+}
+
+// updateIndex adds library books that are not indexed yet to the
+// full-text index and shows the progress in the library status line. It
+// returns descriptions of books that could not be indexed and an error if
+// the index cannot be updated
+func updateIndex(controls *ControlList, conf *cf.Config) ([]string, error) {
+	// indices of books that are not in the list are removed, so an
+	// incomplete list must not be used
+	books := conf.DbDriver.BookList()
+	if err := conf.DbDriver.ListError(); err != nil {
+		return nil, err
+	}
+	errs, err := conf.TextIndex().Update(books, func(done, total int, fileName string) {
+		controls.bookInfoDetail.SetTitle(fmt.Sprintf("Indexing [%d/%d] %s", done+1, total, fileName))
+		ui.RefreshScreen()
+	})
+	controls.bookInfoDetail.SetTitle("")
+	return errs, err
+}
+
+// createFindTextDialog asks for a text to look for in all library books
+func createFindTextDialog(controls *ControlList, conf *cf.Config) {
+	createInputDialog("Search text in all books", conf.SearchText, func(text string) {
+		findText(controls, conf, strings.TrimSpace(text))
+	})
+}
+
+// findText looks for paragraphs of library books that contain all words
+// of the text and shows them in a list
+func findText(controls *ControlList, conf *cf.Config, text string) {
+	if len(index.Words(text)) == 0 {
+		return
+	}
+
+	// the opened book is added to the library when its position is saved,
+	// so it must be saved to be indexed as a library book
+	if err := saveBookPosition(conf); err != nil {
+		showError("Library error", fmt.Sprintf("Failed to save reading position: %v", err))
+	}
+	refreshBookList(controls, conf)
+	errs, err := updateIndex(controls, conf)
+	if err != nil {
+		showError("Search index error", fmt.Sprintf("Failed to update the search index: %v", err))
+		return
+	}
+	showErrorList("Index errors", errs)
+
+	hits, complete, err := conf.TextIndex().Search(text, maxTextHits)
+	if err != nil {
+		showError("Search index error", fmt.Sprintf("Failed to search text: %v", err))
+		return
+	}
+	searched := ""
+	if !complete {
+		searched = fmt.Sprintf(" in the first %d books with all words", index.MaxParsedBooks)
+	}
+	if len(hits) == 0 {
+		controls.bookInfoDetail.SetTitle(fmt.Sprintf("Text '%s' is not found%s", text, searched))
+		return
+	}
+	createTextHitsDialog(controls, conf, fmt.Sprintf("Found '%s'%s [%d]", text, searched, len(hits)), text, hits)
+}
+
+// Generate a text for TableView control that displays found paragraphs
+func getTextHitColumnText(b common.BookRecord, hit index.Hit, col int) string {
+	text := ""
+	switch col {
+	case 0:
+		text = getBookColumnText(b, 0)
+	case 1:
+		text = b.Title
+	case 2:
+		text = hit.Snippet
+	}
+
+	return text
+}
+
+// createTextHitsDialog shows paragraphs found in library books. Enter
+// opens the book of the selected paragraph and closes the library
+func createTextHitsDialog(controls *ControlList, conf *cf.Config, title, text string, hits []index.Hit) {
+	books := make([]common.BookRecord, len(hits))
+	for i, hit := range hits {
+		b, found := conf.DbDriver.BookByFilePath(hit.FilePath)
+		if !found {
+			b = common.BookRecord{FilePath: hit.FilePath}
+		}
+		books[i] = b
+	}
+
+	dlg := ui.AddWindow(0, 0, 12, 7, title)
+	dlg.SetPack(ui.Vertical)
+	dlg.SetModal(true)
+
+	table := ui.CreateTableView(dlg, minWidth, minHeight, 1)
+	ui.ActivateControl(dlg, table)
+	table.SetShowLines(true)
+	table.SetShowRowNumber(true)
+	dlg.SetMaximized(true)
+
+	table.SetRowCount(len(hits))
+	cols := []ui.Column{
+		ui.Column{Title: "Author", Width: 16, Alignment: ui.AlignLeft},
+		ui.Column{Title: "Title", Width: 25, Alignment: ui.AlignLeft},
+		ui.Column{Title: "Text", Width: 100, Alignment: ui.AlignLeft},
+	}
+	table.SetColumns(cols)
+
+	// Enter opens the selected book, Escape returns to the library
+	dlg.OnKeyDown(func(ev ui.Event, data interface{}) bool {
+		switch ev.Key {
+		case term.KeyEsc:
+			go ui.PutEvent(ui.Event{Type: ui.EventCloseWindow})
+			return true
+		case term.KeyEnter:
+			row := table.SelectedRow()
+			if row < 0 || row >= len(hits) {
+				return true
+			}
+			ui.WindowManager().DestroyWindow(dlg)
+			if openTextHit(controls, conf, text, books[row], hits[row]) {
+				// the library is closed the same way as after opening
+				// a book from it
+				go ui.PutEvent(ui.Event{Type: ui.EventCloseWindow})
+			}
+			return true
+		}
+		return false
+	}, nil)
+
+	table.OnDrawCell(func(info *ui.ColumnDrawInfo) {
+		if info.Row >= len(hits) {
+			return
+		}
+		info.Text = getTextHitColumnText(books[info.Row], hits[info.Row], info.Col)
+	})
+}
+
+// openTextHit opens the book and scrolls the reader to the found
+// paragraph. If the paragraph contains the whole text, the text is
+// highlighted as an in-book search result
+func openTextHit(controls *ControlList, conf *cf.Config, text string, b common.BookRecord, hit index.Hit) bool {
+	if !openBook(controls, conf, b) {
+		return false
+	}
+
+	controls.reader.SetTopLine(book.FindLine(conf.Lines, hit.Position()))
+	if hit.Exact {
+		runSearch(controls, conf, text)
+	} else {
+		clearSearch(controls, conf)
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	ui "github.com/VladimirMarkelov/clui"
+	cf "github.com/VladimirMarkelov/termfb2/config"
+	term "github.com/nsf/termbox-go"
+)
+
+// helpRow is a line of the help dialog
+type helpRow struct {
+	keys   string
+	action string
+	help   string
+}
+
+// fixedKeys are keys that cannot be changed in the configuration file
+var fixedKeys = map[string][]helpRow{
+	cf.CtxReader: {
+		{"CtrlQ CtrlQ", "", "close the application"},
+	},
+	cf.CtxLibrary: {
+		{"Characters", "", "filter the book list"},
+		{"Backspace", "", "erase the last filter character"},
+		{"CtrlQ CtrlQ", "", "close the application"},
+	},
+}
+
+// helpRows returns all hotkeys of the context: current bindings of all
+// actions, including unbound ones, and the keys that cannot be changed
+func helpRows(conf *cf.Config, ctx string) []helpRow {
+	rows := make([]helpRow, 0, len(cf.Actions))
+	for _, a := range cf.Actions {
+		if a.Context == ctx {
+			rows = append(rows, helpRow{keys: conf.KeyNames(ctx, a.Name), action: a.Name, help: a.Help})
+		}
+	}
+	return append(rows, fixedKeys[ctx]...)
+}
+
+// createHelpDialog shows hotkeys of the reader or the library dialog.
+// Escape, Enter, or the key that opens the dialog close it
+func createHelpDialog(conf *cf.Config, ctx string) {
+	rows := helpRows(conf, ctx)
+
+	title := "Reader hotkeys"
+	if ctx == cf.CtxLibrary {
+		title = "Library hotkeys"
+	}
+	dlg := ui.AddWindow(0, 0, 12, 7, title)
+	dlg.SetPack(ui.Vertical)
+	dlg.SetModal(true)
+
+	table := ui.CreateTableView(dlg, minWidth, minHeight, 1)
+	ui.ActivateControl(dlg, table)
+	table.SetShowLines(true)
+	dlg.SetMaximized(true)
+
+	cols := []ui.Column{
+		ui.Column{Title: "Keys", Width: 20, Alignment: ui.AlignLeft},
+		ui.Column{Title: "Action", Width: 14, Alignment: ui.AlignLeft},
+		ui.Column{Title: "Description", Width: 40, Alignment: ui.AlignLeft},
+	}
+	table.SetColumns(cols)
+	table.SetRowCount(len(rows))
+
+	dlg.OnKeyDown(func(ev ui.Event, data interface{}) bool {
+		if ev.Key == term.KeyEsc || ev.Key == term.KeyEnter || conf.ActionForKey(ctx, ev.Key, ev.Ch) == cf.ActHelp {
+			go ui.PutEvent(ui.Event{Type: ui.EventCloseWindow})
+			return true
+		}
+		return false
+	}, nil)
+
+	table.OnDrawCell(func(info *ui.ColumnDrawInfo) {
+		if info.Row >= len(rows) {
+			return
+		}
+		row := rows[info.Row]
+		switch info.Col {
+		case 0:
+			info.Text = row.keys
+		case 1:
+			info.Text = row.action
+		case 2:
+			info.Text = row.help
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	ui "github.com/VladimirMarkelov/clui"
+	"github.com/VladimirMarkelov/termfb2/common"
+	cf "github.com/VladimirMarkelov/termfb2/config"
+	"github.com/VladimirMarkelov/termfb2/scan"
+	"io/ioutil"
+	"os"
+	path "path/filepath"
+	"strings"
+)
+
+// createImportDialog asks for a directory and adds all books from it and
+// its sub-directories to the library
+func createImportDialog(controls *ControlList, conf *cf.Config) {
+	dir, _ := os.Getwd()
+	createInputDialog("Import books from directory", dir, func(dir string) {
+		importBooks(controls, conf, strings.TrimSpace(dir))
+	})
+}
+
+// importBooks scans the directory, indexes the imported books and shows
+// the progress of both passes in the library status line
+func importBooks(controls *ControlList, conf *cf.Config, dir string) {
+	if dir == "" {
+		return
+	}
+
+	res, err := scan.ImportDir(conf.DbDriver, dir, func(done, total int, fileName string) {
+		controls.bookInfoDetail.SetTitle(fmt.Sprintf("Importing [%d/%d] %s", done+1, total, fileName))
+		ui.RefreshScreen()
+	})
+	controls.bookTable.SetRowCount(len(conf.DbDriver.FilteredBooks()))
+	if err != nil {
+		controls.bookInfoDetail.SetTitle("")
+		showError("Error", fmt.Sprintf("Failed to scan '%s': %v", dir, err))
+		return
+	}
+
+	// imported books are indexed for full-text search right away. Errors of
+	// both passes are shown in one dialog
+	errs := res.Errors
+	indexErrs, err := updateIndex(controls, conf)
+	errs = append(errs, indexErrs...)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("Failed to update the search index: %v", err))
+	}
+	controls.bookInfoDetail.SetTitle(fmt.Sprintf("Import from '%s': %s", dir, res))
+	showErrorList("Import errors", errs)
+}
+
+// identifyBook calculates the content hash of the opened book. If the book
+// is not in the library, but it is a moved book from the library, the book
+// record is updated to point to the new path, so the reading position and
+// bookmarks are kept
+func identifyBook(conf *cf.Config, fileName string) {
+	conf.BookHash = ""
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	conf.BookHash = scan.ContentHash(data)
+
+	if !conf.UseDb {
+		return
+	}
+	if _, found := conf.DbDriver.BookByFilePath(fileName); found {
+		return
+	}
+	moved, found := scan.FindMoved(conf.DbDriver, conf.BookHash, "")
+	if !found {
+		moved, found = scan.FindMoved(conf.DbDriver, "", conf.Info.Id)
+	}
+	if !found {
+		return
+	}
+	if err := scan.Relink(conf.DbDriver, moved, fileName, conf.BookHash); err != nil {
+		showError("Library error", fmt.Sprintf("Failed to update the path of book '%s': %v", moved.Title, err))
+	}
+}
+
+// createRelocateDialog asks for a new path of the file of the book
+// selected in the library
+func createRelocateDialog(controls *ControlList, conf *cf.Config) {
+	row := controls.bookTable.SelectedRow()
+	filtered := conf.DbDriver.FilteredBooks()
+	if row < 0 || row >= len(filtered) {
+		return
+	}
+
+	b := filtered[row]
+	createInputDialog("New path of the book file", b.FilePath, func(newPath string) {
+		relocateBook(controls, conf, b, strings.TrimSpace(newPath))
+	})
+}
+
+func relocateBook(controls *ControlList, conf *cf.Config, b common.BookRecord, newPath string) {
+	if newPath == "" || newPath == b.FilePath {
+		return
+	}
+
+	absPath, err := path.Abs(newPath)
+	if err != nil {
+		showError("Error", fmt.Sprintf("Invalid path '%s': %v", newPath, err))
+		return
+	}
+	data, err := ioutil.ReadFile(absPath)
+	if err != nil {
+		showError("Error", fmt.Sprintf("Failed to read book '%s': %v", newPath, err))
+		return
+	}
+	if _, found := conf.DbDriver.BookByFilePath(absPath); found {
+		showError("Error", fmt.Sprintf("The file '%s' belongs to another book in the library", newPath))
+		return
+	}
+
+	if err := conf.DbDriver.RelinkBook(b.FilePath, absPath, scan.ContentHash(data)); err != nil {
+		showError("Library error", fmt.Sprintf("Failed to update the path of book '%s': %v", b.Title, err))
+		return
+	}
+	if conf.LastFile == b.FilePath {
+		conf.LastFile = absPath
+	}
+	controls.bookTable.SetRowCount(len(conf.DbDriver.FilteredBooks()))
+}
@@ -0,0 +1,278 @@
+package index
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"github.com/VladimirMarkelov/termfb2/book"
+	"github.com/VladimirMarkelov/termfb2/common"
+	"io/ioutil"
+	"os"
+	path "path/filepath"
+	"strings"
+	"unicode"
+)
+
+// version of the index file format. Files of other versions are indexed
+// again
+const version = 1
+
+// fileExt is the extension of index files of books
+const fileExt = ".idx"
+
+// Progress is called before indexing every book: done is the number of
+// processed books
+type Progress func(done, total int, fileName string)
+
+// Index is an inverted index of the text of library books: for every word
+// of a book it keeps the paragraphs the word is found in. Every book is
+// kept in a separate file of the index directory
+type Index struct {
+	dir string
+	// indexed books by their file paths. They are read from the disk the
+	// first time all books are needed
+	books map[string]*bookIndex
+}
+
+// header is the first value of an index file. It is read without
+// the words to check whether the book is indexed
+type header struct {
+	Version  int
+	FilePath string
+	// content hash of the book file when it was indexed
+	Hash string
+}
+
+// bookIndex is the index of a single book
+type bookIndex struct {
+	header
+	// sorted indices of paragraphs every word is found in. It is empty
+	// if the book could not be read
+	Words map[string][]int32
+}
+
+// Open returns the index kept in the directory. The directory is created
+// when the first book is indexed
+func Open(dir string) *Index {
+	return &Index{dir: dir}
+}
+
+// Words splits a text into lowercase words: sequences of letters and
+// digits. Text and search queries are split the same way
+func Words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// indexFile returns the name of the index file of the book
+func (idx *Index) indexFile(fileName string) string {
+	sum := sha1.Sum([]byte(fileName))
+	return path.Join(idx.dir, hex.EncodeToString(sum[:])+fileExt)
+}
+
+// readFile reads the index of a book. If withWords is false only the
+// header is read
+func readFile(fileName string, withWords bool) (*bookIndex, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	bi := &bookIndex{}
+	dec := gob.NewDecoder(bufio.NewReader(file))
+	if err := dec.Decode(&bi.header); err != nil {
+		return nil, err
+	}
+	if bi.Version != version {
+		return nil, fmt.Errorf("unsupported index version %d", bi.Version)
+	}
+	if withWords {
+		if err := dec.Decode(&bi.Words); err != nil {
+			return nil, err
+		}
+	}
+	return bi, nil
+}
+
+// writeFile saves the index of a book. The file is replaced only after
+// the new index is written completely
+func (idx *Index) writeFile(bi *bookIndex) error {
+	if err := os.MkdirAll(idx.dir, 0755); err != nil {
+		return err
+	}
+
+	fileName := idx.indexFile(bi.FilePath)
+	tmpName := fileName + ".tmp"
+	file, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	enc := gob.NewEncoder(w)
+	err = enc.Encode(&bi.header)
+	if err == nil {
+		err = enc.Encode(bi.Words)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
+
+// load reads all indexed books. Damaged index files are removed, so their
+// books are indexed again
+func (idx *Index) load() error {
+	if idx.books != nil {
+		return nil
+	}
+
+	books := make(map[string]*bookIndex)
+	files, err := ioutil.ReadDir(idx.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), fileExt) {
+			continue
+		}
+		fileName := path.Join(idx.dir, f.Name())
+		bi, err := readFile(fileName, true)
+		if err != nil || fileName != idx.indexFile(bi.FilePath) {
+			os.Remove(fileName)
+			continue
+		}
+		books[bi.FilePath] = bi
+	}
+	idx.books = books
+	return nil
+}
+
+// Indexed returns true if the book file is indexed. If the hash is not
+// empty, it must be the same as the hash of the indexed file
+func (idx *Index) Indexed(fileName, hash string) bool {
+	bi, found := idx.books[fileName]
+	if idx.books == nil {
+		var err error
+		bi, err = readFile(idx.indexFile(fileName), false)
+		found = err == nil && bi.FilePath == fileName
+	}
+	return found && (hash == "" || bi.Hash == hash)
+}
+
+// AddBook indexes the text of all paragraphs of the book, including notes.
+// The previous index of the file is replaced
+func (idx *Index) AddBook(fileName, hash string, b *book.Book) error {
+	words := make(map[string][]int32)
+	for i, p := range b.Paragraphs {
+		for _, w := range Words(p.Text) {
+			paras := words[w]
+			if len(paras) == 0 || paras[len(paras)-1] != int32(i) {
+				words[w] = append(paras, int32(i))
+			}
+		}
+	}
+
+	return idx.saveBook(&bookIndex{header: header{Version: version, FilePath: fileName, Hash: hash}, Words: words})
+}
+
+// saveBook writes the book index to the disk and keeps it in the loaded
+// book list
+func (idx *Index) saveBook(bi *bookIndex) error {
+	if err := idx.writeFile(bi); err != nil {
+		return err
+	}
+	if idx.books != nil {
+		idx.books[bi.FilePath] = bi
+	}
+	return nil
+}
+
+// RemoveBook deletes the index of the book file
+func (idx *Index) RemoveBook(fileName string) error {
+	if idx.books != nil {
+		delete(idx.books, fileName)
+	}
+	err := os.Remove(idx.indexFile(fileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Update indexes all library books that are not indexed yet and removes
+// books that are not in the library anymore. A moved book keeps its index
+// if its content hash is the same. Books with missing files are skipped.
+// It returns descriptions of books that could not be read: they are
+// indexed without text, so they are not read again until they change
+func (idx *Index) Update(books []common.BookRecord, progress Progress) ([]string, error) {
+	if err := idx.load(); err != nil {
+		return nil, err
+	}
+
+	inLibrary := make(map[string]bool, len(books))
+	for _, b := range books {
+		inLibrary[b.FilePath] = true
+	}
+	// indices of removed books by hash to reuse them for moved books
+	removed := make(map[string]*bookIndex)
+	for fileName, bi := range idx.books {
+		if inLibrary[fileName] {
+			continue
+		}
+		if err := idx.RemoveBook(fileName); err != nil {
+			return nil, err
+		}
+		if bi.Hash != "" {
+			removed[bi.Hash] = bi
+		}
+	}
+
+	todo := make([]common.BookRecord, 0)
+	for _, b := range books {
+		if !idx.Indexed(b.FilePath, b.Hash) {
+			todo = append(todo, b)
+		}
+	}
+
+	errs := make([]string, 0)
+	for i, b := range todo {
+		if progress != nil {
+			progress(i, len(todo), b.FilePath)
+		}
+		if moved, found := removed[b.Hash]; found && b.Hash != "" {
+			// the removed index is used only once: other books with the
+			// same content are indexed from their own files
+			delete(removed, b.Hash)
+			bi := *moved
+			bi.FilePath = b.FilePath
+			if err := idx.saveBook(&bi); err != nil {
+				return errs, err
+			}
+			continue
+		}
+		if _, err := os.Stat(b.FilePath); os.IsNotExist(err) {
+			continue
+		}
+
+		bk, err := book.ParseBook(b.FilePath)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", b.FilePath, err))
+		}
+		if err := idx.AddBook(b.FilePath, b.Hash, bk); err != nil {
+			return errs, err
+		}
+	}
+
+	return errs, nil
+}
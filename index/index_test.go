@@ -0,0 +1,222 @@
+package index
+
+import (
+	"fmt"
+	"github.com/VladimirMarkelov/termfb2/book"
+	"github.com/VladimirMarkelov/termfb2/common"
+	"io/ioutil"
+	path "path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		text  string
+		words []string
+	}{
+		{"", []string{}},
+		{"  ,.!  ", []string{}},
+		{"Hello, World!", []string{"hello", "world"}},
+		{"Жили-были ДЕД и баба", []string{"жили", "были", "дед", "и", "баба"}},
+		{"chapter 12: it's 1984", []string{"chapter", "12", "it", "s", "1984"}},
+	}
+	for _, test := range tests {
+		if words := Words(test.text); !reflect.DeepEqual(words, test.words) {
+			t.Errorf("Words(%q) = %q, want %q", test.text, words, test.words)
+		}
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	tests := []struct {
+		a, b []int32
+		res  []int32
+	}{
+		{[]int32{}, []int32{1, 2}, []int32{}},
+		{[]int32{1, 2}, nil, []int32{}},
+		{[]int32{1, 3, 5, 7}, []int32{2, 3, 4, 7, 9}, []int32{3, 7}},
+		{[]int32{1, 2, 3}, []int32{1, 2, 3}, []int32{1, 2, 3}},
+		{[]int32{1, 2}, []int32{3, 4}, []int32{}},
+	}
+	for _, test := range tests {
+		if res := intersect(test.a, test.b); !reflect.DeepEqual(res, test.res) {
+			t.Errorf("intersect(%v, %v) = %v, want %v", test.a, test.b, res, test.res)
+		}
+	}
+}
+
+func TestMakeHit(t *testing.T) {
+	long := strings.Repeat("a ", 40) + "needle" + strings.Repeat(" b", 60)
+	tests := []struct {
+		name    string
+		text    string
+		search  string
+		found   bool
+		exact   bool
+		offset  int
+		snippet string
+	}{
+		{"exact", "Жили-были ДЕД и баба", "дед", true, true, 10, "Жили-были ДЕД и баба"},
+		{"exact phrase", "Жили-были дед и баба", "Дед  и", true, true, 10, "Жили-были дед и баба"},
+		{"words", "Жили-были дед и баба", "баба дед", true, false, 16, "Жили-были дед и баба"},
+		{"missing word", "Жили-были дед и баба", "дед репка", false, false, 0, ""},
+		{"long", long, "needle", true, true, 80, "..." + long[50:150] + "..."},
+	}
+	for _, test := range tests {
+		words := Words(test.search)
+		phrase := strings.ToLower(strings.Join(strings.Fields(test.search), " "))
+		hit, found := makeHit(test.text, words, phrase)
+		if found != test.found {
+			t.Errorf("%s: found = %v, want %v", test.name, found, test.found)
+			continue
+		}
+		if !found {
+			continue
+		}
+		if hit.Exact != test.exact || hit.Offset != test.offset || hit.Snippet != test.snippet {
+			t.Errorf("%s: hit %+v, want exact %v, offset %d, snippet %q",
+				test.name, hit, test.exact, test.offset, test.snippet)
+		}
+	}
+}
+
+// writeBooks writes text books to the directory and returns their paths
+func writeBooks(t *testing.T, dir string, texts map[string]string) map[string]string {
+	paths := make(map[string]string)
+	for name, text := range texts {
+		paths[name] = path.Join(dir, name)
+		if err := ioutil.WriteFile(paths[name], []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return paths
+}
+
+// indexBook parses the book file and adds it to the index
+func indexBook(t *testing.T, idx *Index, fileName, hash string) {
+	bk, err := book.ParseBook(fileName)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", fileName, err)
+	}
+	if err := idx.AddBook(fileName, hash, bk); err != nil {
+		t.Fatalf("failed to index %s: %v", fileName, err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeBooks(t, dir, map[string]string{
+		"a.txt": "The fox is red.\n\nThe fox is still red.\n\nRed is the fox.\n",
+		"b.txt": "A red fox runs.\n\nNothing here.\n",
+	})
+	idx := Open(path.Join(dir, "index"))
+	for name, p := range paths {
+		indexBook(t, idx, p, name)
+	}
+
+	tests := []struct {
+		text  string
+		limit int
+		hits  []string
+	}{
+		// paragraphs with the whole text go before paragraphs with its words
+		{"red fox", 2, []string{"A red fox runs.", "The fox is red."}},
+		{"RED  FOX", 1, []string{"A red fox runs."}},
+		{"fox red", 10, []string{"The fox is red.", "The fox is still red.", "Red is the fox.", "A red fox runs."}},
+		{"nothing", 10, []string{"Nothing here."}},
+		{"wolf", 10, []string{}},
+		{"  ", 10, []string{}},
+	}
+	for _, test := range tests {
+		hits, complete, err := idx.Search(test.text, test.limit)
+		if err != nil || !complete {
+			t.Errorf("Search(%q) failed: %v, complete %v", test.text, err, complete)
+			continue
+		}
+		texts := make([]string, len(hits))
+		for i, h := range hits {
+			texts[i] = h.Snippet
+		}
+		if !reflect.DeepEqual(texts, test.hits) {
+			t.Errorf("Search(%q, %d) = %q, want %q", test.text, test.limit, texts, test.hits)
+		}
+	}
+}
+
+func TestSearchLimit(t *testing.T) {
+	dir := t.TempDir()
+	texts := make(map[string]string)
+	for i := 0; i <= MaxParsedBooks; i++ {
+		texts[fmt.Sprintf("%03d.txt", i)] = "The fox is red.\n"
+	}
+	texts["other.txt"] = "Nothing here.\n"
+	idx := Open(path.Join(dir, "index"))
+	for name, p := range writeBooks(t, dir, texts) {
+		indexBook(t, idx, p, name)
+	}
+
+	tests := []struct {
+		text     string
+		limit    int
+		hits     int
+		complete bool
+	}{
+		// books without the words are not read, so they do not count
+		{"nothing", 10, 1, true},
+		{"fox red", 10, 10, false},
+		{"fox red", 1000, MaxParsedBooks, false},
+	}
+	for _, test := range tests {
+		hits, complete, err := idx.Search(test.text, test.limit)
+		if err != nil || len(hits) != test.hits || complete != test.complete {
+			t.Errorf("Search(%q, %d) found %d, complete %v, %v, want %d, complete %v",
+				test.text, test.limit, len(hits), complete, err, test.hits, test.complete)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeBooks(t, dir, map[string]string{
+		"a.txt": "Some text.\n",
+		"b.txt": "Other text.\n",
+	})
+	idx := Open(path.Join(dir, "index"))
+	indexBook(t, idx, paths["a.txt"], "hash-a")
+
+	// the book is moved to a file that does not exist yet, and a copy of
+	// it is added. Only one of them can reuse the index of the old file
+	moved := path.Join(dir, "moved.txt")
+	books := []common.BookRecord{
+		{FilePath: moved, Hash: "hash-a"},
+		{FilePath: path.Join(dir, "copy.txt"), Hash: "hash-a"},
+		{FilePath: paths["b.txt"], Hash: "hash-b"},
+	}
+	errs, err := idx.Update(books, nil)
+	if err != nil || len(errs) != 0 {
+		t.Fatalf("Update failed: %v %v", err, errs)
+	}
+
+	tests := []struct {
+		fileName string
+		indexed  bool
+	}{
+		{paths["a.txt"], false},
+		{moved, true},
+		{path.Join(dir, "copy.txt"), false},
+		{paths["b.txt"], true},
+	}
+	for _, test := range tests {
+		if indexed := idx.Indexed(test.fileName, ""); indexed != test.indexed {
+			t.Errorf("%s indexed = %v, want %v", path.Base(test.fileName), indexed, test.indexed)
+		}
+	}
+
+	// the index is read from the disk the same way
+	idx = Open(path.Join(dir, "index"))
+	if !idx.Indexed(moved, "hash-a") || idx.Indexed(paths["a.txt"], "") {
+		t.Errorf("the moved book index has not been saved")
+	}
+}
@@ -0,0 +1,182 @@
+package index
+
+import (
+	"github.com/VladimirMarkelov/termfb2/book"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// the number of characters of the paragraph text shown before and after
+// the beginning of the found text
+const (
+	snippetBefore = 30
+	snippetAfter  = 70
+)
+
+// MaxParsedBooks is the number of books a search reads at most. Books are
+// read to check the whole text and to make snippets, so without the limit
+// a text of common words would read the whole library
+const MaxParsedBooks = 50
+
+// Hit is a book paragraph that contains all words of the searched text
+type Hit struct {
+	FilePath string
+	Para     int
+	// rune offset of the found text inside the paragraph
+	Offset int
+	// true if the paragraph contains the whole text, not only its words
+	Exact bool
+	// the paragraph text around the found text
+	Snippet string
+}
+
+// Position returns the position of the found text in the book
+func (h Hit) Position() book.Position {
+	return book.Position{Para: h.Para, Offset: h.Offset}
+}
+
+// intersect returns paragraphs that are in both sorted lists
+func intersect(a, b []int32) []int32 {
+	res := make([]int32, 0)
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	return res
+}
+
+// findParas returns paragraphs of the book that contain all words
+func (bi *bookIndex) findParas(words []string) []int32 {
+	paras := bi.Words[words[0]]
+	for _, w := range words[1:] {
+		if len(paras) == 0 {
+			break
+		}
+		paras = intersect(paras, bi.Words[w])
+	}
+	return paras
+}
+
+// Search looks for paragraphs that contain all words of the text ignoring
+// case. Books of found paragraphs are read to make snippets, so paragraphs
+// that do not contain the words anymore (the book file has changed after
+// it was indexed) are skipped. At most limit paragraphs are returned,
+// paragraphs that contain the whole text go first. At most MaxParsedBooks
+// books are read, the returned flag is false if some books with the words
+// have not been searched
+func (idx *Index) Search(text string, limit int) ([]Hit, bool, error) {
+	words := Words(text)
+	if len(words) == 0 {
+		return []Hit{}, true, nil
+	}
+	if err := idx.load(); err != nil {
+		return []Hit{}, true, err
+	}
+
+	files := make([]string, 0, len(idx.books))
+	for fileName := range idx.books {
+		files = append(files, fileName)
+	}
+	sort.Strings(files)
+
+	// paragraphs with the whole text and with only its words are collected
+	// separately, so the limit does not drop exact hits of books that go
+	// after many word hits
+	exact := make([]Hit, 0)
+	other := make([]Hit, 0)
+	phrase := strings.ToLower(strings.Join(strings.Fields(text), " "))
+	complete := true
+	parsed := 0
+	for _, fileName := range files {
+		if len(exact) >= limit {
+			break
+		}
+		paras := idx.books[fileName].findParas(words)
+		if len(paras) == 0 {
+			continue
+		}
+		if parsed >= MaxParsedBooks {
+			complete = false
+			break
+		}
+		parsed++
+		bk, err := book.ParseBook(fileName)
+		if err != nil && len(bk.Paragraphs) == 0 {
+			continue
+		}
+		for _, para := range paras {
+			if int(para) >= len(bk.Paragraphs) || len(exact) >= limit {
+				break
+			}
+			hit, ok := makeHit(bk.Paragraphs[para].Text, words, phrase)
+			if !ok {
+				continue
+			}
+			hit.FilePath = fileName
+			hit.Para = int(para)
+			if hit.Exact {
+				exact = append(exact, hit)
+			} else if len(other) < limit {
+				other = append(other, hit)
+			}
+		}
+	}
+
+	hits := append(exact, other...)
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, complete, nil
+}
+
+// makeHit finds the text in the paragraph and makes the snippet around
+// it. If the paragraph does not contain the whole text, the snippet starts
+// near the first word. It returns false if some words are not found
+func makeHit(text string, words []string, phrase string) (Hit, bool) {
+	hit := Hit{}
+	lower := strings.ToLower(text)
+	start := strings.Index(lower, phrase)
+	if start >= 0 {
+		hit.Exact = true
+	} else {
+		found := make(map[string]bool)
+		for _, w := range Words(text) {
+			found[w] = true
+		}
+		for _, w := range words {
+			if !found[w] {
+				return hit, false
+			}
+		}
+		start = strings.Index(lower, words[0])
+	}
+	// ToLower converts every rune to a single rune, so rune offsets of
+	// the text and its lowercase version are the same
+	hit.Offset = utf8.RuneCountInString(lower[:start])
+
+	runes := []rune(text)
+	from, to := hit.Offset-snippetBefore, hit.Offset+snippetAfter
+	if from < 0 {
+		from = 0
+	}
+	if to > len(runes) {
+		to = len(runes)
+	}
+	hit.Snippet = string(runes[from:to])
+	if from > 0 {
+		hit.Snippet = "..." + hit.Snippet
+	}
+	if to < len(runes) {
+		hit.Snippet += "..."
+	}
+	return hit, true
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"github.com/VladimirMarkelov/termfb2/book"
+	"github.com/VladimirMarkelov/termfb2/common"
+	cf "github.com/VladimirMarkelov/termfb2/config"
+	"sort"
+	"strings"
+)
+
+// linkRef points to a link inside a book paragraph
+type linkRef struct {
+	para  int
+	index int
+}
+
+// runeStyle is a text and background colors of a single character. Empty
+// string means the default color
+type runeStyle struct {
+	fg string
+	bg string
+}
+
+// colorizeLine converts a line to clui colored text. styles contains
+// colors for every line rune
+func colorizeLine(runes []rune, styles []runeStyle) string {
+	var sb strings.Builder
+	curr := runeStyle{}
+	for i, r := range runes {
+		if styles[i].fg != curr.fg {
+			sb.WriteString("<c:" + styles[i].fg + ">")
+		}
+		if styles[i].bg != curr.bg {
+			sb.WriteString("<b:" + styles[i].bg + ">")
+		}
+		curr = styles[i]
+		sb.WriteRune(r)
+	}
+	if curr.fg != "" {
+		sb.WriteString("<c:>")
+	}
+	if curr.bg != "" {
+		sb.WriteString("<b:>")
+	}
+
+	return sb.String()
+}
+
+// kindColor returns the text color of a paragraph kind
+func kindColor(conf *cf.Config, kind book.Kind) string {
+	switch kind {
+	case book.KindTitle:
+		return conf.TitleColor
+	case book.KindSubtitle:
+		return conf.SubtitleColor
+	case book.KindEpigraph:
+		return conf.EpigraphColor
+	case book.KindPoem:
+		return conf.PoemColor
+	}
+	return ""
+}
+
+// styleColor returns the text color of a character: the paragraph color
+// combined with colors of all inline styles of the character
+func styleColor(conf *cf.Config, base string, style book.Style) string {
+	parts := make([]string, 0, 5)
+	if base != "" {
+		parts = append(parts, base)
+	}
+	if style&book.StyleStrong != 0 && conf.StrongColor != "" {
+		parts = append(parts, conf.StrongColor)
+	}
+	if style&book.StyleEmphasis != 0 && conf.EmphasisColor != "" {
+		parts = append(parts, conf.EmphasisColor)
+	}
+	if style&book.StyleStrike != 0 && conf.StrikeColor != "" {
+		parts = append(parts, conf.StrikeColor)
+	}
+	if style&book.StyleCode != 0 && conf.CodeColor != "" {
+		parts = append(parts, conf.CodeColor)
+	}
+	return strings.Join(parts, " ")
+}
+
+// renderLine returns a text of a formatted line with text styles applied
+// and all links and search matches highlighted
+func renderLine(conf *cf.Config, ind int) string {
+	line := &conf.Lines[ind]
+	if line.Para >= len(conf.Book.Paragraphs) {
+		return line.Text
+	}
+	para := &conf.Book.Paragraphs[line.Para]
+	firstMatch := sort.Search(len(conf.Matches), func(i int) bool {
+		return conf.Matches[i].Para >= line.Para
+	})
+	hasMatches := firstMatch < len(conf.Matches) && conf.Matches[firstMatch].Para == line.Para
+	base := kindColor(conf, para.Kind)
+	if len(para.Links) == 0 && len(para.Spans) == 0 && !hasMatches && base == "" {
+		return line.Text
+	}
+
+	runes := []rune(line.Text)
+	styles := make([]runeStyle, len(runes))
+	for col, src := range line.Src {
+		offset := int(src)
+		if offset < 0 {
+			styles[col].fg = base
+			continue
+		}
+		styles[col].fg = styleColor(conf, base, para.StyleAt(offset))
+		for i, link := range para.Links {
+			if offset < link.Start || offset >= link.End {
+				continue
+			}
+			styles[col].fg = conf.LinkColor
+			if conf.SelectedPara == line.Para && conf.SelectedLink == i {
+				styles[col].fg += " reverse"
+			}
+		}
+		for i := firstMatch; i < len(conf.Matches) && conf.Matches[i].Para == line.Para; i++ {
+			m := conf.Matches[i]
+			if offset < m.Start || offset >= m.End {
+				continue
+			}
+			styles[col].fg = "black"
+			if i == conf.CurrentMatch {
+				styles[col].bg = conf.SearchCurrentColor
+			} else {
+				styles[col].bg = conf.SearchColor
+			}
+		}
+	}
+
+	return colorizeLine(runes, styles)
+}
+
+// updateTitle shows the reading progress, the book title and the search
+// state in the reader title
+func updateTitle(controls *ControlList, conf *cf.Config) {
+	if conf.LastLength == 0 {
+		return
+	}
+
+	topLine := conf.LastPosition + 1
+	winTitle := fmt.Sprintf("[%v%%] [%v/%v] ", int(topLine*100/conf.LastLength), topLine, conf.LastLength)
+	if chapter := chapterTitle(conf); chapter != "" {
+		winTitle += fmt.Sprintf("[%s] ", chapter)
+	}
+	winTitle += titleForBook(conf.Info)
+	if left := timeLeftText(conf); left != "" {
+		winTitle += fmt.Sprintf(" [%s]", left)
+	}
+	if conf.SearchText != "" {
+		if len(conf.Matches) == 0 {
+			winTitle += fmt.Sprintf(" [%s: not found]", conf.SearchText)
+		} else if conf.CurrentMatch >= 0 {
+			winTitle += fmt.Sprintf(" [%s: %v/%v]", conf.SearchText, conf.CurrentMatch+1, len(conf.Matches))
+		}
+	}
+	controls.mainWindow.SetTitle(winTitle)
+}
+
+// restorePosition returns the line to show at the top of the reader for
+// the saved reading position. Databases and last files created by old
+// versions keep only a line number (para is -1). In this case the line is
+// recalculated in proportion to the new number of lines
+func restorePosition(conf *cf.Config, para, offset, line, total int) int {
+	if para >= 0 {
+		return book.FindLine(conf.Lines, book.Position{Para: para, Offset: offset})
+	}
+
+	if total > 0 && total != len(conf.Lines) {
+		line = line * len(conf.Lines) / total
+	}
+	if line >= len(conf.Lines) {
+		line = len(conf.Lines) - 1
+	}
+	if line < 0 {
+		line = 0
+	}
+	return line
+}
+
+// restoreBookPosition returns the line to show at the top of the reader for
+// the position saved in the library
+func restoreBookPosition(conf *cf.Config, b common.BookRecord) int {
+	if b.PosVersion < common.POS_VERSION {
+		return restorePosition(conf, -1, 0, b.LineLast, b.LineTotal)
+	}
+	return restorePosition(conf, b.ParaLast, b.OffsetLast, b.LineLast, b.LineTotal)
+}
+
+// formatBook splits the opened book into lines that fit the width and
+// counts words left to the end of the book from every line
+func formatBook(conf *cf.Config, width int) {
+	conf.Lines = book.Format(conf.Book, width, conf.Justify)
+	conf.LineWidth = width
+	conf.WordsLeft = book.WordsLeft(conf.Lines, conf.Book.TextEnd())
+}
+
+// reflowBook formats the opened book to fit the new reader width. The text
+// that was at the top of the reader before reformatting stays at the top
+func reflowBook(controls *ControlList, conf *cf.Config, width int) {
+	if width == conf.LineWidth || width <= 0 || len(conf.Lines) == 0 {
+		return
+	}
+
+	oldLines := conf.Lines
+	top := controls.reader.TopLine()
+	formatBook(conf, width)
+	controls.reader.SetLineCount(len(conf.Lines))
+	controls.reader.SetTopLine(remapLine(oldLines, conf.Lines, top))
+	for i, line := range conf.LinkHistory {
+		conf.LinkHistory[i] = remapLine(oldLines, conf.Lines, line)
+	}
+}
+
+// remapLine returns the index of the line of the reformatted book that
+// shows the same text as the old line. An empty line between paragraphs
+// has the position of the next paragraph, so it is mapped to the empty
+// line before that paragraph, not to its first line
+func remapLine(oldLines, newLines []book.Line, idx int) int {
+	if idx < 0 || idx >= len(oldLines) {
+		return 0
+	}
+	pos := oldLines[idx].Position()
+	line := book.FindLine(newLines, pos)
+	if oldLines[idx].Src == nil {
+		for line > 0 && newLines[line-1].Src == nil && newLines[line-1].Position() == pos {
+			line--
+		}
+	}
+	return line
+}
+
+// visibleLinks returns all links which start in the visible part
+// of the reader in the order they appear on the screen
+func visibleLinks(controls *ControlList, conf *cf.Config) []linkRef {
+	links := make([]linkRef, 0)
+	_, height := controls.reader.Size()
+	top := controls.reader.TopLine()
+	for ind := top; ind < top+height && ind < len(conf.Lines); ind++ {
+		line := &conf.Lines[ind]
+		if line.Para >= len(conf.Book.Paragraphs) {
+			continue
+		}
+		for i, link := range conf.Book.Paragraphs[line.Para].Links {
+			if line.Column(link.Start) != -1 {
+				links = append(links, linkRef{para: line.Para, index: i})
+			}
+		}
+	}
+
+	return links
+}
+
+// selectNextLink highlights the next visible link after the selected one
+func selectNextLink(controls *ControlList, conf *cf.Config) {
+	links := visibleLinks(controls, conf)
+	if len(links) == 0 {
+		conf.SelectedPara = -1
+		return
+	}
+
+	next := 0
+	for i, l := range links {
+		if l.para == conf.SelectedPara && l.index == conf.SelectedLink {
+			next = (i + 1) % len(links)
+			break
+		}
+	}
+	conf.SelectedPara = links[next].para
+	conf.SelectedLink = links[next].index
+}
+
+// followLink jumps to the target of the selected link. If the selected
+// link is not visible the first visible link is used
+func followLink(controls *ControlList, conf *cf.Config) {
+	links := visibleLinks(controls, conf)
+	if len(links) == 0 {
+		return
+	}
+
+	ref := links[0]
+	for _, l := range links {
+		if l.para == conf.SelectedPara && l.index == conf.SelectedLink {
+			ref = l
+			break
+		}
+	}
+
+	target := conf.Book.Paragraphs[ref.para].Links[ref.index].Target
+	para, ok := conf.Book.Anchors[target]
+	if !ok {
+		return
+	}
+
+	conf.LinkHistory = append(conf.LinkHistory, controls.reader.TopLine())
+	conf.SelectedPara = -1
+	controls.reader.SetTopLine(book.FindLine(conf.Lines, book.Position{Para: para}))
+}
+
+// returnFromLink restores the position the last link was followed from
+func returnFromLink(controls *ControlList, conf *cf.Config) {
+	if len(conf.LinkHistory) == 0 {
+		return
+	}
+
+	top := conf.LinkHistory[len(conf.LinkHistory)-1]
+	conf.LinkHistory = conf.LinkHistory[:len(conf.LinkHistory)-1]
+	conf.SelectedPara = -1
+	controls.reader.SetTopLine(top)
+}
@@ -0,0 +1,194 @@
+package scan
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/VladimirMarkelov/termfb2/book"
+	"github.com/VladimirMarkelov/termfb2/common"
+	"io/ioutil"
+	"os"
+	path "path/filepath"
+)
+
+// Progress is called before processing every file found by a scan:
+// done is the number of processed files
+type Progress func(done, total int, fileName string)
+
+// Result is the summary of a directory import
+type Result struct {
+	Added    int
+	Relinked int
+	Skipped  int
+	Failed   int
+	// descriptions of all failures: a file name and the reason
+	Errors []string
+}
+
+// ContentHash returns a hash of the book file content. It is used to
+// detect books that are already in the library under a different path
+func ContentHash(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// FindBooks returns all files in the directory and its sub-directories
+// which extensions are supported by the reader
+func FindBooks(dir string) ([]string, error) {
+	files := make([]string, 0)
+	err := path.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			// unreadable sub-directories are skipped
+			if info != nil && info.IsDir() && filePath != dir {
+				return path.SkipDir
+			}
+			return err
+		}
+		if info.Mode().IsRegular() && book.IsBookFile(filePath) {
+			files = append(files, filePath)
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+// Status is the result of importing a single file
+type Status int
+
+const (
+	// StatusSkipped means that the book is already in the library or
+	// the file is not a book, e.g. an archive without FB2 files
+	StatusSkipped Status = iota
+	// StatusAdded means that a new book has been added to the library
+	StatusAdded
+	// StatusRelinked means that the file is a book from the library that
+	// has been moved, and the book record now points to the file
+	StatusRelinked
+)
+
+// FindMoved looks for a library book which file does not exist anymore and
+// which content hash or document id is the same as the given one. Empty
+// hash or id never match
+func FindMoved(bookDb common.BookDb, hash, docId string) (common.BookRecord, bool) {
+	for _, b := range bookDb.BooksByContent(hash, docId) {
+		if fileMissing(b.FilePath) {
+			return b, true
+		}
+	}
+	return common.BookRecord{}, false
+}
+
+// fileMissing returns true if the file does not exist. Files that cannot
+// be checked, e.g. because of permissions, are considered existing
+func fileMissing(fileName string) bool {
+	_, err := os.Lstat(fileName)
+	return os.IsNotExist(err)
+}
+
+// Relink points the moved book to the new file. The old file is checked
+// again right before the change, so a book is never taken from a file
+// that exists
+func Relink(bookDb common.BookDb, moved common.BookRecord, fileName, hash string) error {
+	if !fileMissing(moved.FilePath) {
+		return fmt.Errorf("the book file '%s' still exists", moved.FilePath)
+	}
+	return bookDb.RelinkBook(moved.FilePath, fileName, hash)
+}
+
+// ImportFile adds a book to the library. If the file is a moved book from
+// the library, the book record is updated instead. Only the book
+// description is parsed
+func ImportFile(bookDb common.BookDb, fileName string) (Status, error) {
+	if _, found := bookDb.BookByFilePath(fileName); found {
+		return StatusSkipped, nil
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return StatusSkipped, err
+	}
+	hash := ContentHash(data)
+	if moved, found := FindMoved(bookDb, hash, ""); found {
+		if err := Relink(bookDb, moved, fileName, hash); err != nil {
+			return StatusSkipped, err
+		}
+		return StatusRelinked, nil
+	}
+	if _, found := bookDb.BookByHash(hash); found {
+		return StatusSkipped, nil
+	}
+
+	l := book.FindLoader(fileName, data)
+	if l == nil {
+		return StatusSkipped, nil
+	}
+	info, err := l.ParseInfo(fileName, data)
+	if err != nil {
+		return StatusSkipped, err
+	}
+	// the file content may change after moving, e.g. if the book was zipped
+	if moved, found := FindMoved(bookDb, "", info.Id); found {
+		if err := Relink(bookDb, moved, fileName, hash); err != nil {
+			return StatusSkipped, err
+		}
+		return StatusRelinked, nil
+	}
+
+	brec := common.BookRecord{
+		FilePath:   fileName,
+		Hash:       hash,
+		DocId:      info.Id,
+		PosVersion: common.POS_VERSION,
+		FirstName:  info.FirstName,
+		LastName:   info.LastName,
+		Title:      info.Title,
+		Sequence:   info.Sequence,
+		SeqNumber:  info.SeqNumber,
+		Language:   info.Language,
+		Genre:      info.Genre,
+	}
+	if err := bookDb.AddBook(&brec); err != nil {
+		return StatusSkipped, err
+	}
+	return StatusAdded, nil
+}
+
+// ImportDir scans the directory recursively and adds all found books to
+// the library. Books which paths or contents are already in the library
+// are skipped, moved books are relinked
+func ImportDir(bookDb common.BookDb, dir string, progress Progress) (Result, error) {
+	var res Result
+	dir, err := path.Abs(dir)
+	if err != nil {
+		return res, err
+	}
+	files, err := FindBooks(dir)
+	if err != nil {
+		return res, err
+	}
+
+	for i, fileName := range files {
+		if progress != nil {
+			progress(i, len(files), fileName)
+		}
+		status, err := ImportFile(bookDb, fileName)
+		switch {
+		case err != nil:
+			res.Failed++
+			res.Errors = append(res.Errors, fmt.Sprintf("%s: %v", fileName, err))
+		case status == StatusAdded:
+			res.Added++
+		case status == StatusRelinked:
+			res.Relinked++
+		default:
+			res.Skipped++
+		}
+	}
+
+	return res, nil
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("added %d, relinked %d, skipped %d, failed %d", r.Added, r.Relinked, r.Skipped, r.Failed)
+}
@@ -0,0 +1,120 @@
+package scan
+
+import (
+	"github.com/VladimirMarkelov/termfb2/common"
+	"github.com/VladimirMarkelov/termfb2/db"
+	"io/ioutil"
+	"os"
+	path "path/filepath"
+	"testing"
+)
+
+// libraries opens empty libraries of all backends in the directory
+func libraries(t *testing.T, dir string) map[string]common.BookDb {
+	scribbleDb, err := db.InitDb(path.Join(dir, "scribble"))
+	if err == nil {
+		err = scribbleDb.ReadDatabase()
+	}
+	if err != nil {
+		t.Fatalf("failed to open scribble library: %v", err)
+	}
+	sqliteDb, err := db.InitSqliteDb(path.Join(dir, "sqlite"))
+	if err == nil {
+		err = sqliteDb.ReadDatabase()
+	}
+	if err != nil {
+		t.Fatalf("failed to open sqlite library: %v", err)
+	}
+	return map[string]common.BookDb{common.DB_SCRIBBLE: scribbleDb, common.DB_SQLITE: sqliteDb}
+}
+
+func writeFile(t *testing.T, fileName, text string) {
+	if err := os.MkdirAll(path.Dir(fileName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fileName, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportDir(t *testing.T) {
+	for name, bookDb := range libraries(t, t.TempDir()) {
+		dir := t.TempDir()
+		a, b := path.Join(dir, "a.txt"), path.Join(dir, "b.txt")
+		writeFile(t, a, "First book.\n")
+		writeFile(t, b, "Second book.\n")
+
+		res, err := ImportDir(bookDb, dir, nil)
+		if err != nil || res.Added != 2 || res.Failed != 0 {
+			t.Fatalf("%s: first import %v, %v, want 2 books added", name, res, err)
+		}
+
+		// a.txt is moved, and c.txt is a copy of b.txt that still exists
+		moved, c := path.Join(dir, "sub", "moved.txt"), path.Join(dir, "c.txt")
+		if err := os.MkdirAll(path.Dir(moved), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(a, moved); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, c, "Second book.\n")
+
+		res, err = ImportDir(bookDb, dir, nil)
+		want := Result{Relinked: 1, Skipped: 2}
+		if err != nil || res.String() != want.String() {
+			t.Errorf("%s: second import %v, %v, want %v", name, res, err, want)
+		}
+		if _, found := bookDb.BookByFilePath(moved); !found {
+			t.Errorf("%s: moved book has not been relinked", name)
+		}
+		if _, found := bookDb.BookByFilePath(a); found {
+			t.Errorf("%s: the old path of the moved book is still in the library", name)
+		}
+		if len(bookDb.BookList()) != 2 {
+			t.Errorf("%s: library has %d books, want 2", name, len(bookDb.BookList()))
+		}
+	}
+}
+
+func TestFindMoved(t *testing.T) {
+	for name, bookDb := range libraries(t, t.TempDir()) {
+		dir := t.TempDir()
+		existing := path.Join(dir, "existing.fb2")
+		writeFile(t, existing, "text")
+		books := []common.BookRecord{
+			{FilePath: existing, Hash: "hash-1", DocId: "doc-1"},
+			{FilePath: path.Join(dir, "missing.fb2"), Hash: "hash-2", DocId: "doc-2"},
+			{FilePath: path.Join(dir, "no-hash.fb2")},
+		}
+		for i := range books {
+			if err := bookDb.AddBook(&books[i]); err != nil {
+				t.Fatalf("%s: failed to add a book: %v", name, err)
+			}
+		}
+
+		tests := []struct {
+			hash, docId string
+			found       string
+		}{
+			{"hash-1", "", ""},
+			{"", "doc-1", ""},
+			{"hash-2", "", "missing.fb2"},
+			{"", "doc-2", "missing.fb2"},
+			{"hash-1", "doc-2", "missing.fb2"},
+			{"", "", ""},
+			{"hash-3", "doc-3", ""},
+		}
+		for _, test := range tests {
+			b, found := FindMoved(bookDb, test.hash, test.docId)
+			if found != (test.found != "") || (found && path.Base(b.FilePath) != test.found) {
+				t.Errorf("%s: FindMoved(%q, %q) = %s, %v, want %q", name, test.hash, test.docId,
+					b.FilePath, found, test.found)
+			}
+		}
+
+		// a book is not relinked from a file that exists
+		if err := Relink(bookDb, books[0], path.Join(dir, "new.fb2"), ""); err == nil {
+			t.Errorf("%s: book relinked from an existing file", name)
+		}
+	}
+}
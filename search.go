@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/VladimirMarkelov/termfb2/book"
+	cf "github.com/VladimirMarkelov/termfb2/config"
+	"sort"
+)
+
+// createSearchDialog asks for a text to look for in the opened book
+func createSearchDialog(controls *ControlList, conf *cf.Config) {
+	createInputDialog("Search", conf.SearchText, func(text string) {
+		runSearch(controls, conf, text)
+	})
+}
+
+// runSearch finds all occurrences of text in the book and scrolls the
+// reader to the first one after the top line
+func runSearch(controls *ControlList, conf *cf.Config, text string) {
+	conf.SearchText = text
+	conf.Matches = book.Search(conf.Book, text)
+	conf.CurrentMatch = -1
+	if text == "" {
+		clearSearch(controls, conf)
+		return
+	}
+
+	jumpToMatch(controls, conf, true)
+	updateTitle(controls, conf)
+}
+
+// clearSearch removes search highlighting
+func clearSearch(controls *ControlList, conf *cf.Config) {
+	conf.SearchText = ""
+	conf.Matches = nil
+	conf.CurrentMatch = -1
+	updateTitle(controls, conf)
+}
+
+// jumpToMatch scrolls the reader to the next or previous search match.
+// If the current match is not visible then the search starts from
+// the top line of the reader. The search wraps at the book end and start
+func jumpToMatch(controls *ControlList, conf *cf.Config, forward bool) {
+	if len(conf.Matches) == 0 {
+		return
+	}
+
+	top := controls.reader.TopLine()
+	_, height := controls.reader.Size()
+	idx := 0
+	found := false
+	if conf.CurrentMatch >= 0 && conf.CurrentMatch < len(conf.Matches) {
+		line := book.FindLine(conf.Lines, conf.Matches[conf.CurrentMatch].Position())
+		if line >= top && line < top+height {
+			found = true
+			if forward {
+				idx = conf.CurrentMatch + 1
+			} else {
+				idx = conf.CurrentMatch - 1
+			}
+		}
+	}
+	if !found {
+		pos := conf.Lines[top].Position()
+		idx = sort.Search(len(conf.Matches), func(i int) bool {
+			return !conf.Matches[i].Position().Less(pos)
+		})
+		if !forward {
+			idx--
+		}
+	}
+
+	if idx >= len(conf.Matches) {
+		idx = 0
+	} else if idx < 0 {
+		idx = len(conf.Matches) - 1
+	}
+
+	conf.CurrentMatch = idx
+	controls.reader.SetTopLine(book.FindLine(conf.Lines, conf.Matches[idx].Position()))
+	updateTitle(controls, conf)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	ui "github.com/VladimirMarkelov/clui"
+	"github.com/VladimirMarkelov/termfb2/book"
+	"github.com/VladimirMarkelov/termfb2/common"
+	cf "github.com/VladimirMarkelov/termfb2/config"
+	term "github.com/nsf/termbox-go"
+	"sort"
+	"strings"
+)
+
+// levels of the library tree rows
+const (
+	treeAuthor = iota
+	treeSeries
+	treeBook
+)
+
+// seriesGroup is the books of an author that belong to the same
+// sequence. Books without a sequence are in the group with an empty name
+type seriesGroup struct {
+	name  string
+	books []common.BookRecord
+}
+
+// authorGroup is all books of an author grouped by sequences
+type authorGroup struct {
+	name   string
+	series []seriesGroup
+}
+
+// treeRow is a row of the library tree: indices of an author group, its
+// sequence and a book. Series and book are -1 for rows of upper levels
+type treeRow struct {
+	level  int
+	author int
+	series int
+	book   int
+}
+
+// sequenceText shows the sequence of the book with the book number in it
+func sequenceText(b common.BookRecord) string {
+	return book.SequenceText(b.Sequence, b.SeqNumber)
+}
+
+// authorName is the name of an author group in the library tree
+func authorName(b common.BookRecord) string {
+	name := strings.TrimSpace(b.LastName)
+	if first := strings.TrimSpace(b.FirstName); first != "" {
+		if name != "" {
+			name += ", "
+		}
+		name += first
+	}
+	if name == "" {
+		return "Unknown author"
+	}
+	return name
+}
+
+// isFinished returns true if the book has been read to the end
+func isFinished(b common.BookRecord) bool {
+	return b.Completed != ""
+}
+
+// groupBooks groups books by authors and then by sequences. Authors and
+// sequences are sorted by name, books without a sequence go after all
+// sequences of the author. Books of a sequence are sorted by their
+// numbers, books without numbers go last
+func groupBooks(books []common.BookRecord) []authorGroup {
+	groups := make([]authorGroup, 0)
+	authors := make(map[string]int)
+	for _, b := range books {
+		name := authorName(b)
+		key := strings.ToLower(name)
+		ai, found := authors[key]
+		if !found {
+			ai = len(groups)
+			authors[key] = ai
+			groups = append(groups, authorGroup{name: name})
+		}
+
+		g := &groups[ai]
+		seq := strings.TrimSpace(b.Sequence)
+		si := -1
+		for i, s := range g.series {
+			if strings.EqualFold(s.name, seq) {
+				si = i
+				break
+			}
+		}
+		if si == -1 {
+			si = len(g.series)
+			g.series = append(g.series, seriesGroup{name: seq})
+		}
+		g.series[si].books = append(g.series[si].books, b)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return strings.ToLower(groups[i].name) < strings.ToLower(groups[j].name)
+	})
+	for _, g := range groups {
+		sort.SliceStable(g.series, func(i, j int) bool {
+			s1, s2 := g.series[i].name, g.series[j].name
+			if s1 == "" || s2 == "" {
+				return s2 == "" && s1 != ""
+			}
+			return strings.ToLower(s1) < strings.ToLower(s2)
+		})
+		for _, s := range g.series {
+			sort.SliceStable(s.books, func(i, j int) bool {
+				n1, n2 := s.books[i].SeqNumber, s.books[j].SeqNumber
+				if n1 != n2 && (n1 == 0 || n2 == 0) {
+					return n2 == 0
+				}
+				if n1 != n2 {
+					return n1 < n2
+				}
+				return strings.ToLower(s.books[i].Title) < strings.ToLower(s.books[j].Title)
+			})
+		}
+	}
+	return groups
+}
+
+// nextUnread returns the index of the first book of the sequence that has
+// not been read to the end, -1 if all books are finished
+func (s *seriesGroup) nextUnread() int {
+	for i, b := range s.books {
+		if !isFinished(b) {
+			return i
+		}
+	}
+	return -1
+}
+
+// progress shows how many books of the group are finished, e.g. "2/5"
+func progress(books []common.BookRecord) string {
+	finished := 0
+	for _, b := range books {
+		if isFinished(b) {
+			finished++
+		}
+	}
+	return fmt.Sprintf("%d/%d", finished, len(books))
+}
+
+// authorBooks returns all books of the author group
+func (g *authorGroup) authorBooks() []common.BookRecord {
+	books := make([]common.BookRecord, 0)
+	for _, s := range g.series {
+		books = append(books, s.books...)
+	}
+	return books
+}
+
+// groupKey identifies an author or a sequence row to remember whether it
+// is collapsed
+func groupKey(groups []authorGroup, row treeRow) string {
+	key := groups[row.author].name
+	if row.level == treeSeries {
+		key += "\n" + groups[row.author].series[row.series].name
+	}
+	return key
+}
+
+// treeRows makes the rows of the library tree. Children of collapsed
+// authors and sequences are skipped. Books without a sequence are shown
+// right under their author
+func treeRows(groups []authorGroup, collapsed map[string]bool) []treeRow {
+	rows := make([]treeRow, 0)
+	for ai, g := range groups {
+		row := treeRow{level: treeAuthor, author: ai, series: -1, book: -1}
+		rows = append(rows, row)
+		if collapsed[groupKey(groups, row)] {
+			continue
+		}
+		for si, s := range g.series {
+			level := treeBook
+			if s.name != "" {
+				row = treeRow{level: treeSeries, author: ai, series: si, book: -1}
+				rows = append(rows, row)
+				if collapsed[groupKey(groups, row)] {
+					continue
+				}
+			} else {
+				level = treeSeries
+			}
+			for bi := range s.books {
+				rows = append(rows, treeRow{level: level, author: ai, series: si, book: bi})
+			}
+		}
+	}
+	return rows
+}
+
+// Generate a text for TableView control that displays the library tree
+func getTreeColumnText(groups []authorGroup, collapsed map[string]bool, row treeRow, col int) string {
+	g := &groups[row.author]
+	indent := strings.Repeat("  ", row.level)
+	marker := "[-] "
+	if row.book == -1 && collapsed[groupKey(groups, row)] {
+		marker = "[+] "
+	}
+
+	text := ""
+	switch {
+	case row.book != -1:
+		s := &g.series[row.series]
+		b := s.books[row.book]
+		switch col {
+		case 0:
+			text = indent + b.Title
+			if s.name != "" && b.SeqNumber != 0 {
+				text = fmt.Sprintf("%s#%d %s", indent, b.SeqNumber, b.Title)
+			}
+		case 1:
+			text = getBookColumnText(b, 2)
+		case 2:
+			if s.name != "" && s.nextUnread() == row.book {
+				text = "next"
+			}
+		case 3:
+			text = b.FilePath
+		}
+	case row.series != -1:
+		s := &g.series[row.series]
+		switch col {
+		case 0:
+			text = indent + marker + s.name
+		case 1:
+			text = progress(s.books)
+		case 2:
+			if next := s.nextUnread(); next != -1 {
+				text = sequenceText(s.books[next]) + ": " + s.books[next].Title
+			} else {
+				text = "finished"
+			}
+		}
+	default:
+		switch col {
+		case 0:
+			text = marker + g.name
+		case 1:
+			text = progress(g.authorBooks())
+		}
+	}
+
+	return text
+}
+
+// createTreeDialog shows the filtered library books grouped by authors
+// and sequences. Enter collapses or expands the selected author or
+// sequence, or opens the selected book and closes the library
+func createTreeDialog(controls *ControlList, conf *cf.Config) {
+	groups := groupBooks(conf.DbDriver.FilteredBooks())
+	collapsed := make(map[string]bool)
+	rows := treeRows(groups, collapsed)
+
+	dlg := ui.AddWindow(0, 0, 12, 7, "Authors and sequences")
+	dlg.SetPack(ui.Vertical)
+	dlg.SetModal(true)
+
+	table := ui.CreateTableView(dlg, minWidth, minHeight, 1)
+	ui.ActivateControl(dlg, table)
+	table.SetShowLines(true)
+	dlg.SetMaximized(true)
+
+	table.SetRowCount(len(rows))
+	cols := []ui.Column{
+		ui.Column{Title: "Author / Sequence / Book", Width: 40, Alignment: ui.AlignLeft},
+		ui.Column{Title: "Done", Width: 7, Alignment: ui.AlignRight},
+		ui.Column{Title: "Next unread", Width: 30, Alignment: ui.AlignLeft},
+		ui.Column{Title: "FilePath", Width: 100, Alignment: ui.AlignLeft},
+	}
+	table.SetColumns(cols)
+
+	// Escape returns to the library
+	dlg.OnKeyDown(func(ev ui.Event, data interface{}) bool {
+		switch ev.Key {
+		case term.KeyEsc:
+			go ui.PutEvent(ui.Event{Type: ui.EventCloseWindow})
+			return true
+		case term.KeyEnter:
+			idx := table.SelectedRow()
+			if idx < 0 || idx >= len(rows) {
+				return true
+			}
+			row := rows[idx]
+			if row.book == -1 {
+				key := groupKey(groups, row)
+				collapsed[key] = !collapsed[key]
+				rows = treeRows(groups, collapsed)
+				table.SetRowCount(len(rows))
+				// rows before the toggled one are the same, so it keeps
+				// its index. The selection must not point past the end
+				// of the shortened list
+				if idx >= len(rows) {
+					idx = len(rows) - 1
+				}
+				table.SetSelectedRow(idx)
+				return true
+			}
+
+			b := groups[row.author].series[row.series].books[row.book]
+			ui.WindowManager().DestroyWindow(dlg)
+			if openBook(controls, conf, b) {
+				// the library is closed the same way as after opening
+				// a book from it
+				go ui.PutEvent(ui.Event{Type: ui.EventCloseWindow})
+			}
+			return true
+		}
+		return false
+	}, nil)
+
+	table.OnDrawCell(func(info *ui.ColumnDrawInfo) {
+		if info.Row >= len(rows) {
+			return
+		}
+		info.Text = getTreeColumnText(groups, collapsed, rows[info.Row], info.Col)
+	})
+}
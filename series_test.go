@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"github.com/VladimirMarkelov/termfb2/common"
+	"reflect"
+	"testing"
+)
+
+func treeBooks() []common.BookRecord {
+	return []common.BookRecord{
+		{LastName: "Strugatsky", FirstName: "Arkady", Sequence: "Noon", SeqNumber: 2, Title: "B"},
+		{LastName: "Strugatsky", FirstName: "Arkady", Title: "Z"},
+		{LastName: "strugatsky", FirstName: "arkady", Sequence: "noon", SeqNumber: 1, Title: "A",
+			Completed: "2024-01-01T10:00:00Z"},
+		{LastName: "Strugatsky", FirstName: "Arkady", Sequence: "Noon", Title: "C"},
+		{LastName: "Asimov", FirstName: "Isaac", Sequence: "Foundation", SeqNumber: 1, Title: "Foundation"},
+		{Title: "Anonymous"},
+	}
+}
+
+// groupNames lists groups as "author/sequence/number title" lines
+func groupNames(groups []authorGroup) []string {
+	names := make([]string, 0)
+	for _, g := range groups {
+		for _, s := range g.series {
+			for _, b := range s.books {
+				names = append(names, fmt.Sprintf("%s/%s/%d %s", g.name, s.name, b.SeqNumber, b.Title))
+			}
+		}
+	}
+	return names
+}
+
+func TestAuthorName(t *testing.T) {
+	tests := []struct {
+		first, last string
+		name        string
+	}{
+		{"Arkady", "Strugatsky", "Strugatsky, Arkady"},
+		{"", " Strugatsky ", "Strugatsky"},
+		{"Arkady", "", "Arkady"},
+		{" ", "", "Unknown author"},
+	}
+	for _, test := range tests {
+		b := common.BookRecord{FirstName: test.first, LastName: test.last}
+		if name := authorName(b); name != test.name {
+			t.Errorf("authorName(%q, %q) = %q, want %q", test.first, test.last, name, test.name)
+		}
+	}
+}
+
+func TestGroupBooks(t *testing.T) {
+	// authors and sequences are grouped ignoring case, books without a
+	// sequence go after sequences, books without a number go last
+	want := []string{
+		"Asimov, Isaac/Foundation/1 Foundation",
+		"Strugatsky, Arkady/Noon/1 A",
+		"Strugatsky, Arkady/Noon/2 B",
+		"Strugatsky, Arkady/Noon/0 C",
+		"Strugatsky, Arkady//0 Z",
+		"Unknown author//0 Anonymous",
+	}
+	if names := groupNames(groupBooks(treeBooks())); !reflect.DeepEqual(names, want) {
+		t.Errorf("groupBooks = %q, want %q", names, want)
+	}
+}
+
+func TestTreeRows(t *testing.T) {
+	groups := groupBooks(treeBooks())
+	tests := []struct {
+		name      string
+		collapsed map[string]bool
+		rows      []treeRow
+	}{
+		{"expanded", map[string]bool{}, []treeRow{
+			{treeAuthor, 0, -1, -1},
+			{treeSeries, 0, 0, -1},
+			{treeBook, 0, 0, 0},
+			{treeAuthor, 1, -1, -1},
+			{treeSeries, 1, 0, -1},
+			{treeBook, 1, 0, 0},
+			{treeBook, 1, 0, 1},
+			{treeBook, 1, 0, 2},
+			// books without a sequence are right under the author
+			{treeSeries, 1, 1, 0},
+			{treeAuthor, 2, -1, -1},
+			{treeSeries, 2, 0, 0},
+		}},
+		{"collapsed", map[string]bool{"Asimov, Isaac": true, "Strugatsky, Arkady\nNoon": true}, []treeRow{
+			{treeAuthor, 0, -1, -1},
+			{treeAuthor, 1, -1, -1},
+			{treeSeries, 1, 0, -1},
+			{treeSeries, 1, 1, 0},
+			{treeAuthor, 2, -1, -1},
+			{treeSeries, 2, 0, 0},
+		}},
+	}
+	for _, test := range tests {
+		if rows := treeRows(groups, test.collapsed); !reflect.DeepEqual(rows, test.rows) {
+			t.Errorf("%s: treeRows = %v, want %v", test.name, rows, test.rows)
+		}
+	}
+}
+
+func TestNextUnread(t *testing.T) {
+	tests := []struct {
+		completed []bool
+		next      int
+		progress  string
+	}{
+		{[]bool{}, -1, "0/0"},
+		{[]bool{false, false}, 0, "0/2"},
+		{[]bool{true, false, false}, 1, "1/3"},
+		{[]bool{true, false, true}, 1, "2/3"},
+		{[]bool{true, true}, -1, "2/2"},
+	}
+	for _, test := range tests {
+		s := seriesGroup{name: "Noon"}
+		for _, done := range test.completed {
+			b := common.BookRecord{}
+			if done {
+				b.Completed = "2024-01-01T10:00:00Z"
+			}
+			s.books = append(s.books, b)
+		}
+		if next := s.nextUnread(); next != test.next {
+			t.Errorf("nextUnread of %v = %d, want %d", test.completed, next, test.next)
+		}
+		if p := progress(s.books); p != test.progress {
+			t.Errorf("progress of %v = %q, want %q", test.completed, p, test.progress)
+		}
+	}
+}
+
+func TestTreeColumnText(t *testing.T) {
+	groups := groupBooks(treeBooks())
+	collapsed := map[string]bool{"Asimov, Isaac": true}
+	tests := []struct {
+		row  treeRow
+		col  int
+		text string
+	}{
+		{treeRow{treeAuthor, 0, -1, -1}, 0, "[+] Asimov, Isaac"},
+		{treeRow{treeAuthor, 1, -1, -1}, 0, "[-] Strugatsky, Arkady"},
+		{treeRow{treeAuthor, 1, -1, -1}, 1, "1/4"},
+		{treeRow{treeSeries, 1, 0, -1}, 0, "  [-] Noon"},
+		{treeRow{treeSeries, 1, 0, -1}, 1, "1/3"},
+		{treeRow{treeSeries, 1, 0, -1}, 2, "Noon #2: B"},
+		{treeRow{treeBook, 1, 0, 0}, 0, "    #1 A"},
+		{treeRow{treeBook, 1, 0, 1}, 2, "next"},
+		{treeRow{treeBook, 1, 0, 2}, 0, "    C"},
+		{treeRow{treeBook, 1, 0, 2}, 2, ""},
+		{treeRow{treeSeries, 1, 1, 0}, 0, "  Z"},
+	}
+	for _, test := range tests {
+		if text := getTreeColumnText(groups, collapsed, test.row, test.col); text != test.text {
+			t.Errorf("row %v, column %d: %q, want %q", test.row, test.col, text, test.text)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/VladimirMarkelov/termfb2/book"
+	"github.com/VladimirMarkelov/termfb2/common"
+	cf "github.com/VladimirMarkelov/termfb2/config"
+	"time"
+)
+
+const (
+	// the reading session ends if the reader is not scrolled for this time
+	sessionIdle = 5 * time.Minute
+	// how often the current session is written to the library
+	sessionSaveInterval = time.Minute
+)
+
+// startSession begins a new reading session at the top line of the
+// reader. Sessions are recorded only for books in the library
+func startSession(conf *cf.Config) {
+	conf.Session = nil
+	if !conf.UseDb || conf.LastFile == "" || conf.LastPosition >= len(conf.Lines) {
+		return
+	}
+
+	pos := conf.Lines[conf.LastPosition].Position()
+	now := time.Now()
+	conf.Session = &common.Session{
+		Start:       now.Format(time.RFC3339),
+		End:         now.Format(time.RFC3339),
+		StartPara:   pos.Para,
+		StartOffset: pos.Offset,
+		EndPara:     pos.Para,
+		EndOffset:   pos.Offset,
+	}
+	conf.SessionSaved = now
+}
+
+// trackSession updates the current session after the reader top line
+// changes from the line 'from' to the line 'to'. Only scrolling forward
+// by at most a page counts as reading, longer moves are jumps. If the
+// reader has been idle for too long, a new session starts
+func trackSession(controls *ControlList, conf *cf.Config, from, to int) {
+	if conf.Session == nil || to < 0 || to >= len(conf.Lines) {
+		return
+	}
+
+	now := time.Now()
+	if end, err := time.Parse(time.RFC3339, conf.Session.End); err == nil && now.Sub(end) > sessionIdle {
+		// errors are reported when the book is closed
+		saveSession(conf)
+		startSession(conf)
+		if conf.Session == nil {
+			return
+		}
+	}
+
+	s := conf.Session
+	pos := conf.Lines[to].Position()
+	_, height := controls.reader.Size()
+	if to > from && to-from <= height {
+		s.Words += conf.Book.CountWords(book.Position{Para: s.EndPara, Offset: s.EndOffset}, pos)
+	}
+	s.EndPara = pos.Para
+	s.EndOffset = pos.Offset
+	s.End = now.Format(time.RFC3339)
+
+	if now.Sub(conf.SessionSaved) >= sessionSaveInterval {
+		saveSession(conf)
+	}
+}
+
+// saveSession writes the current session to the library. The session ends
+// now unless the reader is idle. Sessions without reading are not saved
+func saveSession(conf *cf.Config) error {
+	s := conf.Session
+	if s == nil || (s.Words == 0 && s.StartPara == s.EndPara && s.StartOffset == s.EndOffset) {
+		return nil
+	}
+
+	now := time.Now()
+	if end, err := time.Parse(time.RFC3339, s.End); err == nil && now.Sub(end) <= sessionIdle {
+		s.End = now.Format(time.RFC3339)
+	}
+	conf.SessionSaved = now
+	return conf.DbDriver.SaveSession(conf.LastFile, *s)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	ui "github.com/VladimirMarkelov/clui"
+	"github.com/VladimirMarkelov/termfb2/book"
+	"github.com/VladimirMarkelov/termfb2/common"
+	cf "github.com/VladimirMarkelov/termfb2/config"
+	"github.com/VladimirMarkelov/termfb2/stats"
+	term "github.com/nsf/termbox-go"
+	"strings"
+	"time"
+)
+
+// minMeasuredTime is the total time of reading sessions after which the
+// measured reading speed is used instead of the configured one
+const minMeasuredTime = 10 * time.Minute
+
+// periodTitles are names of statistics periods shown to a user
+var periodTitles = map[string]string{
+	stats.Today:     "Today",
+	stats.ThisWeek:  "This week",
+	stats.ThisMonth: "This month",
+	stats.AllTime:   "All time",
+}
+
+// periodColumns are column titles of the period statistics table
+var periodColumns = []string{"Period", "Sessions", "Time", "Pages", "Pages/session", "Finished"}
+
+// historyTitles are titles of the reading history sections
+var historyTitles = []string{"Day", "Week", "Month"}
+
+// historyPeriods returns the reading history sections in the order of
+// historyTitles
+func historyPeriods(sum stats.Summary) [][]stats.Period {
+	return [][]stats.Period{sum.Days, sum.Weeks, sum.Months}
+}
+
+// statsRow is a row of the statistics table: a period or a title of
+// a reading history section if the period is nil
+type statsRow struct {
+	title  string
+	period *stats.Period
+}
+
+// statsRows returns the periods that include the current time followed
+// by the reading history
+func statsRows(sum stats.Summary) []statsRow {
+	rows := make([]statsRow, 0)
+	for i := range sum.Periods {
+		rows = append(rows, statsRow{period: &sum.Periods[i]})
+	}
+	for i, periods := range historyPeriods(sum) {
+		rows = append(rows, statsRow{title: "By " + strings.ToLower(historyTitles[i])})
+		for j := range periods {
+			rows = append(rows, statsRow{period: &periods[j]})
+		}
+	}
+	return rows
+}
+
+// periodColumnText returns the text of a period statistics table cell.
+// History periods are named by their start date
+func periodColumnText(p stats.Period, col int) string {
+	switch col {
+	case 0:
+		if title, ok := periodTitles[p.Name]; ok {
+			return title
+		}
+		return p.Name
+	case 1:
+		return fmt.Sprintf("%d", p.Sessions)
+	case 2:
+		return stats.FormatDuration(time.Duration(p.Seconds) * time.Second)
+	case 3:
+		return fmt.Sprintf("%d", p.Pages)
+	case 4:
+		if p.Sessions == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f", float64(p.Words)/float64(p.Sessions)/float64(common.WORDS_PER_PAGE))
+	case 5:
+		return fmt.Sprintf("%d", p.Finished)
+	}
+	return ""
+}
+
+// speedText describes the average reading speed and the time left to
+// finish the current book. wordsLeft is negative if no book is opened
+func speedText(sum stats.Summary, wordsLeft int) []string {
+	if sum.WordsPerMinute <= 0 {
+		return []string{"Average speed: unknown"}
+	}
+	lines := []string{fmt.Sprintf("Average speed: %.0f words per minute", sum.WordsPerMinute)}
+	if wordsLeft >= 0 {
+		lines = append(lines, fmt.Sprintf("Time left for the current book: %s (%d pages)",
+			stats.FormatDuration(sum.TimeLeft(wordsLeft)), wordsLeft/common.WORDS_PER_PAGE))
+	}
+	return lines
+}
+
+// readingSpeed returns the speed measured from reading sessions or the
+// configured speed if not enough sessions have been recorded
+func readingSpeed(conf *cf.Config, sum stats.Summary) float64 {
+	measured := time.Duration(sum.Total().Seconds) * time.Second
+	if sum.WordsPerMinute > 0 && measured >= minMeasuredTime {
+		return sum.WordsPerMinute
+	}
+	return float64(conf.ReadingSpeed)
+}
+
+// updateReadingSpeed recalculates the speed used to estimate the time
+// left in the reader title
+func updateReadingSpeed(conf *cf.Config) {
+	conf.WordsPerMinute = float64(conf.ReadingSpeed)
+	if conf.UseDb {
+		conf.WordsPerMinute = readingSpeed(conf, stats.Collect(conf.DbDriver, time.Now()))
+	}
+}
+
+// timeLeftText estimates the time left to finish the current chapter and
+// the book, e.g. "~1h20m left in chapter / 6h left in book". The chapter
+// is skipped if it is the last one
+func timeLeftText(conf *cf.Config) string {
+	top := conf.LastPosition
+	if conf.WordsPerMinute <= 0 || top >= len(conf.WordsLeft) || conf.WordsLeft[top] == 0 {
+		return ""
+	}
+
+	duration := func(words int) string {
+		return stats.FormatDuration(time.Duration(float64(words) / conf.WordsPerMinute * float64(time.Minute)))
+	}
+	bookLeft := conf.WordsLeft[top]
+	text := "~"
+	if idx := currentChapter(conf); idx != -1 && idx+1 < len(conf.Book.Toc) {
+		end := book.FindLine(conf.Lines, book.Position{Para: conf.Book.Toc[idx+1].Para})
+		text += duration(bookLeft-conf.WordsLeft[end]) + " left in chapter / "
+	}
+	return text + duration(bookLeft) + " left in book"
+}
+
+// createStatsDialog shows the reading statistics of the library and the
+// time left to finish the opened book. The current session is saved
+// first, so the statistics include it
+func createStatsDialog(controls *ControlList, conf *cf.Config) {
+	if !conf.UseDb {
+		return
+	}
+	if err := saveSession(conf); err != nil {
+		showError("Library error", fmt.Sprintf("Failed to save reading session: %v", err))
+	}
+
+	sum := stats.Collect(conf.DbDriver, time.Now())
+	conf.WordsPerMinute = readingSpeed(conf, sum)
+	wordsLeft := -1
+	if conf.LastFile != "" && conf.LastPosition < len(conf.Lines) {
+		pos := conf.Lines[conf.LastPosition].Position()
+		wordsLeft = conf.Book.CountWords(pos, conf.Book.TextEnd())
+	}
+
+	dlg := ui.AddWindow(0, 0, 12, 7, "Reading statistics")
+	dlg.SetPack(ui.Vertical)
+	dlg.SetModal(true)
+
+	table := ui.CreateTableView(dlg, minWidth, minHeight, 1)
+	ui.ActivateControl(dlg, table)
+	table.SetShowLines(true)
+	text := speedText(sum, wordsLeft)
+	label := ui.CreateLabel(dlg, minWidth, len(text), "", ui.Fixed)
+	label.SetMultiline(true)
+	label.SetTitle(strings.Join(text, "\n"))
+	dlg.SetMaximized(true)
+
+	cols := make([]ui.Column, 0, len(periodColumns))
+	for i, title := range periodColumns {
+		align := ui.AlignRight
+		if i == 0 {
+			align = ui.AlignLeft
+		}
+		cols = append(cols, ui.Column{Title: title, Width: 13, Alignment: align})
+	}
+	table.SetColumns(cols)
+	rows := statsRows(sum)
+	table.SetRowCount(len(rows))
+
+	dlg.OnKeyDown(func(ev ui.Event, data interface{}) bool {
+		if ev.Key == term.KeyEsc || ev.Key == term.KeyEnter || conf.ActionForKey(cf.CtxReader, ev.Key, ev.Ch) == cf.ActStats {
+			go ui.PutEvent(ui.Event{Type: ui.EventCloseWindow})
+			return true
+		}
+		return false
+	}, nil)
+
+	table.OnDrawCell(func(info *ui.ColumnDrawInfo) {
+		if info.Row >= len(rows) {
+			return
+		}
+		row := rows[info.Row]
+		if row.period != nil {
+			info.Text = periodColumnText(*row.period, info.Col)
+		} else if info.Col == 0 {
+			info.Text = row.title
+		}
+	})
+}
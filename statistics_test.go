@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/VladimirMarkelov/termfb2/book"
+	cf "github.com/VladimirMarkelov/termfb2/config"
+	"github.com/VladimirMarkelov/termfb2/stats"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// chaptersConfig opens a book of two chapters: 601 and 401 words with
+// their titles
+func chaptersConfig() *cf.Config {
+	b := &book.Book{
+		Paragraphs: []book.Paragraph{
+			{Kind: book.KindTitle, Text: "One"},
+			{Kind: book.KindText, Text: strings.Repeat("word ", 600)},
+			{Kind: book.KindTitle, Text: "Two"},
+			{Kind: book.KindText, Text: strings.Repeat("word ", 400)},
+		},
+		Toc:       []book.TocItem{{Title: "One", Para: 0}, {Title: "Two", Para: 2}},
+		NotesPara: -1,
+	}
+	conf := &cf.Config{Book: b}
+	formatBook(conf, 80)
+	return conf
+}
+
+func TestTimeLeftText(t *testing.T) {
+	conf := chaptersConfig()
+	second := book.FindLine(conf.Lines, book.Position{Para: 2})
+	tests := []struct {
+		name  string
+		top   int
+		speed float64
+		text  string
+	}{
+		{"first chapter", 0, 10, "~1h left in chapter / 1h40m left in book"},
+		// the chapter is skipped if it is the last one
+		{"last chapter", second, 10, "~40m left in book"},
+		{"end", len(conf.Lines) - 1, 10, ""},
+		{"unknown speed", 0, 0, ""},
+	}
+	for _, test := range tests {
+		conf.LastPosition, conf.WordsPerMinute = test.top, test.speed
+		if text := timeLeftText(conf); text != test.text {
+			t.Errorf("%s: timeLeftText = %q, want %q", test.name, text, test.text)
+		}
+	}
+}
+
+func TestSpeedText(t *testing.T) {
+	tests := []struct {
+		name      string
+		speed     float64
+		wordsLeft int
+		lines     []string
+	}{
+		{"unknown", 0, 1000, []string{"Average speed: unknown"}},
+		{"no book", 199.6, -1, []string{"Average speed: 200 words per minute"}},
+		{"book", 200, 30000, []string{"Average speed: 200 words per minute",
+			"Time left for the current book: 2h30m (120 pages)"}},
+	}
+	for _, test := range tests {
+		lines := speedText(stats.Summary{WordsPerMinute: test.speed}, test.wordsLeft)
+		if !reflect.DeepEqual(lines, test.lines) {
+			t.Errorf("%s: speedText = %q, want %q", test.name, lines, test.lines)
+		}
+	}
+}
+
+func TestReadingSpeed(t *testing.T) {
+	conf := &cf.Config{ReadingSpeed: 200}
+	tests := []struct {
+		name    string
+		seconds int64
+		speed   float64
+		want    float64
+	}{
+		{"nothing read", 0, 0, 200},
+		// a few short sessions do not measure the speed well
+		{"short", 599, 300, 200},
+		{"measured", 600, 300, 300},
+	}
+	for _, test := range tests {
+		sum := stats.Summary{Periods: []stats.Period{{Name: stats.AllTime, Seconds: test.seconds}},
+			WordsPerMinute: test.speed}
+		if speed := readingSpeed(conf, sum); speed != test.want {
+			t.Errorf("%s: readingSpeed = %v, want %v", test.name, speed, test.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package stats
+
+import (
+	"fmt"
+	"github.com/VladimirMarkelov/termfb2/common"
+	"time"
+)
+
+// Period is the reading statistics for a period of time
+type Period struct {
+	Name     string `json:"name"`
+	Sessions int    `json:"sessions"`
+	// total duration of the sessions
+	Seconds  int64 `json:"seconds"`
+	Words    int   `json:"words"`
+	Pages    int   `json:"pages"`
+	Finished int   `json:"finished"`
+}
+
+// Summary is the reading statistics of the whole library
+type Summary struct {
+	// today, this week, this month and all time
+	Periods []Period `json:"periods"`
+	// reading history by days, weeks and months, the latest period first.
+	// A period name is its first day (YYYY-MM-DD) or month (YYYY-MM)
+	Days   []Period `json:"days"`
+	Weeks  []Period `json:"weeks"`
+	Months []Period `json:"months"`
+	// average reading speed, 0 if nothing has been read yet
+	WordsPerMinute float64 `json:"wordsPerMinute"`
+}
+
+// period names in the order they are shown
+const (
+	Today     = "today"
+	ThisWeek  = "week"
+	ThisMonth = "month"
+	AllTime   = "total"
+)
+
+// the number of periods in the reading history
+const (
+	HistoryDays   = 14
+	HistoryWeeks  = 8
+	HistoryMonths = 12
+)
+
+// history is a list of consecutive periods, the latest first
+type history struct {
+	periods []Period
+	starts  []time.Time
+}
+
+// newHistory makes count periods named by the start time in the layout.
+// start returns the start time of the i-th period from the latest one
+func newHistory(count int, layout string, start func(i int) time.Time) *history {
+	h := &history{periods: make([]Period, count), starts: make([]time.Time, count)}
+	for i := range h.periods {
+		h.starts[i] = start(i)
+		h.periods[i].Name = h.starts[i].Format(layout)
+	}
+	return h
+}
+
+// find returns the period that includes the time, nil if the time is
+// before all periods
+func (h *history) find(t time.Time) *Period {
+	for i, start := range h.starts {
+		if !t.Before(start) {
+			return &h.periods[i]
+		}
+	}
+	return nil
+}
+
+// periodStarts returns the start time of every period. All time period
+// starts at zero time
+func periodStarts(now time.Time) []time.Time {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	// weeks start on Monday
+	weekday := (int(day.Weekday()) + 6) % 7
+	return []time.Time{
+		day,
+		day.AddDate(0, 0, -weekday),
+		time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()),
+		time.Time{},
+	}
+}
+
+// Collect calculates the reading statistics of all library books for the
+// periods that include the time now and the reading history before it.
+// Sessions are counted in the period they start
+func Collect(bookDb common.BookDb, now time.Time) Summary {
+	starts := periodStarts(now)
+	sum := Summary{Periods: []Period{{Name: Today}, {Name: ThisWeek}, {Name: ThisMonth}, {Name: AllTime}}}
+	histories := []*history{
+		newHistory(HistoryDays, "2006-01-02", func(i int) time.Time { return starts[0].AddDate(0, 0, -i) }),
+		newHistory(HistoryWeeks, "2006-01-02", func(i int) time.Time { return starts[1].AddDate(0, 0, -7*i) }),
+		newHistory(HistoryMonths, "2006-01", func(i int) time.Time { return starts[2].AddDate(0, -i, 0) }),
+	}
+
+	var seconds int64
+	words := 0
+	for _, b := range bookDb.BookList() {
+		completed, err := time.Parse(time.RFC3339, b.Completed)
+		for i := range sum.Periods {
+			if err == nil && !completed.Before(starts[i]) {
+				sum.Periods[i].Finished++
+			}
+		}
+		for _, h := range histories {
+			if p := h.find(completed); err == nil && p != nil {
+				p.Finished++
+			}
+		}
+
+		for _, s := range bookDb.Sessions(b.FilePath) {
+			start, err := time.Parse(time.RFC3339, s.Start)
+			if err != nil {
+				continue
+			}
+			end, err := time.Parse(time.RFC3339, s.End)
+			if err != nil || end.Before(start) {
+				end = start
+			}
+			length := int64(end.Sub(start) / time.Second)
+			periods := make([]*Period, 0, len(sum.Periods)+len(histories))
+			for i := range sum.Periods {
+				if !start.Before(starts[i]) {
+					periods = append(periods, &sum.Periods[i])
+				}
+			}
+			for _, h := range histories {
+				if p := h.find(start); p != nil {
+					periods = append(periods, p)
+				}
+			}
+			for _, p := range periods {
+				p.Sessions += s.SessionCount()
+				p.Seconds += length
+				p.Words += s.Words
+			}
+			seconds += length
+			words += s.Words
+		}
+	}
+
+	sum.Days = histories[0].periods
+	sum.Weeks = histories[1].periods
+	sum.Months = histories[2].periods
+	for _, list := range [][]Period{sum.Periods, sum.Days, sum.Weeks, sum.Months} {
+		for i := range list {
+			list[i].Pages = list[i].Words / common.WORDS_PER_PAGE
+		}
+	}
+	if seconds > 0 {
+		sum.WordsPerMinute = float64(words) * 60 / float64(seconds)
+	}
+	return sum
+}
+
+// Total returns the statistics of all time
+func (sum *Summary) Total() Period {
+	return sum.Periods[len(sum.Periods)-1]
+}
+
+// TimeLeft returns the time needed to read the words at the average
+// speed. It returns 0 if the speed is unknown
+func (sum *Summary) TimeLeft(words int) time.Duration {
+	if sum.WordsPerMinute <= 0 {
+		return 0
+	}
+	return time.Duration(float64(words) / sum.WordsPerMinute * float64(time.Minute))
+}
+
+// FormatDuration makes a short text of a duration with minute precision,
+// e.g. "2h05m", "6h" or "40m"
+func FormatDuration(d time.Duration) string {
+	minutes := int64(d.Round(time.Minute) / time.Minute)
+	switch {
+	case minutes < 60:
+		return fmt.Sprintf("%dm", minutes)
+	case minutes%60 == 0:
+		return fmt.Sprintf("%dh", minutes/60)
+	}
+	return fmt.Sprintf("%dh%02dm", minutes/60, minutes%60)
+}
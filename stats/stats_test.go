@@ -0,0 +1,160 @@
+package stats
+
+import (
+	"github.com/VladimirMarkelov/termfb2/common"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// library keeps books and their sessions in memory. Other methods of the
+// library must not be called
+type library struct {
+	common.BookDb
+	books    []common.BookRecord
+	sessions map[string][]common.Session
+}
+
+func (l *library) BookList() []common.BookRecord {
+	return l.books
+}
+
+func (l *library) Sessions(bookPath string) []common.Session {
+	return l.sessions[bookPath]
+}
+
+// session makes a session that starts at the time and lasts the number of
+// minutes
+func session(start string, minutes, words, merged int) common.Session {
+	t, _ := time.Parse(time.RFC3339, start)
+	end := t.Add(time.Duration(minutes) * time.Minute).Format(time.RFC3339)
+	return common.Session{Start: start, End: end, Words: words, Merged: merged}
+}
+
+// periods makes a history of count periods named by their start, the
+// latest first
+func periods(count int, layout string, start func(i int) time.Time) []Period {
+	list := make([]Period, count)
+	for i := range list {
+		list[i].Name = start(i).Format(layout)
+	}
+	return list
+}
+
+func TestCollect(t *testing.T) {
+	// Wednesday, the week starts on Monday, June 3, and the month starts
+	// on Saturday, June 1
+	now := time.Date(2024, 6, 5, 15, 0, 0, 0, time.UTC)
+	today := time.Date(2024, 6, 5, 0, 0, 0, 0, time.UTC)
+	lib := &library{
+		books: []common.BookRecord{
+			{FilePath: "a", Completed: "2024-06-05T10:00:00Z"},
+			{FilePath: "b", Completed: "2024-05-15T10:00:00Z"},
+			{FilePath: "c"},
+		},
+		sessions: map[string][]common.Session{
+			"a": {
+				session("2024-06-05T09:00:00Z", 30, 500, 0),
+				// the start of the week
+				session("2024-06-03T00:00:00Z", 10, 250, 0),
+				// a session is counted in the period it starts
+				session("2024-06-02T23:59:00Z", 20, 1000, 0),
+			},
+			"b": {
+				// the end before the start makes an empty session
+				{Start: "2024-06-05T12:00:00Z", End: "2024-06-05T11:00:00Z", Words: 100},
+				session("2024-05-31T23:00:00Z", 60, 2000, 3),
+				// older than the month history
+				session("2023-06-30T23:00:00Z", 10, 250, 0),
+				{Start: "broken", End: "2024-06-05T11:00:00Z", Words: 1000},
+			},
+		},
+	}
+
+	want := Summary{
+		Periods: []Period{
+			{Name: Today, Sessions: 2, Seconds: 1800, Words: 600, Pages: 2, Finished: 1},
+			{Name: ThisWeek, Sessions: 3, Seconds: 2400, Words: 850, Pages: 3, Finished: 1},
+			{Name: ThisMonth, Sessions: 4, Seconds: 3600, Words: 1850, Pages: 7, Finished: 1},
+			{Name: AllTime, Sessions: 8, Seconds: 7800, Words: 4100, Pages: 16, Finished: 2},
+		},
+		Days: periods(HistoryDays, "2006-01-02", func(i int) time.Time { return today.AddDate(0, 0, -i) }),
+		Weeks: periods(HistoryWeeks, "2006-01-02", func(i int) time.Time {
+			return time.Date(2024, 6, 3-7*i, 0, 0, 0, 0, time.UTC)
+		}),
+		Months: periods(HistoryMonths, "2006-01", func(i int) time.Time {
+			return time.Date(2024, time.Month(6-i), 1, 0, 0, 0, 0, time.UTC)
+		}),
+		WordsPerMinute: 4100.0 * 60 / 7800,
+	}
+	want.Days[0] = Period{Name: "2024-06-05", Sessions: 2, Seconds: 1800, Words: 600, Pages: 2, Finished: 1}
+	want.Days[2] = Period{Name: "2024-06-03", Sessions: 1, Seconds: 600, Words: 250, Pages: 1}
+	want.Days[3] = Period{Name: "2024-06-02", Sessions: 1, Seconds: 1200, Words: 1000, Pages: 4}
+	want.Days[5] = Period{Name: "2024-05-31", Sessions: 3, Seconds: 3600, Words: 2000, Pages: 8}
+	want.Weeks[0] = Period{Name: "2024-06-03", Sessions: 3, Seconds: 2400, Words: 850, Pages: 3, Finished: 1}
+	want.Weeks[1] = Period{Name: "2024-05-27", Sessions: 4, Seconds: 4800, Words: 3000, Pages: 12}
+	want.Weeks[3] = Period{Name: "2024-05-13", Finished: 1}
+	want.Months[0] = Period{Name: "2024-06", Sessions: 4, Seconds: 3600, Words: 1850, Pages: 7, Finished: 1}
+	want.Months[1] = Period{Name: "2024-05", Sessions: 3, Seconds: 3600, Words: 2000, Pages: 8, Finished: 1}
+
+	sum := Collect(lib, now)
+	if !reflect.DeepEqual(sum, want) {
+		t.Errorf("Collect:\n%+v\nwant:\n%+v", sum, want)
+	}
+	if total := sum.Total(); total != want.Periods[3] {
+		t.Errorf("Total() = %+v, want %+v", total, want.Periods[3])
+	}
+}
+
+func TestCollectEmpty(t *testing.T) {
+	// a week that starts on Monday includes Sunday as its last day
+	now := time.Date(2024, 6, 9, 23, 0, 0, 0, time.UTC)
+	sum := Collect(&library{books: []common.BookRecord{{FilePath: "a"}}}, now)
+	if sum.WordsPerMinute != 0 || sum.Total().Sessions != 0 {
+		t.Errorf("empty library statistics %+v", sum)
+	}
+	if sum.Days[0].Name != "2024-06-09" || sum.Weeks[0].Name != "2024-06-03" || sum.Months[11].Name != "2023-07" {
+		t.Errorf("periods start at %s, %s and %s, want 2024-06-09, 2024-06-03 and 2023-07",
+			sum.Days[0].Name, sum.Weeks[0].Name, sum.Months[11].Name)
+	}
+}
+
+func TestTimeLeft(t *testing.T) {
+	tests := []struct {
+		speed float64
+		words int
+		left  time.Duration
+	}{
+		{0, 1000, 0},
+		{200, 0, 0},
+		{200, 1000, 5 * time.Minute},
+		{250, 100, 24 * time.Second},
+	}
+	for _, test := range tests {
+		sum := Summary{WordsPerMinute: test.speed}
+		if left := sum.TimeLeft(test.words); left != test.left {
+			t.Errorf("TimeLeft(%d) at %v words per minute = %v, want %v", test.words, test.speed, left, test.left)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		text string
+	}{
+		{0, "0m"},
+		{29 * time.Second, "0m"},
+		{30 * time.Second, "1m"},
+		{40 * time.Minute, "40m"},
+		{59*time.Minute + 31*time.Second, "1h"},
+		{6 * time.Hour, "6h"},
+		{2*time.Hour + 5*time.Minute, "2h05m"},
+		{27*time.Hour + 50*time.Minute, "27h50m"},
+	}
+	for _, test := range tests {
+		if text := FormatDuration(test.d); text != test.text {
+			t.Errorf("FormatDuration(%v) = %q, want %q", test.d, text, test.text)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"github.com/VladimirMarkelov/termfb2/common"
+	cf "github.com/VladimirMarkelov/termfb2/config"
+	"strconv"
+	"strings"
+)
+
+// ratingText shows the book rating as stars
+func ratingText(rating int) string {
+	return strings.Repeat("*", rating)
+}
+
+// selectedBook returns the book selected in the library dialog
+func selectedBook(controls *ControlList, conf *cf.Config) (common.BookRecord, bool) {
+	row := controls.bookTable.SelectedRow()
+	filtered := conf.DbDriver.FilteredBooks()
+	if row < 0 || row >= len(filtered) {
+		return common.BookRecord{}, false
+	}
+	return filtered[row], true
+}
+
+// createTagsDialog asks for a comma separated list of tags of the
+// selected book. An empty list removes all tags
+func createTagsDialog(controls *ControlList, conf *cf.Config) {
+	b, ok := selectedBook(controls, conf)
+	if !ok {
+		return
+	}
+
+	createInputDialog("Tags (comma separated)", common.JoinTags(b.Tags), func(text string) {
+		if err := conf.DbDriver.SetTags(b.FilePath, common.ParseTags(text)); err != nil {
+			showError("Library error", fmt.Sprintf("Failed to change tags of book '%s': %v", b.Title, err))
+		}
+		// the book may disappear from the filtered list
+		controls.bookTable.SetRowCount(len(conf.DbDriver.FilteredBooks()))
+	})
+}
+
+// createRatingDialog asks for a new rating of the selected book
+func createRatingDialog(controls *ControlList, conf *cf.Config) {
+	b, ok := selectedBook(controls, conf)
+	if !ok {
+		return
+	}
+
+	title := fmt.Sprintf("Rating (0-%d, 0 - not rated)", common.MAX_RATING)
+	createInputDialog(title, strconv.Itoa(b.Rating), func(text string) {
+		rating, err := strconv.Atoi(strings.TrimSpace(text))
+		if err != nil || rating < 0 || rating > common.MAX_RATING {
+			showError("Error", fmt.Sprintf("Invalid rating '%s': enter a number from 0 to %d", text, common.MAX_RATING))
+			return
+		}
+		if err := conf.DbDriver.SetRating(b.FilePath, rating); err != nil {
+			showError("Library error", fmt.Sprintf("Failed to change rating of book '%s': %v", b.Title, err))
+		}
+	})
+}
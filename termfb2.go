@@ -4,13 +4,14 @@ import (
 	"flag"
 	"fmt"
 	ui "github.com/VladimirMarkelov/clui"
-	fbutils "github.com/VladimirMarkelov/fb2text"
+	"github.com/VladimirMarkelov/termfb2/book"
 	"github.com/VladimirMarkelov/termfb2/common"
 	cf "github.com/VladimirMarkelov/termfb2/config"
 	xs "github.com/huandu/xstrings"
 	term "github.com/nsf/termbox-go"
 	"os"
 	path "path/filepath"
+	"strings"
 	"unicode/utf8"
 )
 
@@ -47,6 +48,19 @@ type ControlList struct {
 	askLabel  *ui.Label
 	askRemove *ui.Button
 	askCancel *ui.Button
+
+	// The bookmark list of the opened book - used only if book library is ON
+	bookmarkWindow *ui.Window
+	bookmarkTable  *ui.TableView
+
+	// The table of contents of the opened book
+	tocWindow *ui.Window
+	tocTable  *ui.TableView
+
+	// the difference between the terminal width and the reader width.
+	// It is used to calculate the new reader width when the terminal
+	// is resized
+	readerMargin int
 }
 
 // createView creates the main Window - a book reader view
@@ -55,11 +69,7 @@ func createView(controls *ControlList, conf *cf.Config) {
 	controls.mainWindow.SetPack(ui.Vertical)
 
 	controls.mainWindow.OnKeyDown(func(ev ui.Event, data interface {}) bool {
-		if ev.Key == term.KeyF2 && conf.UseDb {
-			createBookListDialog(controls, conf)
-			return true
-		}
-		return false
+		return runReaderAction(controls, conf, conf.ActionForKey(cf.CtxReader, ev.Key, ev.Ch))
 	}, nil)
 	controls.reader = ui.CreateTextReader(controls.mainWindow, minWidth, minHeight, 1)
 	controls.reader.SetTextColor(conf.TextColor)
@@ -67,6 +77,13 @@ func createView(controls *ControlList, conf *cf.Config) {
 	ui.ActivateControl(controls.mainWindow, controls.reader)
 	controls.mainWindow.SetMaximized(true)
 	controls.mainWindow.SetModal(true)
+
+	cw, _ := term.Size()
+	rw, _ := controls.reader.Size()
+	controls.readerMargin = cw - rw
+	controls.mainWindow.OnScreenResize(func(ev ui.Event) {
+		reflowBook(controls, conf, ev.Width-controls.readerMargin)
+	})
 }
 
 func mainLoop(controls *ControlList, conf *cf.Config) {
@@ -82,46 +99,70 @@ func getBookColumnText(book common.BookRecord, col int) string {
 	case 1:
 		text = book.Title
 	case 2:
-		if book.LineTotal == 0 {
-			text = "0%"
-		} else {
-			text = fmt.Sprintf("%v%%", book.LineLast*100/book.LineTotal)
-		}
+		text = fmt.Sprintf("%v%%", book.Percent())
 	case 3:
-		text = book.Sequence
+		text = ratingText(book.Rating)
 	case 4:
-		text = book.Genre
+		text = common.JoinTags(book.Tags)
 	case 5:
-		text = book.Added
+		text = sequenceText(book)
 	case 6:
-		text = book.Completed
+		text = book.Genre
 	case 7:
+		text = book.Added
+	case 8:
+		text = book.Completed
+	case 9:
 		text = book.FilePath
 	}
 
 	return text
 }
 
-// Opens a book that is currently selected in TableView (book library)
-func loadBook(controls *ControlList, conf *cf.Config) {
-	var lines []string
-	row := controls.bookTable.SelectedRow()
-	b := conf.DbDriver.FilteredBooks()[row]
+// Opens a library book in the reader at its saved position. It returns
+// false if the book cannot be opened
+func loadBook(controls *ControlList, conf *cf.Config, b common.BookRecord) bool {
 	fileName := b.FilePath
 
-	conf.Info, lines = fbutils.ParseBook(fileName, true)
+	bk, err := book.ParseBook(fileName)
+	if err != nil {
+		showError("Error", fmt.Sprintf("Failed to open book '%s': %v", fileName, err))
+		if len(bk.Paragraphs) == 0 {
+			return false
+		}
+	}
+	conf.Book = bk
+	conf.Info = conf.Book.Info
+	identifyBook(conf, fileName)
+	indexBook(conf, fileName)
+	conf.SelectedPara = -1
+	conf.LinkHistory = nil
+	conf.SearchText = ""
+	conf.Matches = nil
+	conf.CurrentMatch = -1
 	width, _ := controls.reader.Size()
-	conf.Lines = fbutils.FormatBook(lines, width, conf.Justify)
-	lastPosition := b.LineLast
+	formatBook(conf, width)
 	conf.LastLength = len(conf.Lines)
 	conf.LastFile = fileName
 
-	if lastPosition >= conf.LastLength {
-		lastPosition = conf.LastLength - 1
-	}
 	controls.reader.SetLineCount(conf.LastLength)
-	conf.LastPosition = lastPosition
+	conf.LastPosition = restoreBookPosition(conf, b)
+	updateReadingSpeed(conf)
+	startSession(conf)
 	controls.reader.SetTopLine(conf.LastPosition)
+	return true
+}
+
+// openBook opens the library book in the reader unless it is already
+// opened. It returns false if the book cannot be opened
+func openBook(controls *ControlList, conf *cf.Config, b common.BookRecord) bool {
+	if b.FilePath == conf.LastFile {
+		return true
+	}
+	if err := closeBook(conf); err != nil {
+		showError("Library error", fmt.Sprintf("Failed to save reading position: %v", err))
+	}
+	return loadBook(controls, conf, b)
 }
 
 // Creates a confirmation dialog to use it when asking about
@@ -162,6 +203,16 @@ func createBookConfirm(controls *ControlList, conf *cf.Config) {
 	})
 }
 
+// bookListTitle generates the library dialog title: the current filter
+// and whether only books with missing files are shown
+func bookListTitle(conf *cf.Config) string {
+	title := fmt.Sprintf("Book list [%s]", conf.DbDriver.Filter())
+	if conf.DbDriver.MissingOnly() {
+		title += " - missing files"
+	}
+	return title
+}
+
 // Creates and shows a book library dialog - available only if
 // library is ON
 func createBookListDialog(controls *ControlList, conf *cf.Config) {
@@ -176,13 +227,14 @@ func createBookListDialog(controls *ControlList, conf *cf.Config) {
 	controls.bookTable.SetShowRowNumber(true)
 	controls.bookListWindow.SetMaximized(true)
 
-	controls.bookTable.SetRowCount(len(conf.DbDriver.FilteredBooks()))
-	controls.bookListWindow.SetTitle(fmt.Sprintf("Book list [%s]", conf.DbDriver.Filter()))
+	refreshBookList(controls, conf)
 
 	cols := []ui.Column{
 		ui.Column{Title: "Author", Width: 16, Alignment: ui.AlignLeft},
 		ui.Column{Title: "Title", Width: 25, Alignment: ui.AlignLeft},
 		ui.Column{Title: "Done", Width: 4, Alignment: ui.AlignRight},
+		ui.Column{Title: "Rating", Width: 6, Alignment: ui.AlignLeft},
+		ui.Column{Title: "Tags", Width: 12, Alignment: ui.AlignLeft},
 		ui.Column{Title: "Sequence", Width: 8, Alignment: ui.AlignLeft},
 		ui.Column{Title: "Genre", Width: 8, Alignment: ui.AlignLeft},
 		ui.Column{Title: "Added", Width: 20, Alignment: ui.AlignLeft},
@@ -195,39 +247,72 @@ func createBookListDialog(controls *ControlList, conf *cf.Config) {
 	// opening selected book by pressing Enter
 	// Escape closes the dialog without doing anything
 	controls.bookListWindow.OnKeyDown(func(ev ui.Event, data interface {}) bool {
+		switch conf.ActionForKey(cf.CtxLibrary, ev.Key, ev.Ch) {
+		case cf.ActClose:
+			go ui.PutEvent(ui.Event{Type: ui.EventCloseWindow})
+			return true
+		case cf.ActRelocate:
+			createRelocateDialog(controls, conf)
+			return true
+		case cf.ActImport:
+			createImportDialog(controls, conf)
+			return true
+		case cf.ActTags:
+			createTagsDialog(controls, conf)
+			return true
+		case cf.ActRating:
+			createRatingDialog(controls, conf)
+			return true
+		case cf.ActFindText:
+			createFindTextDialog(controls, conf)
+			return true
+		case cf.ActTree:
+			createTreeDialog(controls, conf)
+			return true
+		case cf.ActMissing:
+			conf.DbDriver.SetMissingOnly(!conf.DbDriver.MissingOnly())
+			refreshBookList(controls, conf)
+			return true
+		case cf.ActSort:
+			sortBookList(controls, conf)
+			return true
+		case cf.ActUnsort:
+			unsortBookList(controls, conf)
+			return true
+		case cf.ActDelete:
+			askRemoveBook(controls, conf, controls.bookTable.SelectedRow())
+			return true
+		case cf.ActHelp:
+			createHelpDialog(conf, cf.CtxLibrary)
+			return true
+		case cf.ActOpen:
+			row := controls.bookTable.SelectedRow()
+			if row != -1 {
+				openBook(controls, conf, conf.DbDriver.FilteredBooks()[row])
+			}
+			go ui.PutEvent(ui.Event{Type: ui.EventCloseWindow})
+			return true
+		}
+
 		if ev.Ch != 0 {
 			filter := conf.DbDriver.Filter() + string(ev.Ch)
 			conf.DbDriver.SetFilter(filter)
-
-			controls.bookTable.SetRowCount(len(conf.DbDriver.FilteredBooks()))
-			controls.bookListWindow.SetTitle(fmt.Sprintf("Book list [%s]", filter))
+			refreshBookList(controls, conf)
 			return true
 		}
 
 		switch ev.Key {
-		case term.KeyBackspace:
+		case term.KeyBackspace, term.KeyBackspace2:
 			filter := conf.DbDriver.Filter()
 			if filter != "" {
 				filter = xs.Slice(filter, 0, xs.Len(filter)-1)
 				conf.DbDriver.SetFilter(filter)
-
-				controls.bookTable.SetRowCount(len(conf.DbDriver.FilteredBooks()))
-				controls.bookListWindow.SetTitle(fmt.Sprintf("Book list [%s]", filter))
+				refreshBookList(controls, conf)
 			}
 			return true
-		case term.KeyEsc:
-			go ui.PutEvent(ui.Event{Type: ui.EventCloseWindow})
-			return true
-		case term.KeyEnter:
-			row := controls.bookTable.SelectedRow()
-			if row != -1 {
-				book := conf.DbDriver.FilteredBooks()[row]
-				if book.FilePath != conf.LastFile {
-					closeBook(conf)
-					loadBook(controls, conf)
-				}
-			}
-			go ui.PutEvent(ui.Event{Type: ui.EventCloseWindow})
+		case term.KeyF4, term.KeyDelete, term.KeyInsert:
+			// built-in keys of the table are disabled, so sorting and
+			// removing books work only with the configured keys
 			return true
 		}
 		return false
@@ -253,85 +338,154 @@ func createBookListDialog(controls *ControlList, conf *cf.Config) {
 		book := filtered[row]
 		controls.bookInfoDetail.SetTitle(getBookColumnText(book, col))
 	})
+}
 
-	// override it to do custom sorting and delete a book from library
-	controls.bookTable.OnAction(func(ev ui.TableEvent) {
-		filtered := conf.DbDriver.FilteredBooks()
-		if ev.Action == ui.TableActionDelete {
-			if ev.Row == -1 {
-				return
-			}
+// refreshBookList updates the library dialog after the filter or the
+// book list changes. An invalid filter query and library errors are
+// explained in the statusbar at the bottom of the dialog
+func refreshBookList(controls *ControlList, conf *cf.Config) {
+	controls.bookTable.SetRowCount(len(conf.DbDriver.FilteredBooks()))
+	controls.bookListWindow.SetTitle(bookListTitle(conf))
+	if err := conf.DbDriver.FilterError(); err != nil {
+		controls.bookInfoDetail.SetTitle("Invalid filter: " + err.Error())
+	} else if err := conf.DbDriver.ListError(); err != nil {
+		controls.bookInfoDetail.SetTitle("Failed to read the library: " + err.Error())
+	} else {
+		controls.bookInfoDetail.SetTitle("")
+	}
+}
 
-			book := filtered[ev.Row]
-			controls.bookListWindow.SetModal(false)
-			controls.askLabel.SetTitle(fmt.Sprintf("Information about book <c:bright green>'%s'<c:> will be removed from the library. Continue?", book.Title))
-			controls.askWindow.SetModal(true)
-			controls.askWindow.SetVisible(true)
-			ui.ActivateControl(controls.askWindow, controls.askCancel)
+// askRemoveBook asks for confirmation and removes the book from the library
+func askRemoveBook(controls *ControlList, conf *cf.Config, row int) {
+	filtered := conf.DbDriver.FilteredBooks()
+	if row < 0 || row >= len(filtered) {
+		return
+	}
 
-			controls.askRemove.OnClick(func(evBtn ui.Event) {
-				conf.DbDriver.DeleteBookByIndex(ev.Row)
-				controls.bookTable.SetRowCount(controls.bookTable.RowCount() - 1)
+	book := filtered[row]
+	controls.bookListWindow.SetModal(false)
+	controls.askLabel.SetTitle(fmt.Sprintf("Information about book <c:bright green>'%s'<c:> will be removed from the library. Continue?", book.Title))
+	controls.askWindow.SetModal(true)
+	controls.askWindow.SetVisible(true)
+	ui.ActivateControl(controls.askWindow, controls.askCancel)
 
-				controls.askWindow.SetModal(false)
-				controls.askWindow.SetVisible(false)
-				ui.ActivateControl(controls.bookListWindow, controls.bookTable)
-			})
+	controls.askRemove.OnClick(func(evBtn ui.Event) {
+		err := conf.DbDriver.DeleteBookByIndex(row)
+		controls.bookTable.SetRowCount(len(conf.DbDriver.FilteredBooks()))
 
-			return
+		controls.askWindow.SetModal(false)
+		controls.askWindow.SetVisible(false)
+		ui.ActivateControl(controls.bookListWindow, controls.bookTable)
+		if err != nil {
+			showError("Library error", fmt.Sprintf("Failed to remove book: %v", err))
 		}
+	})
+}
 
-		if ev.Action != ui.TableActionSort {
-			return
-		}
+// sortBookList changes the sort mode of the selected column in a cycle:
+// ascending, descending, off. If sort mode is off, the default sorting is
+// used: the best matches first in fuzzy filter mode, otherwise by author
+func sortBookList(controls *ControlList, conf *cf.Config) {
+	col := controls.bookTable.SelectedCol()
+	cols := controls.bookTable.Columns()
+	if col < 0 || col >= len(cols) {
+		return
+	}
 
-		if ev.Col == -1 {
-			return
-		}
-		fields := []string{
-			common.FIELD_AUTHOR,
-			common.FIELD_TITLE,
-			common.FIELD_PERCENT,
-			"",
-			common.FIELD_GENRE,
-			common.FIELD_ADDED,
-			common.FIELD_COMPLETED,
-		}
+	order := ui.SortAsc
+	switch cols[col].Sort {
+	case ui.SortAsc:
+		order = ui.SortDesc
+	case ui.SortDesc:
+		order = ui.SortNone
+	}
+	for i := range cols {
+		cols[i].Sort = ui.SortNone
+	}
+	cols[col].Sort = order
+	controls.bookTable.SetColumns(cols)
 
-		if ev.Sort == ui.SortNone || ev.Col >= len(fields) {
-			conf.DbDriver.SetSortMode(common.FIELD_AUTHOR, true)
-			return
-		}
+	fields := []string{
+		common.FIELD_AUTHOR,
+		common.FIELD_TITLE,
+		common.FIELD_PERCENT,
+		common.FIELD_RATING,
+		common.FIELD_TAGS,
+		common.FIELD_SEQUENCE,
+		common.FIELD_GENRE,
+		common.FIELD_ADDED,
+		common.FIELD_COMPLETED,
+	}
 
-		field := fields[ev.Col]
-		if field == "" {
-			conf.DbDriver.SetSortMode(common.FIELD_AUTHOR, ev.Sort == ui.SortAsc)
-		} else {
-			conf.DbDriver.SetSortMode(field, ev.Sort == ui.SortAsc)
-		}
-	})
+	if order == ui.SortNone || col >= len(fields) {
+		conf.DbDriver.SetSortMode(conf.DefaultSort())
+		return
+	}
+
+	conf.DbDriver.SetSortMode(fields[col], order == ui.SortAsc)
 }
 
-// closeBook saves the current reading progress to a database and file
-func closeBook(conf *cf.Config) {
+// unsortBookList turns sorting off for all columns, so the book list
+// returns to the default order whichever column is selected
+func unsortBookList(controls *ControlList, conf *cf.Config) {
+	cols := controls.bookTable.Columns()
+	for i := range cols {
+		cols[i].Sort = ui.SortNone
+	}
+	controls.bookTable.SetColumns(cols)
+	conf.DbDriver.SetSortMode(conf.DefaultSort())
+}
+
+// closeBook saves the current reading progress and session to a database
+// and file
+func closeBook(conf *cf.Config) error {
+	err := saveBookPosition(conf)
 	conf.SaveLastFileInfo()
+	if err != nil || !conf.UseDb || conf.LastFile == "" || conf.LastLength == 0 {
+		return err
+	}
+	// the book is added to the library by the update, so the session
+	// of a new book can be saved only after it
+	return saveSession(conf)
+}
 
-	if conf.UseDb && conf.LastFile != "" && conf.LastLength != 0 {
-		var brec common.BookRecord
-		brec.FirstName = conf.Info.FirstName
-		brec.LastName = conf.Info.LastName
-		brec.Title = conf.Info.Title
-		brec.Language = conf.Info.Language
-		brec.Sequence = conf.Info.Sequence
-		brec.Genre = conf.Info.Genre
+// saveBookPosition writes the reading position of the opened book to the
+// library and adds the book to the library if it is not there yet. Unlike
+// closeBook, it does not touch the reading session
+func saveBookPosition(conf *cf.Config) error {
+	if conf.LastPosition < len(conf.Lines) {
+		pos := conf.Lines[conf.LastPosition].Position()
+		conf.LastPara = pos.Para
+		conf.LastOffset = pos.Offset
+	}
+	if !conf.UseDb || conf.LastFile == "" || conf.LastLength == 0 {
+		return nil
+	}
 
-		conf.DbDriver.UpdateBookInDb(conf.LastFile, conf.LastPosition, conf.LastLength, &brec)
+	var brec common.BookRecord
+	brec.FirstName = conf.Info.FirstName
+	brec.LastName = conf.Info.LastName
+	brec.Title = conf.Info.Title
+	brec.Language = conf.Info.Language
+	brec.Sequence = conf.Info.Sequence
+	brec.SeqNumber = conf.Info.SeqNumber
+	brec.Genre = conf.Info.Genre
+	brec.Hash = conf.BookHash
+	brec.DocId = conf.Info.Id
+
+	pos := common.Position{
+		Line:      conf.LastPosition,
+		Total:     conf.LastLength,
+		Para:      conf.LastPara,
+		Offset:    conf.LastOffset,
+		ParaTotal: conf.Book.TextEnd().Para,
 	}
+	return conf.DbDriver.UpdateBookInDb(conf.LastFile, pos, &brec)
 }
 
 // titleForBook generates a short description of a book by its full info
 // Used to show it in a reader title
-func titleForBook(info fbutils.BookInfo) string {
+func titleForBook(info book.Info) string {
 	var s string
 
 	if info.FirstName != "" {
@@ -345,12 +499,10 @@ func titleForBook(info fbutils.BookInfo) string {
 	return s
 }
 
-// getFilenameFromArgs looks for a file name in the argument list and
-// generates a full path for the book if the path is not absolute
-func getFilenameFromArgs(conf *cf.Config) string {
-	flag.Parse()
-
-	fileName := flag.Arg(0)
+// getFilenameFromArgs generates a full path for the book from the command
+// line if the path is not absolute
+func getFilenameFromArgs(conf *cf.Config, args openArgs) string {
+	fileName := args.fileName
 	if fileName != "" && !path.IsAbs(fileName) {
 		currDir, _ := os.Getwd()
 		if currDir != conf.BinPath {
@@ -368,9 +520,13 @@ func main() {
 	var controls ControlList
 	conf := cf.InitConfig()
 
+	// subcommands do not start UI and exit after they finish
+	flag.Parse()
+	args := runCommand(conf, flag.Args())
+
 	// read the last book file name from the configuration file
 	// in case of argument list is empty
-	fileName := getFilenameFromArgs(conf)
+	fileName := getFilenameFromArgs(conf, args)
 	lastFileConf := conf.LastFile
 	if fileName == "" {
 		fileName = conf.LastFile
@@ -378,66 +534,81 @@ func main() {
 		conf.LastFile = fileName
 	}
 
-	// read book database if it is ON
-	if conf.UseDb {
-		conf.InitDatabase()
-		conf.DbDriver.ReadDatabase()
-	}
+	// read book database if it is ON. The error is shown after UI starts
+	dbErr := conf.InitDatabase()
 
 	var width int
 	ui.InitLibrary()
-	defer ui.DeinitLibrary()
 
 	// create reader and format a book to fit the reader width
 	createView(&controls, conf)
 	createBookConfirm(&controls, conf)
 	width, _ = controls.reader.Size()
+	if dbErr != nil {
+		showError("Library error", dbErr.Error())
+	}
+	if len(conf.ConfigErrors) != 0 {
+		showError("Configuration error", strings.Join(conf.ConfigErrors, "\n"))
+	}
 
-	var lines []string
-    absFileName, _ := path.Abs(fileName)
-	conf.Info, lines = fbutils.ParseBook(absFileName, true)
-	conf.Lines = fbutils.FormatBook(lines, width, conf.Justify)
+	absFileName, _ := path.Abs(fileName)
+	var err error
+	conf.Book, err = book.ParseBook(absFileName)
+	if err != nil && fileName != "" {
+		showError("Error", fmt.Sprintf("Failed to open book '%s': %v", fileName, err))
+	}
+	conf.Info = conf.Book.Info
+	if fileName != "" {
+		identifyBook(conf, fileName)
+		indexBook(conf, fileName)
+	}
+	formatBook(conf, width)
+	updateReadingSpeed(conf)
 
 	// restore the book position to the latest saved one
 	// it is read from the last file info and database
-	if conf.LastLength > 0 && conf.LastLength != len(conf.Lines) {
-		conf.LastPosition = conf.LastPosition * len(conf.Lines) / conf.LastLength
-	}
-	if fileName != "" && lastFileConf != conf.LastFile && conf.UseDb {
-		b, found := conf.DbDriver.BookByFilePath(fileName)
-		if found {
-			conf.LastPosition = b.LineLast
-			conf.LastLength = b.LineTotal
-		} else {
-			conf.LastPosition = 0
+	conf.LastPosition = restorePosition(conf, conf.LastPara, conf.LastOffset, conf.LastPosition, conf.LastLength)
+	if fileName != "" && lastFileConf != conf.LastFile {
+		conf.LastPosition = 0
+		if conf.UseDb {
+			b, found := conf.DbDriver.BookByFilePath(fileName)
+			if found {
+				conf.LastPosition = restoreBookPosition(conf, b)
+			}
 		}
 	}
+	// the position from the command line overrides the saved one
+	if args.para >= 0 {
+		conf.LastPosition = book.FindLine(conf.Lines, book.Position{Para: args.para})
+	}
 
 	savedPos := conf.LastPosition
 	controls.reader.SetLineCount(len(conf.Lines))
+	startSession(conf)
 
 	// override OnPositionChanged to update the current positon and
 	// percent read for an opened book
 	controls.reader.OnPositionChanged(func(topLine int, totalLines int) {
+		trackSession(&controls, conf, conf.LastPosition, topLine)
 		conf.LastPosition = topLine
 		conf.LastLength = totalLines
-
-		topLine++
-		winTitle := fmt.Sprintf("[%v%%] [%v/%v] %s",
-			int(topLine*100/totalLines),
-			topLine, totalLines, titleForBook(conf.Info))
-		controls.mainWindow.SetTitle(winTitle)
+		updateTitle(&controls, conf)
 	})
 
 	controls.reader.SetTopLine(savedPos)
 	controls.reader.SetLineCount(len(conf.Lines))
 	controls.reader.OnDrawLine(func(ind int) string {
-		return conf.Lines[ind]
+		return renderLine(conf, ind)
 	})
 
 	// start UI loop
 	mainLoop(&controls, conf)
 
 	// save the current position and the last book file name on app close
-	closeBook(conf)
+	err = closeBook(conf)
+	ui.DeinitLibrary()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save reading position: %v\n", err)
+		os.Exit(1)
+	}
 }
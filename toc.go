@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	ui "github.com/VladimirMarkelov/clui"
+	"github.com/VladimirMarkelov/termfb2/book"
+	cf "github.com/VladimirMarkelov/termfb2/config"
+	xs "github.com/huandu/xstrings"
+	term "github.com/nsf/termbox-go"
+	"strings"
+)
+
+const chapterTitleLength = 30
+
+// currentChapter returns the index of the table of contents item that
+// contains the top line of the reader
+func currentChapter(conf *cf.Config) int {
+	if conf.LastPosition >= len(conf.Lines) {
+		return -1
+	}
+	return conf.Book.Chapter(conf.Lines[conf.LastPosition].Para)
+}
+
+// chapterTitle returns a short title of the chapter displayed in the
+// reader title
+func chapterTitle(conf *cf.Config) string {
+	idx := currentChapter(conf)
+	if idx == -1 {
+		return ""
+	}
+
+	title := conf.Book.Toc[idx].Title
+	if xs.Len(title) > chapterTitleLength {
+		title = xs.Slice(title, 0, chapterTitleLength-1) + "…"
+	}
+	return title
+}
+
+// Generate a text for TableView control that displays table of contents
+func getTocColumnText(conf *cf.Config, item book.TocItem, col int) string {
+	text := ""
+	switch col {
+	case 0:
+		text = strings.Repeat("  ", item.Level) + item.Title
+	case 1:
+		line := book.FindLine(conf.Lines, book.Position{Para: item.Para})
+		text = fmt.Sprintf("%v%%", (line+1)*100/len(conf.Lines))
+	}
+
+	return text
+}
+
+// Creates and shows the table of contents of the opened book
+func createTocDialog(controls *ControlList, conf *cf.Config) {
+	if len(conf.Book.Toc) == 0 {
+		return
+	}
+
+	controls.tocWindow = ui.AddWindow(0, 0, 12, 7, "Contents")
+	controls.tocWindow.SetPack(ui.Vertical)
+	controls.tocWindow.SetModal(true)
+
+	controls.tocTable = ui.CreateTableView(controls.tocWindow, minWidth, minHeight, 1)
+	ui.ActivateControl(controls.tocWindow, controls.tocTable)
+	controls.tocTable.SetShowLines(true)
+	controls.tocWindow.SetMaximized(true)
+
+	cols := []ui.Column{
+		ui.Column{Title: "Title", Width: 60, Alignment: ui.AlignLeft},
+		ui.Column{Title: "Done", Width: 4, Alignment: ui.AlignRight},
+	}
+	controls.tocTable.SetColumns(cols)
+	controls.tocTable.SetRowCount(len(conf.Book.Toc))
+	if idx := currentChapter(conf); idx != -1 {
+		controls.tocTable.SetSelectedRow(idx)
+	}
+
+	// Enter jumps to the selected chapter
+	// Escape closes the dialog without doing anything
+	controls.tocWindow.OnKeyDown(func(ev ui.Event, data interface{}) bool {
+		switch ev.Key {
+		case term.KeyEsc:
+			go ui.PutEvent(ui.Event{Type: ui.EventCloseWindow})
+			return true
+		case term.KeyEnter:
+			row := controls.tocTable.SelectedRow()
+			if row != -1 && row < len(conf.Book.Toc) {
+				pos := book.Position{Para: conf.Book.Toc[row].Para}
+				controls.reader.SetTopLine(book.FindLine(conf.Lines, pos))
+			}
+			go ui.PutEvent(ui.Event{Type: ui.EventCloseWindow})
+			return true
+		}
+		return false
+	}, nil)
+
+	controls.tocTable.OnDrawCell(func(info *ui.ColumnDrawInfo) {
+		if info.Row >= len(conf.Book.Toc) {
+			return
+		}
+		info.Text = getTocColumnText(conf, conf.Book.Toc[info.Row], info.Col)
+	})
+}